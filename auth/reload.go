@@ -0,0 +1,78 @@
+// Package auth secures the connector gRPC server: TLS and mTLS with certificates that are
+// reloaded when the mounted files are rotated, bearer token authentication, and the identity
+// of the caller for audit records.
+package auth
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloader holds a value loaded from files and loads it again once any of them is modified,
+// like a Kubernetes secret that is rotated. If the new files can't be loaded, e.g. because
+// the certificate was updated before its key, the previous value is kept.
+type reloader struct {
+	paths []string
+	load  func() (interface{}, error)
+
+	mtx      sync.Mutex
+	value    interface{}
+	modTimes []time.Time
+}
+
+// newReloader loads the value for the first time
+func newReloader(load func() (interface{}, error), paths ...string) (*reloader, error) {
+	r := &reloader{paths: paths, load: load}
+	modTimes, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if r.value, err = load(); err != nil {
+		return nil, err
+	}
+	r.modTimes = modTimes
+	return r, nil
+}
+
+func (r *reloader) stat() ([]time.Time, error) {
+	modTimes := make([]time.Time, len(r.paths))
+	for i, path := range r.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// get returns the value, reloading it first if the files have been modified
+func (r *reloader) get() interface{} {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	modTimes, err := r.stat()
+	if err != nil || equalTimes(modTimes, r.modTimes) {
+		return r.value
+	}
+	value, err := r.load()
+	if err != nil {
+		log.Printf("failed to reload %v, keeping the previous version: %s", r.paths, err)
+		return r.value
+	}
+	log.Printf("reloaded %v", r.paths)
+	r.value = value
+	r.modTimes = modTimes
+	return r.value
+}
+
+func equalTimes(a, b []time.Time) bool {
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rewrite writes data to path and moves its modification time forward, so that the change is
+// seen on file systems with a coarse modification time
+func rewrite(t *testing.T, path, data string, at time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	path := writeFile(t, "token", "v1")
+	loads := 0
+	r, err := newReloader(func() (interface{}, error) {
+		loads++
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return nil, errors.New("empty")
+		}
+		return string(data), nil
+	}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.get(); got != "v1" || loads != 1 {
+		t.Fatalf("get = %v after %d loads, want v1 loaded once", got, loads)
+	}
+
+	// an unchanged file is not loaded again
+	r.get()
+	if loads != 1 {
+		t.Errorf("%d loads of an unchanged file, want 1", loads)
+	}
+
+	now := time.Now()
+	rewrite(t, path, "v2", now.Add(time.Minute))
+	if got := r.get(); got != "v2" {
+		t.Errorf("get = %v after the file changed, want v2", got)
+	}
+
+	// a file that can't be loaded keeps the previous value, and is loaded again once it changes
+	rewrite(t, path, "", now.Add(2*time.Minute))
+	if got := r.get(); got != "v2" {
+		t.Errorf("get = %v after an invalid change, want v2", got)
+	}
+	rewrite(t, path, "v3", now.Add(3*time.Minute))
+	if got := r.get(); got != "v3" {
+		t.Errorf("get = %v after the file was fixed, want v3", got)
+	}
+
+	// a removed file keeps the previous value
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.get(); got != "v3" {
+		t.Errorf("get = %v after the file was removed, want v3", got)
+	}
+}
+
+func TestTokenAuthenticatorReloadsToken(t *testing.T) {
+	path := writeFile(t, "token", "old")
+	a, err := NewTokenAuthenticator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrite(t, path, "new\n", time.Now().Add(time.Minute))
+	if got := a.token.get(); got != "new" {
+		t.Errorf("token = %v after rotation, want new", got)
+	}
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// ServerTLSConfig returns the TLS config of a server presenting the certificate and key of
+// certFile and keyFile. If clientCAFile is set, clients must present a certificate signed by
+// one of its CAs (mTLS). The files are reloaded when they change, so rotated certificates are
+// used for new connections without a restart.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	certs, err := newReloader(func() (interface{}, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		return &cert, err
+	}, certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certs.get().(*tls.Certificate), nil
+		},
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	cas, err := newReloader(func() (interface{}, error) {
+		return loadCertPool(clientCAFile)
+	}, clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client CAs: %w", err)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	// the client CAs are only consulted for each handshake through a per-connection config
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = cas.get().(*x509.CertPool)
+		return c, nil
+	}
+	return config, nil
+}
+
+// ClientTLSConfig returns the TLS config of a client that verifies the server with the CAs of
+// caFile, or the system roots if it is empty, and presents the certificate and key of certFile
+// and keyFile if they are set
+func ClientTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
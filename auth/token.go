@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// healthService is not authenticated, so that probes keep working without a token
+const healthService = "/grpc.health.v1.Health/"
+
+// tokenAuthenticatedKey marks the context of a call that presented a valid bearer token
+type tokenAuthenticatedKey struct{}
+
+// TokenAuthenticator checks the bearer token of unary calls against the token of a file,
+// which is reloaded when it changes
+type TokenAuthenticator struct {
+	token *reloader
+}
+
+// NewTokenAuthenticator reads the token of tokenFile, surrounding whitespace is ignored
+func NewTokenAuthenticator(tokenFile string) (*TokenAuthenticator, error) {
+	token, err := newReloader(func() (interface{}, error) {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return nil, fmt.Errorf("token file %s is empty", tokenFile)
+		}
+		return token, nil
+	}, tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token: %w", err)
+	}
+	return &TokenAuthenticator{token: token}, nil
+}
+
+// UnaryInterceptor rejects calls without the `authorization: Bearer <token>` metadata with
+// Unauthenticated. The health service is exempt.
+func (a *TokenAuthenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthService) {
+			return handler(ctx, req)
+		}
+		if err := a.authenticate(ctx); err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, tokenAuthenticatedKey{}, true), req)
+	}
+}
+
+func (a *TokenAuthenticator) authenticate(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if token != value && subtle.ConstantTimeCompare([]byte(token), []byte(a.token.get().(string))) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+// CallerIdentity describes the caller of a grpc call for audit records: its address, the
+// subject of its client certificate if it presented one, and whether it was authenticated
+// by bearer token
+func CallerIdentity(ctx context.Context) string {
+	parts := make([]string, 0, 3)
+	if p, ok := peer.FromContext(ctx); ok {
+		parts = append(parts, "addr="+p.Addr.String())
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			parts = append(parts, "cert="+tlsInfo.State.VerifiedChains[0][0].Subject.String())
+		}
+	}
+	if authenticated, _ := ctx.Value(tokenAuthenticatedKey{}).(bool); authenticated {
+		parts = append(parts, "token=valid")
+	}
+	if len(parts) == 0 {
+		return "unknown"
+	}
+	return strings.Join(parts, " ")
+}
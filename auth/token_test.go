@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// writeFile writes data to the file name of a temporary directory removed at the end of the test
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	a, err := NewTokenAuthenticator(writeFile(t, "token", "s3cret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := a.UnaryInterceptor()
+	tests := []struct {
+		name          string
+		method        string
+		authorization []string
+		wantCode      codes.Code
+	}{
+		{"valid token", "/connector.v1.ConnectorService/SetPayload", []string{"Bearer s3cret"}, codes.OK},
+		{"valid token among others", "/connector.v1.ConnectorService/SetPayload", []string{"Bearer wrong", "Bearer s3cret"}, codes.OK},
+		{"invalid token", "/connector.v1.ConnectorService/SetPayload", []string{"Bearer wrong"}, codes.Unauthenticated},
+		{"token without Bearer", "/connector.v1.ConnectorService/SetPayload", []string{"s3cret"}, codes.Unauthenticated},
+		{"token with its newline", "/connector.v1.ConnectorService/SetPayload", []string{"Bearer s3cret\n"}, codes.Unauthenticated},
+		{"no token", "/connector.v1.ConnectorService/GetPayload", nil, codes.Unauthenticated},
+		{"health without token", "/grpc.health.v1.Health/Check", nil, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != nil {
+				md := metadata.MD{}
+				md.Append("authorization", tt.authorization...)
+				ctx = metadata.NewIncomingContext(ctx, md)
+			}
+			var caller string
+			_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				caller = CallerIdentity(ctx)
+				return nil, nil
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("interceptor = %v, want %s", err, tt.wantCode)
+			}
+			if tt.wantCode == codes.OK && tt.authorization != nil && caller != "token=valid" {
+				t.Errorf("caller = %q, want token=valid", caller)
+			}
+		})
+	}
+}
+
+func TestNewTokenAuthenticatorEmptyToken(t *testing.T) {
+	if _, err := NewTokenAuthenticator(writeFile(t, "token", " \n")); err == nil {
+		t.Error("NewTokenAuthenticator accepted an empty token")
+	}
+}
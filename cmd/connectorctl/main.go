@@ -0,0 +1,367 @@
+// Command connectorctl calls the ConnectorService gRPC API of a running connector: it lists
+// and sets its streams and config, and prints its events.
+//
+//	connectorctl [-addr localhost:8000] [-connectorInstanceID id] <command> [arguments]
+//
+// The commands are:
+//
+//	streams                   list the streams of the connector
+//	config                    print the dynamic config of the connector
+//	set file...               replace the streams and config with those of the files
+//	events [-follow]          print the events of the connector
+//
+// The files of `set` are stream and config objects of the KPS API, like the ones in samples/
+// and deploy/. Since SetPayload replaces all streams, every stream that should keep running
+// must be passed.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-template/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	addr        = flag.String("addr", "localhost:8000", "address of the connector gRPC server")
+	connectorID = flag.String("connectorInstanceID", os.Getenv("CONNECTOR_INSTANCE_ID"), "UUID of the connector instance, set files default to their connectorInstanceID")
+	timeout     = flag.Duration("timeout", 10*time.Second, "timeout of each call")
+
+	useTLS        = flag.Bool("tls", false, "connect with TLS, implied by the other -tls flags")
+	tlsCA         = flag.String("tlsCA", "", "CA file to verify the server with instead of the system roots")
+	tlsCert       = flag.String("tlsCert", "", "client certificate file for mTLS")
+	tlsKey        = flag.String("tlsKey", "", "private key file of the client certificate")
+	tlsServerName = flag.String("tlsServerName", "", "name to verify the server certificate against, defaults to the host of -addr")
+	tokenFile     = flag.String("tokenFile", os.Getenv("CONNECTOR_TOKEN_FILE"), "file holding the bearer token to authenticate with")
+)
+
+// tokenCredentials sends the bearer token with every call
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (c tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// dialOptions configures TLS and the bearer token of the connection from the flags
+func dialOptions() ([]grpc.DialOption, error) {
+	secure := *useTLS || *tlsCA != "" || *tlsCert != "" || *tlsKey != "" || *tlsServerName != ""
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if secure {
+		tlsConfig, err := auth.ClientTLSConfig(*tlsCA, *tlsCert, *tlsKey, *tlsServerName)
+		if err != nil {
+			return nil, err
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}
+	}
+	if *tokenFile != "" {
+		data, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: strings.TrimSpace(string(data)), secure: secure}))
+	}
+	return opts, nil
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: connectorctl [flags] <command> [arguments]
+
+commands:
+  streams              list the streams of the connector
+  config               print the dynamic config of the connector
+  set file...          replace the streams and config with those of the KPS stream and config files
+  events [-follow]     print the events of the connector
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	opts, err := dialOptions()
+	if err != nil {
+		log.Fatalf("invalid connection flags: %s", err)
+	}
+	conn, err := grpc.Dial(*addr, opts...)
+	if err != nil {
+		log.Fatalf("failed to connect to %s: %s", *addr, err)
+	}
+	defer conn.Close()
+	client := connectorpb.NewConnectorServiceClient(conn)
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	switch command {
+	case "streams":
+		err = listStreams(client)
+	case "config":
+		err = printConfig(client)
+	case "set":
+		err = setPayload(client, args)
+	case "events":
+		err = printEvents(client, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s: %s", command, err)
+	}
+}
+
+func callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), *timeout)
+}
+
+// checkStatus returns the error reported in a response status
+func checkStatus(status *connectorpb.ResponseStatus) error {
+	if status.GetCode() != connectorpb.ResponseCode_RESPONSE_CODE_OK {
+		return fmt.Errorf("%s: %s", status.GetCode(), status.GetMessage())
+	}
+	return nil
+}
+
+func getPayload(client connectorpb.ConnectorServiceClient, kind connectorpb.PayloadKind) ([]*connectorpb.Payload, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	resp, err := client.GetPayload(ctx, &connectorpb.GetPayloadRequest{ConnectorId: *connectorID, Kind: kind})
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetPayloads(), checkStatus(resp.GetStatus())
+}
+
+func listStreams(client connectorpb.ConnectorServiceClient) error {
+	payloads, err := getPayload(client, connectorpb.PayloadKind_PAYLOAD_KIND_STREAM)
+	if err != nil {
+		return err
+	}
+	if len(payloads) == 0 {
+		fmt.Println("no streams")
+	}
+	for _, payload := range payloads {
+		stream := payload.GetStream()
+		metadata, err := indentJSON(stream.GetMetadata())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n  direction: %s\n  channel:   %s\n  metadata:  %s\n",
+			stream.GetId(), directionName(stream.GetDirection()), stream.GetTransportChannel(), strings.ReplaceAll(metadata, "\n", "\n  "))
+	}
+	return nil
+}
+
+func printConfig(client connectorpb.ConnectorServiceClient) error {
+	payloads, err := getPayload(client, connectorpb.PayloadKind_PAYLOAD_KIND_CONFIG)
+	if err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		config, err := indentJSON(payload.GetConfig().GetMetadata())
+		if err != nil {
+			return err
+		}
+		fmt.Println(config)
+	}
+	return nil
+}
+
+// kpsObject is a stream or config object of the KPS API
+type kpsObject struct {
+	Name                string                 `json:"name"`
+	ConnectorInstanceID string                 `json:"connectorInstanceID"`
+	Direction           string                 `json:"direction"`
+	Stream              map[string]interface{} `json:"stream"`
+	Config              map[string]interface{} `json:"config"`
+}
+
+func setPayload(client connectorpb.ConnectorServiceClient, files []string) error {
+	if len(files) == 0 {
+		return fmt.Errorf("no stream or config files given")
+	}
+	req := &connectorpb.SetPayloadRequest{ConnectorId: *connectorID}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var obj kpsObject
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return fmt.Errorf("invalid file %s: %w", file, err)
+		}
+		if req.ConnectorId == "" {
+			req.ConnectorId = obj.ConnectorInstanceID
+		}
+		payload, err := objectToPayload(&obj)
+		if err != nil {
+			return fmt.Errorf("invalid file %s: %w", file, err)
+		}
+		req.Payloads = append(req.Payloads, payload)
+	}
+
+	ctx, cancel := callContext()
+	defer cancel()
+	resp, err := client.SetPayload(ctx, req)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp.GetStatus()); err != nil {
+		return err
+	}
+	fmt.Printf("set %d payloads on connector %s\n", len(req.Payloads), req.ConnectorId)
+	return nil
+}
+
+// objectToPayload translates a KPS stream or config object to the payload KPS sends for it.
+// The stream is named after the object and uses its name as transport channel.
+func objectToPayload(obj *kpsObject) (*connectorpb.Payload, error) {
+	switch {
+	case obj.Stream != nil:
+		if obj.Name == "" {
+			return nil, fmt.Errorf("stream has no name")
+		}
+		direction, ok := connectorpb.StreamDirection_value["STREAM_DIRECTION_"+strings.ToUpper(obj.Direction)]
+		if !ok {
+			return nil, fmt.Errorf("unknown direction %q", obj.Direction)
+		}
+		metadata, err := structpb.NewStruct(obj.Stream)
+		if err != nil {
+			return nil, err
+		}
+		stream := &connectorpb.Stream{
+			Id:               obj.Name,
+			Direction:        connectorpb.StreamDirection(direction),
+			TransportChannel: obj.Name,
+			Metadata:         metadata,
+		}
+		return &connectorpb.Payload{Object: &connectorpb.Payload_Stream{Stream: stream}}, nil
+	case obj.Config != nil:
+		metadata, err := structpb.NewStruct(obj.Config)
+		if err != nil {
+			return nil, err
+		}
+		return &connectorpb.Payload{Object: &connectorpb.Payload_Config{Config: &connectorpb.Config{Metadata: metadata}}}, nil
+	}
+	return nil, fmt.Errorf("neither a stream nor a config")
+}
+
+func printEvents(client connectorpb.ConnectorServiceClient, args []string) error {
+	flags := flag.NewFlagSet("events", flag.ExitOnError)
+	follow := flags.Bool("follow", false, "keep polling and print events as they are published")
+	interval := flags.Duration("interval", 2*time.Second, "polling interval with -follow")
+	_ = flags.Parse(args)
+
+	// the connector keeps the last event of each kind and stream, an event is printed when it
+	// is new or has changed since the last poll
+	seen := make(map[string]*connectorpb.EventPayload)
+	for {
+		ctx, cancel := callContext()
+		resp, err := client.GetEvents(ctx, &connectorpb.GetEventsRequest{ConnectorId: *connectorID})
+		cancel()
+		if err != nil {
+			return err
+		}
+		if err := checkStatus(resp.GetStatus()); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		events := resp.GetEventPayloads()
+		sort.Slice(events, func(i, j int) bool { return eventKey(events[i]) < eventKey(events[j]) })
+		for _, event := range events {
+			key := eventKey(event)
+			if last, ok := seen[key]; ok && proto.Equal(last, event) {
+				continue
+			}
+			seen[key] = event
+			fmt.Println(formatEvent(now, event))
+		}
+
+		if !*follow {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func eventKey(event *connectorpb.EventPayload) string {
+	if alert := event.GetAlert(); alert != nil {
+		return "alert/" + alert.GetStreamId() + "/" + alert.GetId()
+	}
+	status := event.GetStatus()
+	return "status/" + status.GetStreamId() + "/" + status.GetId()
+}
+
+// formatEvent renders an event on one line: time, kind, severity or state, stream, name and
+// message, followed by the error message and extra metadata of the event
+func formatEvent(now time.Time, event *connectorpb.EventPayload) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s ", now.Format("2006-01-02T15:04:05"))
+	var metadata *structpb.Struct
+	if alert := event.GetAlert(); alert != nil {
+		fmt.Fprintf(&b, "ALERT  %-8s stream=%s %s: %s", strings.TrimPrefix(alert.GetSeverity().String(), "SEVERITY_"),
+			alert.GetStreamId(), alert.GetId(), alert.GetMessage())
+		metadata = alert.GetMetadata()
+	} else {
+		status := event.GetStatus()
+		fmt.Fprintf(&b, "STATUS %-8s stream=%s %s: %s", strings.TrimPrefix(status.GetState().String(), "STATE_"),
+			status.GetStreamId(), status.GetId(), status.GetMessage())
+		metadata = status.GetMetadata()
+	}
+
+	fields := metadata.AsMap()
+	if msg, ok := fields["ErrorMessage"].(string); ok && msg != "" {
+		fmt.Fprintf(&b, " (%s)", msg)
+	}
+	if extra, ok := fields["ExtraMessage"].(map[string]interface{}); ok {
+		keys := make([]string, 0, len(extra))
+		for key := range extra {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, _ := json.Marshal(extra[key])
+			fmt.Fprintf(&b, " %s=%s", key, value)
+		}
+	}
+	return b.String()
+}
+
+func directionName(direction connectorpb.StreamDirection) string {
+	return strings.ToLower(strings.TrimPrefix(direction.String(), "STREAM_DIRECTION_"))
+}
+
+func indentJSON(s *structpb.Struct) (string, error) {
+	data, err := json.MarshalIndent(s.AsMap(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
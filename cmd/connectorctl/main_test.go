@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestObjectToPayload(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		// direction and metadata are those of the expected stream, config the metadata of the
+		// expected config, and err the error of an invalid object
+		direction connectorpb.StreamDirection
+		metadata  map[string]interface{}
+		config    map[string]interface{}
+		err       string
+	}{
+		{name: "ingress stream",
+			doc:       `{"name": "press", "direction": "ingress", "stream": {"plc": "modbus:tcp://10.0.0.1:502"}}`,
+			direction: connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS,
+			metadata:  map[string]interface{}{"plc": "modbus:tcp://10.0.0.1:502"}},
+		{name: "egress stream",
+			doc:       `{"name": "press", "direction": "EGRESS", "stream": {"modbus-server": {"port": 5502}}}`,
+			direction: connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS,
+			metadata:  map[string]interface{}{"modbus-server": map[string]interface{}{"port": 5502.0}}},
+		{name: "config",
+			doc:    `{"config": {"log_level": "debug"}}`,
+			config: map[string]interface{}{"log_level": "debug"}},
+		{name: "stream without name", doc: `{"direction": "ingress", "stream": {}}`, err: "stream has no name"},
+		{name: "unknown direction", doc: `{"name": "press", "direction": "both", "stream": {}}`, err: `unknown direction "both"`},
+		{name: "neither", doc: `{"name": "press"}`, err: "neither a stream nor a config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var obj kpsObject
+			if err := json.Unmarshal([]byte(tt.doc), &obj); err != nil {
+				t.Fatal(err)
+			}
+			payload, err := objectToPayload(&obj)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("objectToPayload = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.config != nil {
+				if got := payload.GetConfig().GetMetadata().AsMap(); !reflect.DeepEqual(got, tt.config) {
+					t.Errorf("config = %v, want %v", got, tt.config)
+				}
+				return
+			}
+			// the stream is named after the object and published on a channel of its name
+			stream := payload.GetStream()
+			if stream.GetId() != "press" || stream.GetTransportChannel() != "press" || stream.GetDirection() != tt.direction {
+				t.Errorf("stream = %v, want press %s on channel press", stream, tt.direction)
+			}
+			if got := stream.GetMetadata().AsMap(); !reflect.DeepEqual(got, tt.metadata) {
+				t.Errorf("metadata = %v, want %v", got, tt.metadata)
+			}
+		})
+	}
+}
+
+func TestFormatEvent(t *testing.T) {
+	now := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	metadata, err := structpb.NewStruct(map[string]interface{}{
+		"ErrorMessage": "connection refused",
+		"StreamId":     "s1",
+		"ExtraMessage": map[string]interface{}{"key": "plc", "suppressed": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		event *connectorpb.EventPayload
+		want  string
+	}{
+		{"alert", &connectorpb.EventPayload{Object: &connectorpb.EventPayload_Alert{Alert: &connectorpb.Alert{
+			Id: "plcConnectRefused", StreamId: "s1", Message: "PLC refused the connection",
+			Severity: connectorpb.Severity_SEVERITY_CRITICAL, Metadata: metadata,
+		}}}, `2021-03-01T08:00:00 ALERT  CRITICAL stream=s1 plcConnectRefused: PLC refused the connection (connection refused) key="plc" suppressed=3`},
+		{"status without metadata", &connectorpb.EventPayload{Object: &connectorpb.EventPayload_Status{Status: &connectorpb.Status{
+			Id: "streamHealthy", StreamId: "s1", Message: "stream is healthy", State: connectorpb.State_STATE_HEALTHY,
+		}}}, `2021-03-01T08:00:00 STATUS HEALTHY  stream=s1 streamHealthy: stream is healthy`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatEvent(now, tt.event); got != tt.want {
+				t.Errorf("formatEvent =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
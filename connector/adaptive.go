@@ -0,0 +1,92 @@
+package connector
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultMaxAdaptiveInterval is the ceiling of `adaptive-polling` without `max-interval`
+	defaultMaxAdaptiveInterval = 1 * time.Minute
+	// defaultAdaptiveFactor is the factor of `adaptive-polling` without `backoff-factor`
+	defaultAdaptiveFactor = 2.0
+	// maxAdaptiveFactor bounds `backoff-factor`
+	maxAdaptiveFactor = 10.0
+)
+
+// adaptiveInterval scales the polling interval of a stream with `adaptive-polling`. Every poll
+// that fails to publish a message multiplies the interval by the factor, up to the ceiling.
+// Every poll that publishes values different from the previous ones divides it, down to the
+// floor, if the stream sets one. Other polls move it one step back toward the polling interval
+// of the stream, the baseline. The scale is relative to the baseline, so that a changed
+// polling interval takes effect right away.
+type adaptiveInterval struct {
+	config AdaptivePolling
+
+	mtx   sync.Mutex
+	scale float64
+	// digest is the digest of the values of the last message published, see valuesDigest
+	digest    uint64
+	hasDigest bool
+}
+
+func newAdaptiveInterval(config AdaptivePolling) *adaptiveInterval {
+	return &adaptiveInterval{config: config, scale: 1}
+}
+
+// interval returns the polling interval in effect for the baseline
+func (a *adaptiveInterval) interval(baseline time.Duration) time.Duration {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.clamp(baseline)
+}
+
+// clamp bounds the scale by the floor and the ceiling and returns the interval it yields,
+// a.mtx must be held
+func (a *adaptiveInterval) clamp(baseline time.Duration) time.Duration {
+	floor, ceiling := baseline, baseline
+	if a.config.MinInterval > 0 && a.config.MinInterval < baseline {
+		floor = a.config.MinInterval
+	}
+	if a.config.MaxInterval > baseline {
+		ceiling = a.config.MaxInterval
+	}
+	a.scale = math.Max(float64(floor)/float64(baseline), math.Min(a.scale, float64(ceiling)/float64(baseline)))
+	return time.Duration(float64(baseline) * a.scale)
+}
+
+// update adapts the interval to the outcome of a poll, published is set if it published a
+// message whose values have digest. It returns the intervals before and after, and why it changed.
+func (a *adaptiveInterval) update(baseline time.Duration, published bool, digest uint64) (time.Duration, time.Duration, string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	from := a.clamp(baseline)
+	var reason string
+	switch {
+	case !published:
+		a.scale *= a.config.Factor
+		reason = "backoff"
+	case a.config.MinInterval > 0 && a.hasDigest && digest != a.digest:
+		a.scale /= a.config.Factor
+		reason = "change"
+	case a.scale > 1:
+		a.scale = math.Max(a.scale/a.config.Factor, 1)
+		reason = "recovered"
+	case a.scale < 1:
+		a.scale = math.Min(a.scale*a.config.Factor, 1)
+		reason = "settled"
+	}
+	if published {
+		a.digest, a.hasDigest = digest, true
+	}
+	return from, a.clamp(baseline), reason
+}
+
+// valuesDigest returns the digest the values of a message are compared by
+func valuesDigest(data []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return h.Sum64()
+}
@@ -0,0 +1,97 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// poll is the outcome of a poll: failed, or published with the values of digest
+type poll struct {
+	failed bool
+	digest uint64
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	const ms = time.Millisecond
+	config := AdaptivePolling{MinInterval: 200 * ms, MaxInterval: 5 * time.Second, Factor: 2}
+	failed := poll{failed: true}
+	tests := []struct {
+		name     string
+		config   AdaptivePolling
+		baseline time.Duration
+		polls    []poll
+		want     []time.Duration
+		reasons  []string
+	}{
+		{"backoff clamped at the ceiling", config, time.Second,
+			[]poll{failed, failed, failed, failed},
+			[]time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+			[]string{"backoff", "backoff", "backoff", "backoff"}},
+		{"recovers to the baseline", config, time.Second,
+			[]poll{failed, failed, failed, {digest: 1}, {digest: 1}, {digest: 1}, {digest: 1}},
+			[]time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 2500 * ms, 1250 * ms, time.Second, time.Second},
+			[]string{"backoff", "backoff", "backoff", "recovered", "recovered", "recovered", ""}},
+		{"changes clamped at the floor", config, time.Second,
+			[]poll{{digest: 1}, {digest: 2}, {digest: 3}, {digest: 4}, {digest: 5}},
+			[]time.Duration{time.Second, 500 * ms, 250 * ms, 200 * ms, 200 * ms},
+			[]string{"", "change", "change", "change", "change"}},
+		{"settles to the baseline", config, time.Second,
+			[]poll{{digest: 1}, {digest: 2}, {digest: 3}, {digest: 3}, {digest: 3}, {digest: 3}},
+			[]time.Duration{time.Second, 500 * ms, 250 * ms, 500 * ms, time.Second, time.Second},
+			[]string{"", "change", "change", "settled", "settled", ""}},
+		{"recovery overshooting the baseline", AdaptivePolling{MaxInterval: 5 * time.Second, Factor: 3}, time.Second,
+			[]poll{failed, {digest: 1}, failed, failed, {digest: 1}, {digest: 1}},
+			[]time.Duration{3 * time.Second, time.Second, 3 * time.Second, 5 * time.Second, 5 * time.Second / 3, time.Second},
+			[]string{"backoff", "recovered", "backoff", "backoff", "recovered", "recovered"}},
+		{"no floor without min-interval", AdaptivePolling{MaxInterval: 5 * time.Second, Factor: 2}, time.Second,
+			[]poll{{digest: 1}, {digest: 2}},
+			[]time.Duration{time.Second, time.Second},
+			[]string{"", ""}},
+		{"floor above the baseline", AdaptivePolling{MinInterval: 2 * time.Second, MaxInterval: 5 * time.Second, Factor: 2}, time.Second,
+			[]poll{{digest: 1}, {digest: 2}},
+			[]time.Duration{time.Second, time.Second},
+			[]string{"", "change"}},
+		{"ceiling below the baseline", config, 10 * time.Second,
+			[]poll{failed, failed},
+			[]time.Duration{10 * time.Second, 10 * time.Second},
+			[]string{"backoff", "backoff"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAdaptiveInterval(tt.config)
+			var got []time.Duration
+			var reasons []string
+			for _, p := range tt.polls {
+				_, to, reason := a.update(tt.baseline, !p.failed, p.digest)
+				got = append(got, to)
+				reasons = append(reasons, reason)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("intervals %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(reasons, tt.reasons) {
+				t.Errorf("reasons %q, want %q", reasons, tt.reasons)
+			}
+		})
+	}
+}
+
+func TestAdaptiveIntervalFollowsBaseline(t *testing.T) {
+	a := newAdaptiveInterval(AdaptivePolling{MinInterval: 200 * time.Millisecond, MaxInterval: 5 * time.Second, Factor: 2})
+	a.update(time.Second, false, 0)
+	if got := a.interval(time.Second); got != 2*time.Second {
+		t.Fatalf("interval = %s, want 2s", got)
+	}
+	// the scale applies to a changed polling interval, bounded by the ceiling
+	if got := a.interval(2 * time.Second); got != 4*time.Second {
+		t.Errorf("interval of a 2s baseline = %s, want 4s", got)
+	}
+	if got := a.interval(4 * time.Second); got != 5*time.Second {
+		t.Errorf("interval of a 4s baseline = %s, want 5s", got)
+	}
+	// the clamped scale holds when the baseline goes back
+	if got := a.interval(time.Second); got != 1250*time.Millisecond {
+		t.Errorf("interval of a 1s baseline = %s, want 1.25s", got)
+	}
+}
@@ -0,0 +1,101 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-template/auth"
+)
+
+// auditRecord is logged for every SetPayload call, whether it succeeded or not
+type auditRecord struct {
+	Time        string `json:"time"`
+	Caller      string `json:"caller"`
+	ConnectorID string `json:"connectorId"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+	// Added and Removed are stream IDs, Changed lists what differs per stream, see streamChanges
+	Added   []string            `json:"added,omitempty"`
+	Removed []string            `json:"removed,omitempty"`
+	Changed map[string][]string `json:"changed,omitempty"`
+	// Config lists the keys of the dynamic config that are set, changed or removed
+	Config []string `json:"config,omitempty"`
+}
+
+// newAuditRecord describes the changes that setting streams and configs makes to the running
+// connector. It must be created before they are applied.
+func (d *Connector) newAuditRecord(ctx context.Context, connectorID string, streams []*connectorpb.Stream, configs []*connectorpb.Config) *auditRecord {
+	record := &auditRecord{
+		Time:        time.Now().UTC().Format(time.RFC3339Nano),
+		Caller:      auth.CallerIdentity(ctx),
+		ConnectorID: connectorID,
+		Changed:     make(map[string][]string),
+	}
+
+	d.mtx.RLock()
+	current := make(map[string]*connectorpb.Stream, len(d.streams))
+	for _, stream := range d.streams {
+		current[stream.GetId()] = stream
+	}
+	d.mtx.RUnlock()
+
+	requested := make(map[string]bool, len(streams))
+	for _, stream := range streams {
+		requested[stream.GetId()] = true
+		old, ok := current[stream.GetId()]
+		if !ok {
+			record.Added = append(record.Added, stream.GetId())
+		} else if changes := streamChanges(old, stream); len(changes) > 0 {
+			record.Changed[stream.GetId()] = changes
+		}
+	}
+	for streamID := range current {
+		if !requested[streamID] {
+			record.Removed = append(record.Removed, streamID)
+		}
+	}
+	sort.Strings(record.Removed)
+
+	// a config payload replaces the config, so keys it doesn't set are changed as well
+	if len(configs) > 0 {
+		config := mergeConfigs(configs)
+		ConnectorCfg.RLock()
+		for k, v := range config {
+			if !reflect.DeepEqual(ConnectorCfg.dynamicConfig[k], v) {
+				record.Config = append(record.Config, k)
+			}
+		}
+		for k := range ConnectorCfg.dynamicConfig {
+			if _, ok := config[k]; !ok {
+				record.Config = append(record.Config, k)
+			}
+		}
+		ConnectorCfg.RUnlock()
+	}
+	sort.Strings(record.Config)
+	return record
+}
+
+// log writes the record with the outcome of the call to the log
+func (r *auditRecord) log(err error) {
+	r.Result = "ok"
+	if err != nil {
+		r.Result = "error"
+		r.Error = err.Error()
+	}
+	auditLogger.WithField("audit", r).Info("audit")
+}
+
+// String formats the record as JSON for the text log format, the JSON log format nests it
+func (r *auditRecord) String() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf("failed to encode audit record: %s", err)
+	}
+	return string(data)
+}
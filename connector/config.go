@@ -2,6 +2,7 @@ package connector
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
@@ -12,9 +13,29 @@ import (
 type Config struct {
 	Name string
 	ID   string
+	// MetricsPort is the port of the HTTP server serving `/metrics`, `/healthz` and `/readyz`, 0 disables it
+	MetricsPort int
+	// TLSCertFile and TLSKeyFile enable TLS on the grpc server, TLSClientCAFile requires client
+	// certificates signed by its CAs. The files are reloaded when they are rotated.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	// AuthTokenFile holds the bearer token grpc calls must present, empty disables token authentication
+	AuthTokenFile string
+	// StateDir is the directory the accepted streams and config are saved to and restored from
+	// on startup, empty disables it
+	StateDir string
+	// TraceExporter is where spans are exported to: `none`, `stdout` or `otlp`, empty is `none`
+	TraceExporter string
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the `otlp` trace exporter
+	OTLPEndpoint string
+	// LogFormat is the format of the logs, `text` or `json`, empty is `text`
+	LogFormat string
 
 	sync.RWMutex
+	// dynamicConfig is the config payload as received, dynamic is its typed form in effect
 	dynamicConfig map[string]interface{}
+	dynamic       *dynamicConfig
 }
 
 var (
@@ -23,6 +44,7 @@ var (
 		// TODO: Set the name for the Connector
 		Name:          "plc4xconnector",
 		dynamicConfig: make(map[string]interface{}),
+		dynamic:       defaultDynamicConfig(),
 	}
 )
 
@@ -48,12 +70,22 @@ func (d *Connector) getConfig(context.Context) (*connectorpb.GetPayloadResponse,
 	return resp, nil
 }
 
-func (d *Connector) updateConfig(ctx context.Context, configs []*connectorpb.Config) {
+// updateConfig replaces the dynamic config with the config payloads. It leaves the config
+// unchanged if there are none, and rejects configs that don't match the schema.
+func (d *Connector) updateConfig(ctx context.Context, configs []*connectorpb.Config) error {
+	if len(configs) == 0 {
+		return nil
+	}
+	config := mergeConfigs(configs)
+	dynamic, err := mapToDynamicConfig(config)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
 	ConnectorCfg.Lock()
 	defer ConnectorCfg.Unlock()
-	for _, config := range configs {
-		for k, v := range config.GetMetadata().AsMap() {
-			ConnectorCfg.dynamicConfig[k] = v
-		}
-	}
+	ConnectorCfg.dynamicConfig = config
+	ConnectorCfg.dynamic = dynamic
+	dynamic.apply()
+	return nil
 }
@@ -3,21 +3,25 @@ package connector
 import (
 	"context"
 	"fmt"
-	"log"
 	"sync"
 
 	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
 	"github.com/nutanix/kps-connector-go-sdk/events"
-	"github.com/nutanix/kps-connector-go-sdk/transport"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // Connector implements the ConnectorService gRPC service
 type Connector struct {
-	mtx              sync.RWMutex
-	id               string
-	streams          []*connectorpb.Stream
-	activeInStreams  map[string]context.CancelFunc
-	activeOutStreams map[string]transport.Subscription
+	mtx        sync.RWMutex
+	id         string
+	streams    []*connectorpb.Stream
+	supervisor *supervisor
+
+	// payloadMtx serializes applying the payloads of KPS and of the state directory,
+	// payloadSet is set once KPS has sent a payload, so that a late restore doesn't undo it
+	payloadMtx sync.Mutex
+	payloadSet bool
 
 	// Registry implements the `GetEvents` method
 	*events.Registry
@@ -30,11 +34,10 @@ var _ connectorpb.ConnectorServiceServer = (*Connector)(nil)
 func NewConnector() *Connector {
 	registry := events.NewRegistry()
 	d := &Connector{
-		id:               ConnectorCfg.ID,
-		streams:          make([]*connectorpb.Stream, 0),
-		activeInStreams:  make(map[string]context.CancelFunc),
-		activeOutStreams: make(map[string]transport.Subscription),
-		Registry:         registry,
+		id:         ConnectorCfg.ID,
+		streams:    make([]*connectorpb.Stream, 0),
+		supervisor: newSupervisor(),
+		Registry:   registry,
 	}
 
 	d.initEventRegistry()
@@ -66,13 +69,9 @@ func (d *Connector) GetPayload(ctx context.Context, req *connectorpb.GetPayloadR
 // SetPayload updates the payloads that connector needs to process:
 //   - If payload kind is set to STREAM, it should reset the topics being used by subscribing to the streams from the request and unsubscribing the old ones
 //   - If payload kind is set to CONFIG, it should update the current dynamic config
-func (d *Connector) SetPayload(ctx context.Context, req *connectorpb.SetPayloadRequest) (*connectorpb.SetPayloadResponse, error) {
-	if req.GetConnectorId() != d.id {
-		err := fmt.Errorf("wrong Connector id")
-		resp := &connectorpb.SetPayloadResponse{Status: &connectorpb.ResponseStatus{Code: connectorpb.ResponseCode_RESPONSE_CODE_INVALID_ARGUMENT, Message: err.Error()}}
-		return resp, err
-	}
-
+//
+// Every call is logged as an audit record with the caller and the changes it makes.
+func (d *Connector) SetPayload(ctx context.Context, req *connectorpb.SetPayloadRequest) (resp *connectorpb.SetPayloadResponse, err error) {
 	payloads := req.Payloads
 
 	configs := make([]*connectorpb.Config, 0)
@@ -83,16 +82,49 @@ func (d *Connector) SetPayload(ctx context.Context, req *connectorpb.SetPayloadR
 		} else if config := payload.GetConfig(); config != nil {
 			configs = append(configs, config)
 		} else {
-			log.Printf("payload is neither a config nor a stream: %+v", payload)
+			warnf("payload is neither a config nor a stream: %+v", payload)
 		}
 	}
 
-	d.updateConfig(ctx, configs)
+	record := d.newAuditRecord(ctx, req.GetConnectorId(), streams, configs)
+	defer func() { record.log(err) }()
 
+	if req.GetConnectorId() != d.id {
+		err := fmt.Errorf("wrong Connector id")
+		resp := &connectorpb.SetPayloadResponse{Status: &connectorpb.ResponseStatus{Code: connectorpb.ResponseCode_RESPONSE_CODE_INVALID_ARGUMENT, Message: err.Error()}}
+		return resp, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// reject the whole payload before applying any of it if a stream is misconfigured
+	for _, stream := range streams {
+		if _, err := parseStreamMetadata(stream); err != nil {
+			resp := &connectorpb.SetPayloadResponse{Status: &connectorpb.ResponseStatus{Code: connectorpb.ResponseCode_RESPONSE_CODE_INVALID_ARGUMENT, Message: err.Error()}}
+			return resp, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	d.payloadMtx.Lock()
+	defer d.payloadMtx.Unlock()
+	if err := d.updateConfig(ctx, configs); err != nil {
+		resp := &connectorpb.SetPayloadResponse{Status: &connectorpb.ResponseStatus{Code: connectorpb.ResponseCode_RESPONSE_CODE_INVALID_ARGUMENT, Message: err.Error()}}
+		return resp, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	d.payloadSet = true
 	if err := d.setStreams(ctx, streams); err != nil {
 		resp := &connectorpb.SetPayloadResponse{Status: &connectorpb.ResponseStatus{Code: connectorpb.ResponseCode_RESPONSE_CODE_INTERNAL, Message: err.Error()}}
 		return resp, err
 	}
+	// the payload is applied, failing to save it only costs the head start after a restart
+	if err := d.saveState(); err != nil {
+		warnf("failed to save state to %s: %s", ConnectorCfg.StateDir, err)
+	}
 
 	return &connectorpb.SetPayloadResponse{Status: &connectorpb.ResponseStatus{Code: connectorpb.ResponseCode_RESPONSE_CODE_OK}}, nil
 }
+
+// Shutdown stops all streams: ingress streams publish their in-flight message and close their
+// PLC connection, egress streams unsubscribe from the transport. It gives up once ctx is done.
+func (d *Connector) Shutdown(ctx context.Context) error {
+	return d.supervisor.shutdown(ctx)
+}
@@ -0,0 +1,220 @@
+package connector
+
+import (
+	"errors"
+	"math"
+	"net"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLogSamplePeriod is the default of `log_sample_period`
+const defaultLogSamplePeriod = time.Minute
+
+// errWritesDisarmed rejects the messages of egress streams while `writes_armed` is false
+var errWritesDisarmed = errors.New("writes are disarmed by the dynamic config")
+
+// dynamicConfig is the typed dynamic config of the connector, the `configParameterSchema` of
+// deploy/class_plc4x.json. A config payload replaces it as a whole, parameters it doesn't set
+// take their default. All parameters take effect without restarting the streams.
+type dynamicConfig struct {
+	// LogLevel is the minimum severity of logged messages, `log_level`
+	LogLevel logrus.Level
+	// LogSamplePeriod is the minimum time between two logs of a message repeated every poll
+	// cycle or every message of a stream, 0 logs all of them, `log_sample_period` in milliseconds
+	LogSamplePeriod time.Duration
+	// DefaultPollingInterval is used by ingress streams without `polling-interval`,
+	// `default_polling_interval` in milliseconds
+	DefaultPollingInterval time.Duration
+	// MaxReadsPerSecond limits the PLC reads of all ingress streams together, 0 is unlimited,
+	// `max_reads_per_second`
+	MaxReadsPerSecond float64
+	// MaxWritesPerSecond limits the messages written by all egress streams together, 0 is
+	// unlimited, `max_writes_per_second`
+	MaxWritesPerSecond float64
+	// Discovery allows the connector to report streams it discovered in GetPayload, `discovery`.
+	// The connector doesn't discover streams yet.
+	Discovery bool
+	// WritesArmed allows egress streams to write, messages are rejected while it is false,
+	// `writes_armed`
+	WritesArmed bool
+	// DeviceLimits bound the requests of all streams to each device, `max_requests_in_flight`
+	// and `min_request_gap` in milliseconds
+	DeviceLimits deviceLimits
+	// DeviceOverrides are the limits of single devices by their `host:port`, `device_limits`
+	DeviceOverrides map[string]deviceLimits
+	// ShareReads lets ingress streams that poll the same addresses at the same rate share
+	// their reads, `share_reads`, see sharedReadCache
+	ShareReads bool
+	// MessageEnvelope wraps the payloads of ingress streams in an envelope, `message_envelope`
+	MessageEnvelope bool
+}
+
+func defaultDynamicConfig() *dynamicConfig {
+	return &dynamicConfig{
+		LogLevel:               logrus.InfoLevel,
+		LogSamplePeriod:        defaultLogSamplePeriod,
+		DefaultPollingInterval: defaultPollingInterval,
+		WritesArmed:            true,
+		ShareReads:             true,
+		MessageEnvelope:        true,
+	}
+}
+
+// mapToDynamicConfig translates a config payload into a dynamicConfig, rejecting unknown
+// parameters and values that violate the schema
+func mapToDynamicConfig(config map[string]interface{}) (*dynamicConfig, error) {
+	cfg := defaultDynamicConfig()
+	for key := range config {
+		switch key {
+		case "log_level", "log_sample_period", "default_polling_interval", "max_reads_per_second", "max_writes_per_second", "discovery", "writes_armed",
+			"max_requests_in_flight", "min_request_gap", "device_limits", "share_reads",
+			"message_envelope":
+		default:
+			return nil, newMetadataError(key, "unknown config parameter")
+		}
+	}
+
+	if name, ok, err := getString(config, "log_level", ""); err != nil {
+		return nil, err
+	} else if ok {
+		level, err := parseLogLevel(name)
+		if err != nil {
+			return nil, newMetadataError("log_level", "%s", err)
+		}
+		cfg.LogLevel = level
+	}
+	if ms, ok, err := getInteger(config, "log_sample_period", "", 0, math.MaxInt32); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.LogSamplePeriod = time.Duration(ms) * time.Millisecond
+	}
+	if ms, ok, err := getInteger(config, "default_polling_interval", "", int(minPollingInterval/time.Millisecond), math.MaxInt32); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.DefaultPollingInterval = time.Duration(ms) * time.Millisecond
+	}
+	var err error
+	if cfg.MaxReadsPerSecond, err = getRate(config, "max_reads_per_second"); err != nil {
+		return nil, err
+	}
+	if cfg.MaxWritesPerSecond, err = getRate(config, "max_writes_per_second"); err != nil {
+		return nil, err
+	}
+	if discovery, ok, err := getBool(config, "discovery", ""); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.Discovery = discovery
+	}
+	if armed, ok, err := getBool(config, "writes_armed", ""); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.WritesArmed = armed
+	}
+	if share, ok, err := getBool(config, "share_reads", ""); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.ShareReads = share
+	}
+	if wrap, ok, err := getBool(config, "message_envelope", ""); err != nil {
+		return nil, err
+	} else if ok {
+		cfg.MessageEnvelope = wrap
+	}
+	if cfg.DeviceLimits, err = getDeviceLimits(config, "", deviceLimits{}); err != nil {
+		return nil, err
+	}
+	if cfg.DeviceOverrides, err = getDeviceOverrides(config, cfg.DeviceLimits); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// getDeviceLimits reads `max_requests_in_flight` and `min_request_gap`, those not set take
+// the value of defaults
+func getDeviceLimits(obj map[string]interface{}, path string, defaults deviceLimits) (deviceLimits, error) {
+	limits := defaults
+	if n, ok, err := getInteger(obj, "max_requests_in_flight", path, 0, math.MaxInt32); err != nil {
+		return limits, err
+	} else if ok {
+		limits.MaxInFlight = n
+	}
+	if ms, ok, err := getInteger(obj, "min_request_gap", path, 0, math.MaxInt32); err != nil {
+		return limits, err
+	} else if ok {
+		limits.MinGap = time.Duration(ms) * time.Millisecond
+	}
+	return limits, nil
+}
+
+// getDeviceOverrides reads `device_limits`, the limits of single devices by their `host:port`.
+// The limits a device doesn't set are those of all devices.
+func getDeviceOverrides(config map[string]interface{}, defaults deviceLimits) (map[string]deviceLimits, error) {
+	obj, ok := config["device_limits"]
+	if !ok {
+		return nil, nil
+	}
+	devicesMap, err := asObject(obj, "device_limits")
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]deviceLimits, len(devicesMap))
+	for device, obj := range devicesMap {
+		path := joinPath("device_limits", device)
+		if _, _, err := net.SplitHostPort(device); err != nil {
+			return nil, newMetadataError(path, "expected a device as host:port")
+		}
+		limitsMap, err := asObject(obj, path)
+		if err != nil {
+			return nil, err
+		}
+		for key := range limitsMap {
+			if key != "max_requests_in_flight" && key != "min_request_gap" {
+				return nil, newMetadataError(joinPath(path, key), "unknown device limit")
+			}
+		}
+		if overrides[device], err = getDeviceLimits(limitsMap, path, defaults); err != nil {
+			return nil, err
+		}
+	}
+	return overrides, nil
+}
+
+func getRate(config map[string]interface{}, key string) (float64, error) {
+	rate, _, err := getNumber(config, key, "")
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 {
+		return 0, newMetadataError(key, "expected a rate of at least 0, found %v", rate)
+	}
+	return rate, nil
+}
+
+// mergeConfigs merges the config payloads of one SetPayload call, later payloads win
+func mergeConfigs(configs []*connectorpb.Config) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, config := range configs {
+		for k, v := range config.GetMetadata().AsMap() {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// apply makes the parameters that are not read on use take effect
+func (cfg *dynamicConfig) apply() {
+	logger.SetLevel(cfg.LogLevel)
+	readLimiter.setRate(cfg.MaxReadsPerSecond)
+	writeLimiter.setRate(cfg.MaxWritesPerSecond)
+	schedulers.setLimits(cfg.DeviceLimits, cfg.DeviceOverrides)
+}
+
+// getDynamicConfig returns the dynamic config in effect
+func getDynamicConfig() dynamicConfig {
+	ConnectorCfg.RLock()
+	defer ConnectorCfg.RUnlock()
+	return *ConnectorCfg.dynamic
+}
@@ -0,0 +1,185 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// configPayload returns a config payload with the JSON config doc
+func configPayload(t *testing.T, doc string) *connectorpb.Payload {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &m); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := structpb.NewStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &connectorpb.Payload{Object: &connectorpb.Payload_Config{Config: &connectorpb.Config{Metadata: metadata}}}
+}
+
+// resetDynamicConfig puts the default dynamic config back at the end of the test
+func resetDynamicConfig(t *testing.T) {
+	t.Cleanup(func() {
+		ConnectorCfg.Lock()
+		defer ConnectorCfg.Unlock()
+		ConnectorCfg.dynamicConfig = make(map[string]interface{})
+		ConnectorCfg.dynamic = defaultDynamicConfig()
+		ConnectorCfg.dynamic.apply()
+	})
+}
+
+func TestMapToDynamicConfig(t *testing.T) {
+	var config map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"log_level": "debug",
+		"log_sample_period": 0,
+		"default_polling_interval": 2000,
+		"max_reads_per_second": 50,
+		"max_writes_per_second": 2.5,
+		"discovery": true,
+		"writes_armed": false,
+		"max_requests_in_flight": 2,
+		"device_limits": {"10.0.0.10:502": {"min_request_gap": 20}},
+		"share_reads": false,
+		"message_envelope": false
+	}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := mapToDynamicConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &dynamicConfig{
+		LogLevel:               logrus.DebugLevel,
+		DefaultPollingInterval: 2 * time.Second,
+		MaxReadsPerSecond:      50,
+		MaxWritesPerSecond:     2.5,
+		Discovery:              true,
+		DeviceLimits:           deviceLimits{MaxInFlight: 2},
+		DeviceOverrides:        map[string]deviceLimits{"10.0.0.10:502": {MaxInFlight: 2, MinGap: 20 * time.Millisecond}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mapToDynamicConfig = %+v, want %+v", got, want)
+	}
+}
+
+func TestMapToDynamicConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		path string
+		err  string
+	}{
+		{"unknown parameter", `{"log_levle": "debug"}`, "log_levle", "unknown config parameter"},
+		{"unknown log level", `{"log_level": "verbose"}`, "log_level", "verbose"},
+		{"polling interval below the minimum", `{"default_polling_interval": 10}`, "default_polling_interval", "expected integer in 100..2147483647, found 10"},
+		{"negative rate", `{"max_reads_per_second": -1}`, "max_reads_per_second", "expected a rate of at least 0, found -1"},
+		{"rate of the wrong type", `{"max_writes_per_second": "10"}`, "max_writes_per_second", "expected number, found string"},
+		{"discovery of the wrong type", `{"discovery": "yes"}`, "discovery", "expected boolean, found string"},
+		{"writes armed of the wrong type", `{"writes_armed": 1}`, "writes_armed", "expected boolean, found number"},
+		{"device that is not host:port", `{"device_limits": {"10.0.0.10": {"max_requests_in_flight": 1}}}`, "device_limits.10.0.0.10", "expected a device as host:port"},
+		{"unknown device limit", `{"device_limits": {"10.0.0.10:502": {"max_requests": 1}}}`, "device_limits.10.0.0.10:502.max_requests", "unknown device limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &config); err != nil {
+				t.Fatal(err)
+			}
+			_, err := mapToDynamicConfig(config)
+			var metadataErr *metadataError
+			if !errors.As(err, &metadataErr) {
+				t.Fatalf("mapToDynamicConfig = %v, want an error at %s", err, tt.path)
+			}
+			if metadataErr.Path != tt.path || !strings.Contains(metadataErr.Message, tt.err) {
+				t.Errorf("mapToDynamicConfig = %q, want %s: %q", err, tt.path, tt.err)
+			}
+		})
+	}
+}
+
+func TestSetPayloadReplacesConfig(t *testing.T) {
+	resetDynamicConfig(t)
+	d := NewConnector()
+	set := func(payloads ...*connectorpb.Payload) {
+		t.Helper()
+		_, err := d.SetPayload(context.Background(), &connectorpb.SetPayloadRequest{ConnectorId: d.id, Payloads: payloads})
+		if err != nil {
+			t.Fatalf("SetPayload: %s", err)
+		}
+	}
+
+	set(configPayload(t, `{"log_level": "debug", "max_reads_per_second": 5}`))
+	if cfg := getDynamicConfig(); cfg.LogLevel != logrus.DebugLevel || cfg.MaxReadsPerSecond != 5 {
+		t.Fatalf("dynamic config = %+v, want debug and 5 reads per second", cfg)
+	}
+
+	// the parameters the next payload doesn't set go back to their default
+	set(configPayload(t, `{"default_polling_interval": 2000}`))
+	cfg := getDynamicConfig()
+	if cfg.LogLevel != logrus.InfoLevel || cfg.MaxReadsPerSecond != 0 || cfg.DefaultPollingInterval != 2*time.Second {
+		t.Errorf("dynamic config = %+v, want the defaults with a polling interval of 2s", cfg)
+	}
+	if level := logger.GetLevel(); level != logrus.InfoLevel {
+		t.Errorf("log level = %s, want info", level)
+	}
+	ConnectorCfg.RLock()
+	config := ConnectorCfg.dynamicConfig
+	ConnectorCfg.RUnlock()
+	if !reflect.DeepEqual(config, map[string]interface{}{"default_polling_interval": 2000.0}) {
+		t.Errorf("config payload = %v, want default_polling_interval only", config)
+	}
+
+	// the config payloads of one call are merged, later ones win
+	set(configPayload(t, `{"log_level": "debug", "writes_armed": false}`), configPayload(t, `{"log_level": "warn"}`))
+	if cfg := getDynamicConfig(); cfg.LogLevel != logrus.WarnLevel || cfg.WritesArmed {
+		t.Errorf("dynamic config = %+v, want warn with writes disarmed", cfg)
+	}
+}
+
+func TestSetPayloadRejectsInvalidConfig(t *testing.T) {
+	resetDynamicConfig(t)
+	d := NewConnector()
+	_, err := d.SetPayload(context.Background(), &connectorpb.SetPayloadRequest{
+		ConnectorId: d.id,
+		Payloads:    []*connectorpb.Payload{configPayload(t, `{"max_reads_per_second": 5}`)},
+	})
+	if err != nil {
+		t.Fatalf("SetPayload: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"unknown parameter", `{"max_reads_per_second": 10, "discover": true}`},
+		{"invalid value", `{"max_reads_per_second": -10}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := d.SetPayload(context.Background(), &connectorpb.SetPayloadRequest{
+				ConnectorId: d.id,
+				Payloads:    []*connectorpb.Payload{configPayload(t, tt.doc)},
+			})
+			if err == nil || resp.GetStatus().GetCode() != connectorpb.ResponseCode_RESPONSE_CODE_INVALID_ARGUMENT {
+				t.Fatalf("SetPayload = %v, %v, want RESPONSE_CODE_INVALID_ARGUMENT", resp.GetStatus(), err)
+			}
+			// the config in effect is kept
+			if cfg := getDynamicConfig(); cfg.MaxReadsPerSecond != 5 {
+				t.Errorf("max reads per second = %v, want 5", cfg.MaxReadsPerSecond)
+			}
+		})
+	}
+}
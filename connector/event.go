@@ -1,25 +1,258 @@
 package connector
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
 	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
 	"github.com/nutanix/kps-connector-go-sdk/events"
+	"github.com/nutanix/kps-connector-go-template/modbus"
+)
+
+const (
+	// alertDedupWindow is how long an alert for a condition that persists is suppressed before
+	// it is published again
+	alertDedupWindow = 5 * time.Minute
+	// maxAlertsPerWindow caps the alerts a stream publishes within alertDedupWindow, so that a
+	// stream raising an alert for every field doesn't flood the events
+	maxAlertsPerWindow = 20
 )
 
+// catalogAlert is an alert of the event catalog. Unlike events.Alert it knows its name, which
+// is reported when the condition it signals is resolved.
+type catalogAlert struct {
+	events.Alert
+	name string
+}
+
+func newCatalogAlert(name string, message string, severity connectorpb.Severity, state connectorpb.State) *catalogAlert {
+	return &catalogAlert{Alert: events.NewAlert(name, message, severity, state), name: name}
+}
+
 var (
-	transportPublishFailedAlert     = events.NewAlert("transportPublishFailed", "failed to publish message on transport", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
-	transportSubscribeFailedAlert   = events.NewAlert("transportSubscribeFailed", "failed to subscribe to transport", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
-	transportUnsubscribeFailedAlert = events.NewAlert("transportUnsubscribeFailed", "failed to unsubscribe from transport", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+	transportPublishFailedAlert     = newCatalogAlert("transportPublishFailed", "failed to publish message on transport", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+	transportSubscribeFailedAlert   = newCatalogAlert("transportSubscribeFailed", "failed to subscribe to transport", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+	transportUnsubscribeFailedAlert = newCatalogAlert("transportUnsubscribeFailed", "failed to unsubscribe from transport", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+
+	plcConnectRefusedAlert = newCatalogAlert("plcConnectRefused", "PLC refused the connection", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+	plcTimeoutAlert        = newCatalogAlert("plcTimeout", "PLC did not answer in time", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	plcExceptionAlert      = newCatalogAlert("plcException", "PLC answered with a Modbus exception", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	plcInvalidAddressAlert = newCatalogAlert("plcInvalidAddress", "address is not valid on the PLC", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+	plcReadFailedAlert     = newCatalogAlert("plcReadFailed", "failed to read from the PLC", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	fieldBadQualityAlert   = newCatalogAlert("fieldBadQuality", "field was read with bad quality", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	writeRejectedAlert     = newCatalogAlert("writeRejected", "message could not be written to the data service", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	streamStalledAlert     = newCatalogAlert("streamStalled", "stream stopped polling and was restarted", connectorpb.Severity_SEVERITY_CRITICAL, connectorpb.State_STATE_FAILED)
+	scanOverrunAlert       = newCatalogAlert("scanOverrun", "poll cycle took longer than the polling interval", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	scanJitterAlert        = newCatalogAlert("scanJitter", "poll cycle started a polling interval or more late", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+	// scanSlotMissedAlert reports slots of a stream with a schedule that were not read, see missedSlotsCondition
+	scanSlotMissedAlert = newCatalogAlert("scanSlotMissed", "slots of the scan schedule were not read", connectorpb.Severity_SEVERITY_WARNING, connectorpb.State_STATE_UNHEALTHY)
+
+	catalogAlerts = []*catalogAlert{
+		transportPublishFailedAlert,
+		transportSubscribeFailedAlert,
+		transportUnsubscribeFailedAlert,
+		plcConnectRefusedAlert,
+		plcTimeoutAlert,
+		plcExceptionAlert,
+		plcInvalidAddressAlert,
+		plcReadFailedAlert,
+		fieldBadQualityAlert,
+		writeRejectedAlert,
+		streamStalledAlert,
+		scanOverrunAlert,
+		scanJitterAlert,
+		scanSlotMissedAlert,
+	}
 
 	streamStartedStatus   = events.NewStatus("streamStarted", "stream has successfully started", connectorpb.State_STATE_PROVISIONED)
 	streamHealthyStatus   = events.NewStatus("streamHealthy", "stream is healthy", connectorpb.State_STATE_HEALTHY)
 	streamUnhealthyStatus = events.NewStatus("streamUnhealthy", "stream is unhealthy", connectorpb.State_STATE_UNHEALTHY)
+	streamUpdatedStatus   = events.NewStatus("streamUpdated", "stream configuration has changed", connectorpb.State_STATE_PROVISIONED)
+	streamResolvedStatus  = events.NewStatus("streamResolved", "alert condition has cleared", connectorpb.State_STATE_HEALTHY)
+	// streamStatisticsStatus carries the statistics of a stream in its metadata, see streamStats
+	streamStatisticsStatus = events.NewStatus("streamStatistics", "stream statistics", connectorpb.State_STATE_PROVISIONED)
+	// pollingIntervalChangedStatus carries the interval adaptive polling changed to, see adaptiveInterval
+	pollingIntervalChangedStatus = events.NewStatus("pollingIntervalChanged", "adaptive polling changed the polling interval", connectorpb.State_STATE_PROVISIONED)
 )
 
 func (d *Connector) initEventRegistry() {
-	d.RegisterAlert(transportPublishFailedAlert)
-	d.RegisterAlert(transportSubscribeFailedAlert)
-	d.RegisterAlert(transportUnsubscribeFailedAlert)
+	for _, alert := range catalogAlerts {
+		d.RegisterAlert(alert.Alert)
+	}
 	d.RegisterStatus(streamStartedStatus)
 	d.RegisterStatus(streamHealthyStatus)
 	d.RegisterStatus(streamUnhealthyStatus)
+	d.RegisterStatus(streamUpdatedStatus)
+	d.RegisterStatus(streamResolvedStatus)
+	d.RegisterStatus(streamStatisticsStatus)
+	d.RegisterStatus(pollingIntervalChangedStatus)
+}
+
+// condition is a failure observed on a stream, reported by streamEvents as an alert
+type condition struct {
+	alert *catalogAlert
+	// key tells apart conditions of the same alert, e.g. the field that has bad quality
+	key   string
+	err   error
+	extra map[string]interface{}
+}
+
+func (c condition) id() string {
+	return c.alert.name + "/" + c.key
+}
+
+// classifyError maps an error of a PLC connection or read to the alert of the catalog it signals
+func classifyError(key string, err error) condition {
+	c := condition{alert: plcReadFailedAlert, key: key, err: err, extra: map[string]interface{}{}}
+	var exception *modbus.Exception
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		c.alert = plcConnectRefusedAlert
+	case errors.As(err, &exception):
+		c.alert = plcExceptionAlert
+		if exception.Code == modbus.IllegalDataAddress {
+			c.alert = plcInvalidAddressAlert
+		}
+		c.extra["function"] = int(exception.Function)
+		c.extra["exceptionCode"] = int(exception.Code)
+		c.extra["exception"] = exception.Code.String()
+	case isTimeout(err):
+		c.alert = plcTimeoutAlert
+	}
+	return c
+}
+
+// isTimeout reports whether err is a request or connection that timed out
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, modbus.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// contextError returns the reason ctx is done, naming the timeout if it expired
+func contextError(ctx context.Context, timeout time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s: %w", timeout, context.DeadlineExceeded)
+	}
+	return ctx.Err()
+}
+
+// activeCondition is a condition that has been alerted and not yet resolved
+type activeCondition struct {
+	condition
+	published  time.Time
+	suppressed int
+}
+
+// streamEvents publishes the alerts and statuses of a stream. An alert for a condition that
+// persists is only published again after alertDedupWindow, a condition that is no longer
+// reported is resolved with streamResolvedStatus, and streamHealthy and streamUnhealthy are
+// only published when the stream changes between them. At most maxAlertsPerWindow alerts are
+// published within alertDedupWindow, the next alert published reports how many were dropped.
+type streamEvents struct {
+	streamID string
+
+	mtx     sync.Mutex
+	active  map[string]*activeCondition
+	healthy *bool
+	// sent holds when the alerts of the last alertDedupWindow were published, dropped counts
+	// the alerts dropped since the last one published
+	sent    []time.Time
+	dropped int
+}
+
+func newStreamEvents(streamID string) *streamEvents {
+	return &streamEvents{streamID: streamID, active: make(map[string]*activeCondition)}
+}
+
+// report publishes the conditions currently observed on the stream. All previously reported
+// conditions missing from conditions are resolved.
+func (e *streamEvents) report(conditions ...condition) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	now := time.Now()
+	current := make(map[string]bool, len(conditions))
+	for _, c := range conditions {
+		current[c.id()] = true
+		e.publish(c, now)
+	}
+
+	for id, active := range e.active {
+		if current[id] {
+			continue
+		}
+		delete(e.active, id)
+		_ = streamResolvedStatus.Publish(events.StatusWithStreamID(e.streamID), events.StatusWithEventMetadata(&events.EventMetadata{
+			StreamID: e.streamID,
+			Extra:    map[string]interface{}{"alert": active.alert.name, "key": active.key},
+		}))
+	}
+
+	healthy := len(conditions) == 0
+	if e.healthy != nil && *e.healthy == healthy {
+		return
+	}
+	e.healthy = &healthy
+	if healthy {
+		_ = streamHealthyStatus.Publish(events.StatusWithStreamID(e.streamID))
+		return
+	}
+	_ = streamUnhealthyStatus.Publish(events.StatusWithStreamID(e.streamID), events.StatusWithEventMetadata(&events.EventMetadata{
+		StreamID:     e.streamID,
+		ErrorMessage: conditions[0].err.Error(),
+	}))
+}
+
+// raise publishes a condition observed outside of a poll cycle. Unlike report it leaves the
+// other conditions alone, the condition is resolved by the next report that misses it.
+func (e *streamEvents) raise(c condition) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.publish(c, time.Now())
+}
+
+// publish publishes the alert of a condition, unless it is already active and was published
+// less than alertDedupWindow ago, or the stream published maxAlertsPerWindow alerts within
+// alertDedupWindow. A dropped alert is not active, so it is published by a later report once
+// the stream is below the cap again. e.mtx must be held.
+func (e *streamEvents) publish(c condition, now time.Time) {
+	id := c.id()
+	active, ok := e.active[id]
+	if ok && now.Sub(active.published) < alertDedupWindow {
+		active.suppressed++
+		return
+	}
+	for len(e.sent) > 0 && now.Sub(e.sent[0]) >= alertDedupWindow {
+		e.sent = e.sent[1:]
+	}
+	if len(e.sent) >= maxAlertsPerWindow {
+		if e.dropped == 0 {
+			streamLogger(e.streamID).Warnf("dropping alerts, %d were published within %s", maxAlertsPerWindow, alertDedupWindow)
+		}
+		e.dropped++
+		return
+	}
+	extra := map[string]interface{}{"key": c.key}
+	for k, v := range c.extra {
+		extra[k] = v
+	}
+	if ok {
+		extra["suppressed"] = active.suppressed
+	}
+	if e.dropped > 0 {
+		extra["droppedAlerts"] = e.dropped
+		e.dropped = 0
+	}
+	e.sent = append(e.sent, now)
+	_ = c.alert.Publish(events.AlertWithStreamID(e.streamID), events.AlertWithEventMetadata(&events.EventMetadata{
+		ErrorMessage: c.err.Error(),
+		StreamID:     e.streamID,
+		Extra:        extra,
+	}))
+	e.active[id] = &activeCondition{condition: c, published: now}
 }
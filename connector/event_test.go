@@ -0,0 +1,169 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-sdk/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestRegistry registers the alerts and statuses of the catalog with a new registry
+func newTestRegistry() *Connector {
+	d := &Connector{Registry: events.NewRegistry()}
+	d.initEventRegistry()
+	return d
+}
+
+// lastEvent returns the alert or status name the registry last stored for the stream, nil if
+// there is none. Publishing the event again replaces it, so a republished event is a new
+// message.
+func lastEvent(t *testing.T, d *Connector, name, streamID string) proto.Message {
+	t.Helper()
+	resp, err := d.GetEvents(context.Background(), &connectorpb.GetEventsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range resp.EventPayloads {
+		if alert := payload.GetAlert(); alert != nil && alert.Id == name && alert.StreamId == streamID {
+			return alert
+		}
+		if status := payload.GetStatus(); status != nil && status.Id == name && status.StreamId == streamID {
+			return status
+		}
+	}
+	return nil
+}
+
+// extra returns the extra metadata of the alert or status
+func extra(msg proto.Message) map[string]interface{} {
+	var m map[string]interface{}
+	switch msg := msg.(type) {
+	case *connectorpb.Alert:
+		m = msg.GetMetadata().AsMap()
+	case *connectorpb.Status:
+		m = msg.GetMetadata().AsMap()
+	}
+	e, _ := m["ExtraMessage"].(map[string]interface{})
+	return e
+}
+
+func badQuality(field string) condition {
+	return condition{alert: fieldBadQualityAlert, key: field, err: errors.New("bad quality"), extra: map[string]interface{}{}}
+}
+
+func TestStreamEventsDeduplicatesAlerts(t *testing.T) {
+	d := newTestRegistry()
+	e := newStreamEvents("s1")
+	e.report(badQuality("F1"))
+	first := lastEvent(t, d, "fieldBadQuality", "s1")
+	if first == nil {
+		t.Fatal("fieldBadQuality was not published")
+	}
+
+	// the condition persists, the alert is suppressed for alertDedupWindow
+	e.report(badQuality("F1"))
+	e.report(badQuality("F1"))
+	if lastEvent(t, d, "fieldBadQuality", "s1") != first {
+		t.Fatal("fieldBadQuality was published again within alertDedupWindow")
+	}
+
+	e.mtx.Lock()
+	e.active[badQuality("F1").id()].published = time.Now().Add(-alertDedupWindow)
+	e.mtx.Unlock()
+	e.report(badQuality("F1"))
+	again := lastEvent(t, d, "fieldBadQuality", "s1")
+	if again == first {
+		t.Fatal("fieldBadQuality was not published again after alertDedupWindow")
+	}
+	if got := extra(again)["suppressed"]; got != 2.0 {
+		t.Errorf("suppressed = %v, want 2", got)
+	}
+}
+
+func TestStreamEventsResolvesConditions(t *testing.T) {
+	d := newTestRegistry()
+	e := newStreamEvents("s1")
+	e.report(badQuality("F1"), badQuality("F2"))
+	e.report(badQuality("F1"))
+	resolved := lastEvent(t, d, "streamResolved", "s1")
+	if resolved == nil {
+		t.Fatal("streamResolved was not published")
+	}
+	if got := extra(resolved); got["alert"] != "fieldBadQuality" || got["key"] != "F2" {
+		t.Errorf("streamResolved extra = %v, want fieldBadQuality F2", got)
+	}
+
+	// a condition reported again once resolved is alerted at once
+	alert := lastEvent(t, d, "fieldBadQuality", "s1")
+	e.report(badQuality("F1"), badQuality("F2"))
+	if lastEvent(t, d, "fieldBadQuality", "s1") == alert {
+		t.Error("fieldBadQuality F2 was not published again after it was resolved")
+	}
+}
+
+func TestStreamEventsPublishesHealthOnTransitions(t *testing.T) {
+	d := newTestRegistry()
+	e := newStreamEvents("s1")
+	e.report()
+	healthy := lastEvent(t, d, "streamHealthy", "s1")
+	if healthy == nil {
+		t.Fatal("streamHealthy was not published")
+	}
+	e.report()
+	if lastEvent(t, d, "streamHealthy", "s1") != healthy {
+		t.Error("streamHealthy was published again while the stream stayed healthy")
+	}
+
+	e.report(badQuality("F1"))
+	unhealthy := lastEvent(t, d, "streamUnhealthy", "s1")
+	if unhealthy == nil {
+		t.Fatal("streamUnhealthy was not published")
+	}
+	e.report(badQuality("F2"))
+	if lastEvent(t, d, "streamUnhealthy", "s1") != unhealthy {
+		t.Error("streamUnhealthy was published again while the stream stayed unhealthy")
+	}
+
+	e.report()
+	if lastEvent(t, d, "streamHealthy", "s1") == healthy {
+		t.Error("streamHealthy was not published when the stream recovered")
+	}
+}
+
+func TestStreamEventsCapsAlerts(t *testing.T) {
+	d := newTestRegistry()
+	e := newStreamEvents("s1")
+	conditions := make([]condition, 0, maxAlertsPerWindow+5)
+	for i := 0; i < maxAlertsPerWindow+5; i++ {
+		conditions = append(conditions, badQuality(fmt.Sprintf("F%d", i)))
+	}
+	e.report(conditions...)
+	e.mtx.Lock()
+	sent, dropped, active := len(e.sent), e.dropped, len(e.active)
+	e.mtx.Unlock()
+	if sent != maxAlertsPerWindow || dropped != 5 || active != maxAlertsPerWindow {
+		t.Fatalf("sent %d, dropped %d and %d active, want %d, 5 and %d", sent, dropped, active, maxAlertsPerWindow, maxAlertsPerWindow)
+	}
+	last := lastEvent(t, d, "fieldBadQuality", "s1")
+	if got := extra(last)["key"]; got != fmt.Sprintf("F%d", maxAlertsPerWindow-1) {
+		t.Errorf("last alert published for %v, want F%d", got, maxAlertsPerWindow-1)
+	}
+
+	// once the window passed, a dropped condition still observed is alerted with the number of
+	// alerts dropped
+	e.mtx.Lock()
+	for i := range e.sent {
+		e.sent[i] = e.sent[i].Add(-alertDedupWindow)
+	}
+	e.mtx.Unlock()
+	e.report(conditions[:maxAlertsPerWindow+1]...)
+	last = lastEvent(t, d, "fieldBadQuality", "s1")
+	if got := extra(last); got["key"] != fmt.Sprintf("F%d", maxAlertsPerWindow) || got["droppedAlerts"] != 5.0 {
+		t.Errorf("alert extra = %v, want F%d with droppedAlerts 5", got, maxAlertsPerWindow)
+	}
+}
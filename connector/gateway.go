@@ -0,0 +1,399 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nutanix/kps-connector-go-template/modbus"
+	"github.com/nutanix/kps-connector-go-template/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// gatewayRequestTimeout bounds how long a single unit behind a gateway may take to answer,
+	// for streams without `read-timeout`
+	gatewayRequestTimeout = 1 * time.Second
+)
+
+// deviceValue holds the values read from one unit behind a Modbus gateway
+type deviceValue struct {
+	Name           string   `json:"name"`
+	UnitIdentifier uint8    `json:"unit-identifier"`
+	Field          []string `json:"field"`
+	Value          []string `json:"value"`
+	Asset          []string `json:"asset,omitempty"`
+	Error          string   `json:"error,omitempty"`
+
+	// timedOut is set if the unit didn't answer in time
+	timedOut bool
+}
+
+// gatewayDevice is a unit behind a Modbus gateway with the parsed fields to read from it
+type gatewayDevice struct {
+	Device
+	fields []modbus.Field
+}
+
+// modbusGateway reads all devices of a stream through a single connection to a Modbus gateway
+type modbusGateway struct {
+	streamID string
+	plc      string
+	address  string
+	framing  modbus.Framing
+	devices  []gatewayDevice
+	// flat is set for streams without devices, whose values are reported like the plc4x ones
+	flat bool
+	// layout shapes the messages with the context labels and asset paths
+	layout *messageLayout
+
+	connectTimeout time.Duration
+	// readTimeout bounds the reads of a single unit, including the wait for the scheduler
+	readTimeout time.Duration
+
+	// scheduler admits every request to the gateway, with priority
+	scheduler *deviceScheduler
+	priority  requestPriority
+
+	// mtx guards client and backoff
+	mtx     sync.Mutex
+	client  *modbus.Client
+	backoff connectBackoff
+
+	log     *logrus.Entry
+	sampler *logSampler
+}
+
+// newModbusGateway prepares the reads of the devices of the stream, the first poll connects to
+// the gateway. Addresses without a unit identifier of their own are read from the unit
+// identifier of the connection string, which defaults to 1. Read errors are logged to log,
+// sampled by sampler.
+func newModbusGateway(streamID string, metadata *streamMetadata, log *logrus.Entry, sampler *logSampler) (*modbusGateway, error) {
+	address, framing, options, err := parseModbusConnectionString(metadata.Plc)
+	if err != nil {
+		return nil, err
+	}
+	defaultUnitIdentifier := uint8(1)
+	if value := options.Get("unit-identifier"); value != "" {
+		unitIdentifier, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unit-identifier %q: %w", value, err)
+		}
+		defaultUnitIdentifier = uint8(unitIdentifier)
+	}
+
+	devices := append([]Device(nil), metadata.Devices...)
+	for _, addr := range metadata.Addresses {
+		devices = appendToDevice(devices, defaultUnitIdentifier, addr)
+	}
+
+	g := &modbusGateway{
+		streamID: streamID,
+		plc:      metadata.Plc,
+		address:  address,
+		framing:  framing,
+		flat:     len(metadata.Devices) == 0,
+		layout:   newMessageLayout(metadata),
+		log:      log,
+		sampler:  sampler,
+
+		connectTimeout: orDefault(metadata.ConnectTimeout, defaultConnectTimeout),
+		readTimeout:    orDefault(metadata.ReadTimeout, gatewayRequestTimeout),
+
+		scheduler: schedulers.forDevice(deviceAddress(metadata.Plc)),
+		priority:  metadata.Priority,
+	}
+	for _, device := range devices {
+		gd := gatewayDevice{Device: device, fields: make([]modbus.Field, 0, len(device.Addresses))}
+		for _, addr := range device.Addresses {
+			field, err := modbus.ParseField(addr.Address)
+			if err != nil {
+				return nil, fmt.Errorf("device %s: %w", device.Name, err)
+			}
+			gd.fields = append(gd.fields, field)
+		}
+		g.devices = append(g.devices, gd)
+	}
+	return g, nil
+}
+
+// modbusFramings maps the protocol codes of connection strings served by the in-tree Modbus client to their framing
+var modbusFramings = map[string]modbus.Framing{
+	"modbus":       modbus.TCP,
+	"modbus-tcp":   modbus.TCP,
+	"modbus-rtu":   modbus.RTU,
+	"modbus-ascii": modbus.ASCII,
+}
+
+// usesModbusClient reports whether a stream is read with the in-tree Modbus client rather
+// than plc4x: either it reads several units through one gateway, or the connection string
+// selects a serial framing plc4x doesn't support, e.g. `modbus-rtu:tcp://host:port`.
+func usesModbusClient(metadata *streamMetadata) bool {
+	if len(metadata.Devices) > 0 {
+		return true
+	}
+	u, err := url.Parse(metadata.Plc)
+	if err != nil {
+		return false
+	}
+	framing, ok := modbusFramings[u.Scheme]
+	return ok && !framing.Multiplexed()
+}
+
+// parseModbusConnectionString splits a connection string like `modbus-rtu:tcp://host:port?unit-identifier=1`
+// into the TCP address, the framing and the options
+func parseModbusConnectionString(connectionString string) (string, modbus.Framing, url.Values, error) {
+	u, err := url.Parse(connectionString)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("error parsing connection string: %w", err)
+	}
+	framing, ok := modbusFramings[u.Scheme]
+	if !ok {
+		return "", 0, nil, fmt.Errorf("unit identifiers per address are only supported for modbus connections, got %q", u.Scheme)
+	}
+	options := u.Query()
+	host := u.Host
+	if u.Opaque != "" {
+		transportURL, err := url.Parse(u.Opaque)
+		if err != nil {
+			return "", 0, nil, fmt.Errorf("error parsing connection string: %w", err)
+		}
+		if transportURL.Scheme != "tcp" {
+			return "", 0, nil, fmt.Errorf("unsupported transport %q for %s", transportURL.Scheme, u.Scheme)
+		}
+		host = transportURL.Host
+	}
+	return host, framing, options, nil
+}
+
+// connect returns the current gateway connection, dialing a new one if there is none and the
+// backoff after a failed attempt has passed
+func (g *modbusGateway) connect(ctx context.Context) (*modbus.Client, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.client != nil && g.client.Err() == nil {
+		return g.client, nil
+	}
+	if err := g.backoff.wait(time.Now()); err != nil {
+		return nil, err
+	}
+	client, err := g.dial(ctx)
+	g.backoff.done(time.Now(), err)
+	if err != nil {
+		return nil, err
+	}
+	if g.client != nil {
+		plcReconnectsTotal.WithLabelValues(g.streamID, g.plc).Inc()
+	}
+	client.SetAdmit(func(ctx context.Context) (func(), error) {
+		return g.scheduler.acquire(ctx, g.priority)
+	})
+	g.client = client
+	return client, nil
+}
+
+// dial connects to the gateway, giving up after the connect timeout or when ctx is done
+func (g *modbusGateway) dial(ctx context.Context) (*modbus.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.connectTimeout)
+	defer cancel()
+	_, span := tracing.Start(ctx, "plc.connect", tracing.KindClient, spanAttributes(g.streamID, g.plc)...)
+	defer span.End()
+	client, err := modbus.Dial(ctx, g.address, g.framing)
+	if err != nil && ctx.Err() != nil {
+		err = contextError(ctx, g.connectTimeout)
+	}
+	span.RecordError(err)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to modbus gateway %s: %w", g.address, err)
+	}
+	return client, nil
+}
+
+// connected reports whether the gateway connection is currently up
+func (g *modbusGateway) connected() bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.client != nil && g.client.Err() == nil
+}
+
+// dropConnection closes the connection if it is still the given one, the next poll connects again
+func (g *modbusGateway) dropConnection(client *modbus.Client) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.client == client {
+		_ = client.Close()
+	}
+}
+
+// close closes the gateway connection
+func (g *modbusGateway) close() {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if g.client != nil {
+		_ = g.client.Close()
+	}
+}
+
+// nextMsg reads all devices, each with its own timeout, and reports the results per device.
+// With TCP framing the devices are read concurrently. The serial framings send one request
+// at a time, so the devices are read one after another and the timeout of a device doesn't
+// run while it waits for the others.
+func (g *modbusGateway) nextMsg(ctx context.Context, cycle *pollCycle) ([]byte, error) {
+	client, err := g.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]deviceValue, len(g.devices))
+	if g.framing.Multiplexed() {
+		var wg sync.WaitGroup
+		for i := range g.devices {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = g.readDevice(ctx, client, &g.devices[i], cycle)
+			}(i)
+		}
+		wg.Wait()
+	} else {
+		for i := range g.devices {
+			results[i] = g.readDevice(ctx, client, &g.devices[i], cycle)
+		}
+	}
+	if ctx.Err() != nil {
+		// the stream is stopping
+		return nil, ctx.Err()
+	}
+	// a gateway that lets every unit time out is likely behind a half-open connection
+	allTimedOut := true
+	for _, result := range results {
+		allTimedOut = allTimedOut && result.timedOut
+	}
+	if allTimedOut && len(results) > 0 {
+		g.sampler.logf(g.log, logrus.WarnLevel, "timeout", "no unit answered within %s, reconnecting", g.readTimeout)
+		g.dropConnection(client)
+	}
+
+	if g.flat {
+		// a flat stream reads the default unit only, and like the plc4x read a failing field
+		// drops the whole message
+		if len(results) != 1 {
+			return nil, fmt.Errorf("a stream without devices reads one unit, found %d", len(results))
+		}
+		result := results[0]
+		if result.Error != "" {
+			return nil, nil
+		}
+		toMarshal := &rvalue{Field: result.Field, Value: result.Value, Asset: result.Asset}
+		cycle.addSamples(len(toMarshal.Value))
+		g.layout.apply(toMarshal)
+		return encodeMessage(ctx, cycle, toMarshal, spanAttributes(g.streamID, g.plc)...)
+	}
+	for _, result := range results {
+		cycle.addSamples(len(result.Value))
+	}
+	toMarshal := &rvalue{Devices: results}
+	g.layout.apply(toMarshal)
+	return encodeMessage(ctx, cycle, toMarshal, spanAttributes(g.streamID, g.plc)...)
+}
+
+// fieldLabel names a field in the metrics, fields of devices are prefixed with the device name
+func (g *modbusGateway) fieldLabel(device *gatewayDevice, i int) string {
+	if g.flat {
+		return device.Addresses[i].Name
+	}
+	return device.Name + "." + device.Addresses[i].Name
+}
+
+// readDevice reads the fields of a device, the fields that other streams read for the cycle
+// are not read again, see sharedReadCache
+func (g *modbusGateway) readDevice(ctx context.Context, client *modbus.Client, device *gatewayDevice, cycle *pollCycle) deviceValue {
+	ctx, span := tracing.Start(ctx, "plc.read", tracing.KindClient,
+		append(spanAttributes(g.streamID, g.plc), tracing.Int("modbus.unit_id", int(device.UnitIdentifier)))...)
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, g.readTimeout)
+	defer cancel()
+
+	result := deviceValue{
+		Name:           device.Name,
+		UnitIdentifier: device.UnitIdentifier,
+		Field:          make([]string, 0, len(device.fields)),
+		Value:          make([]string, 0, len(device.fields)),
+		Asset:          make([]string, 0, len(device.fields)),
+	}
+	queries := make([]string, len(device.fields))
+	for i, field := range device.fields {
+		queries[i] = field.String()
+	}
+	share := cycle.shareReads(fmt.Sprintf("%s#%d", g.plc, device.UnitIdentifier), queries)
+	defer share.abandon()
+	values, errs := g.readOwn(ctx, client, device, share)
+	if ctx.Err() == context.Canceled {
+		// the stream is stopping, the results are dropped
+		return result
+	}
+	for i := range device.fields {
+		value, err := values[i], errs[i]
+		if share.owns(i) {
+			result.timedOut = result.timedOut || isTimeout(err)
+		} else {
+			var started time.Time
+			value, started, err = share.wait(ctx, i, g.readTimeout)
+			if ctx.Err() == context.Canceled {
+				return result
+			}
+			cycle.readAt(started)
+			sharedReadsTotal.WithLabelValues(g.streamID).Inc()
+		}
+		if err != nil {
+			g.sampler.logf(g.log.WithFields(logrus.Fields{"field": device.Addresses[i].Name, "unit": device.UnitIdentifier}),
+				logrus.WarnLevel, "read:"+g.fieldLabel(device, i), "error reading field: %s", err)
+			badQualityTotal.WithLabelValues(g.streamID, g.fieldLabel(device, i)).Inc()
+			cycle.fail(classifyError(g.fieldLabel(device, i), err))
+			if result.Error == "" {
+				result.Error = err.Error()
+				span.RecordError(err)
+			}
+			continue
+		}
+		result.Field = append(result.Field, device.Addresses[i].Name)
+		result.Value = append(result.Value, value)
+		result.Asset = append(result.Asset, assetName(device.Addresses[i]))
+	}
+	return result
+}
+
+// readOwn reads the fields of a device the cycle owns and shares their results. It returns
+// the formatted value or the error of every field it read.
+func (g *modbusGateway) readOwn(ctx context.Context, client *modbus.Client, device *gatewayDevice, share *readShare) ([]string, []error) {
+	values := make([]string, len(device.fields))
+	errs := make([]error, len(device.fields))
+	own := make([]int, 0, len(device.fields))
+	fields := make([]modbus.Field, 0, len(device.fields))
+	for i, field := range device.fields {
+		if share.owns(i) {
+			own = append(own, i)
+			fields = append(fields, field)
+		}
+	}
+	if len(own) == 0 {
+		return values, errs
+	}
+	read, readErrs := client.ReadFields(ctx, device.UnitIdentifier, fields)
+	if ctx.Err() == context.Canceled {
+		// the reads are abandoned
+		return values, errs
+	}
+	for j, i := range own {
+		if readErrs[j] != nil {
+			errs[i] = readErrs[j]
+		} else {
+			values[i] = modbus.FormatValue(fields[j].DataType, read[j])
+		}
+		share.complete(i, values[i], errs[i])
+	}
+	return values, errs
+}
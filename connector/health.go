@@ -0,0 +1,61 @@
+package connector
+
+import (
+	"errors"
+	"sync"
+)
+
+// transportService is the name the transport client is reported under by ServiceHealth
+const transportService = "transport"
+
+// errReadFailed marks a poll cycle whose read failed without returning an error
+var errReadFailed = errors.New("reading from the PLC failed")
+
+// transportHealth tracks the outcome of the last interaction with the transport client. The
+// SDK doesn't expose the state of its NATS connection, so it is inferred from the results.
+var transportHealth = &healthState{}
+
+// healthState holds the last error of a component, nil while it is healthy
+type healthState struct {
+	mtx sync.Mutex
+	err error
+}
+
+func (h *healthState) set(err error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.err = err
+}
+
+func (h *healthState) healthy() bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return h.err == nil
+}
+
+// ServiceHealth reports the health of every running stream by its ID, and of the transport
+// client as `transport`. It implements health.Checker.
+func (d *Connector) ServiceHealth() map[string]bool {
+	services := d.supervisor.health()
+	services[transportService] = transportHealth.healthy()
+	return services
+}
+
+// health reports whether each running stream is connected and its last cycle succeeded.
+// Streams that failed to start are reported as unhealthy.
+func (s *supervisor) health() map[string]bool {
+	s.streamsMtx.RLock()
+	defer s.streamsMtx.RUnlock()
+	services := make(map[string]bool, len(s.activeInStreams)+len(s.activeOutStreams)+len(s.failedStreams))
+	for streamID := range s.failedStreams {
+		services[streamID] = false
+	}
+	for streamID, in := range s.activeInStreams {
+		services[streamID] = in.consumer.healthy()
+	}
+	for streamID, out := range s.activeOutStreams {
+		sub, ok := out.subscription.(*producerSubscription)
+		services[streamID] = !ok || sub.valid()
+	}
+	return services
+}
@@ -0,0 +1,126 @@
+package connector
+
+import (
+	"strings"
+)
+
+// assetSeparator separates the levels of the asset path of an address, like
+// `Site/Area/Line3/Press2/Temperature`
+const assetSeparator = "/"
+
+// parseAssetPath splits the asset path of an address into its levels, none of which may be empty
+func parseAssetPath(asset, path string) ([]string, error) {
+	levels := strings.Split(asset, assetSeparator)
+	for _, level := range levels {
+		if strings.TrimSpace(level) == "" {
+			return nil, newMetadataError(joinPath(path, "asset"), "expected levels separated by %s, found %q", assetSeparator, asset)
+		}
+	}
+	return levels, nil
+}
+
+// assetPath returns the levels of the asset path of an address, the path of an address
+// without `asset` is its field name
+func assetPath(addr Address) []string {
+	if len(addr.Asset) == 0 {
+		return strings.Split(addr.Name, assetSeparator)
+	}
+	return addr.Asset
+}
+
+// assetName is the asset path of an address as listed in the messages
+func assetName(addr Address) string {
+	return strings.Join(assetPath(addr), assetSeparator)
+}
+
+// checkAssetTree checks that the asset paths of the addresses of a stream with `layout: tree`
+// form a tree: no two addresses share a path, and no address is a level of another's path
+func checkAssetTree(addresses []Address) error {
+	// leaves and levels hold the joined paths of the addresses and of the levels above them
+	leaves := make(map[string]bool)
+	levels := make(map[string]bool)
+	for _, addr := range addresses {
+		path := assetPath(addr)
+		for i := 1; i < len(path); i++ {
+			level := strings.Join(path[:i], assetSeparator)
+			if leaves[level] {
+				return newMetadataError(joinPath(addr.path, "asset"), "%q is the asset path of another address", level)
+			}
+			levels[level] = true
+		}
+		leaf := strings.Join(path, assetSeparator)
+		if leaves[leaf] || levels[leaf] {
+			return newMetadataError(joinPath(addr.path, "asset"), "%q is already in the asset tree", leaf)
+		}
+		leaves[leaf] = true
+	}
+	return nil
+}
+
+// messageLayout shapes the messages of an ingress stream: it adds the `context` labels of the
+// stream and the asset paths of the fields, and with `layout: tree` nests the values into a
+// tree following the asset paths instead of the field and value arrays
+type messageLayout struct {
+	context map[string]string
+	// assets is set if an address of the stream has an asset path, the messages of streams
+	// without asset paths don't list them
+	assets bool
+	tree   bool
+}
+
+func newMessageLayout(metadata *streamMetadata) *messageLayout {
+	l := &messageLayout{context: metadata.Context, tree: metadata.TreeLayout}
+	for _, addr := range metadata.Addresses {
+		l.assets = l.assets || len(addr.Asset) > 0
+	}
+	for _, device := range metadata.Devices {
+		for _, addr := range device.Addresses {
+			l.assets = l.assets || len(addr.Asset) > 0
+		}
+	}
+	return l
+}
+
+// apply shapes a message whose Asset lists hold the asset path of every field
+func (l *messageLayout) apply(v *rvalue) {
+	v.Context = l.context
+	if l.tree {
+		v.Tree = make(map[string]interface{})
+		addToTree(v.Tree, v.Asset, v.Value)
+		for _, device := range v.Devices {
+			addToTree(v.Tree, device.Asset, device.Value)
+			if device.Error != "" {
+				if v.Errors == nil {
+					v.Errors = make(map[string]string)
+				}
+				v.Errors[device.Name] = device.Error
+			}
+		}
+		v.Field, v.Value, v.Asset, v.Devices = nil, nil, nil, nil
+		return
+	}
+	if !l.assets {
+		v.Asset = nil
+		for i := range v.Devices {
+			v.Devices[i].Asset = nil
+		}
+	}
+}
+
+// addToTree nests the values at their asset paths, checkAssetTree ensures that the paths
+// don't collide
+func addToTree(tree map[string]interface{}, assets, values []string) {
+	for i, asset := range assets {
+		node := tree
+		levels := strings.Split(asset, assetSeparator)
+		for _, level := range levels[:len(levels)-1] {
+			child, ok := node[level].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[level] = child
+			}
+			node = child
+		}
+		node[levels[len(levels)-1]] = values[i]
+	}
+}
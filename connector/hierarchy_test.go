@@ -0,0 +1,127 @@
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+)
+
+func TestCheckAssetTree(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses string
+		// devices is empty for a stream without devices
+		devices string
+		// path is where the conflict is reported, empty if the paths form a tree
+		path string
+		err  string
+	}{
+		{"tree",
+			`[{"name": "t", "address": "coil:1", "asset": "Site/Line3/Press2/Temperature"},
+			  {"name": "p", "address": "coil:2", "asset": "Site/Line3/Press2/Pressure"},
+			  {"name": "Site/Line4/Speed", "address": "coil:3"}]`,
+			"", "", ""},
+		{"same path",
+			`[{"name": "t", "address": "coil:1", "asset": "Site/Line3/Temperature"},
+			  {"name": "u", "address": "coil:2", "asset": "Site/Line3/Temperature"}]`,
+			"", "addresses[1].asset", `"Site/Line3/Temperature" is already in the asset tree`},
+		{"leaf is a branch of a later path",
+			`[{"name": "t", "address": "coil:1", "asset": "Site/Line3"},
+			  {"name": "u", "address": "coil:2", "asset": "Site/Line3/Temperature"}]`,
+			"", "addresses[1].asset", `"Site/Line3" is the asset path of another address`},
+		{"leaf is a branch of an earlier path",
+			`[{"name": "t", "address": "coil:1", "asset": "Site/Line3/Temperature"},
+			  {"name": "u", "address": "coil:2", "asset": "Site/Line3"}]`,
+			"", "addresses[1].asset", `"Site/Line3" is already in the asset tree`},
+		{"field name is a branch",
+			`[{"name": "Line3", "address": "coil:1"},
+			  {"name": "u", "address": "coil:2", "asset": "Line3/Temperature"}]`,
+			"", "addresses[1].asset", `"Line3" is the asset path of another address`},
+		{"conflict across devices",
+			`[{"name": "t", "address": "coil:1", "asset": "Site/Press1/Temperature"}]`,
+			`[{"name": "press", "unit-identifier": 2, "addresses": [
+				{"name": "u", "address": "coil:1", "asset": "Site/Press1"}
+			]}]`, "devices[0].addresses[0].asset", `"Site/Press1" is already in the asset tree`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := `{"plc": "modbus:tcp://127.0.0.1:5020", "layout": "tree", "addresses": ` + tt.addresses
+			if tt.devices != "" {
+				doc += `, "devices": ` + tt.devices
+			}
+			doc += `}`
+			_, err := checkMetadata(doc, connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS)
+			if tt.path == "" {
+				if err != nil {
+					t.Fatalf("checkMetadata = %s, want the asset paths to form a tree", err)
+				}
+				return
+			}
+			var metadataErr *metadataError
+			if !errors.As(err, &metadataErr) {
+				t.Fatalf("checkMetadata = %v, want an error at %s", err, tt.path)
+			}
+			if metadataErr.Path != tt.path || !strings.Contains(metadataErr.Message, tt.err) {
+				t.Errorf("checkMetadata = %q, want %s: %q", err, tt.path, tt.err)
+			}
+		})
+	}
+}
+
+func TestMessageLayoutTree(t *testing.T) {
+	l := &messageLayout{context: map[string]string{"site": "Berlin"}, assets: true, tree: true}
+	v := &rvalue{
+		Field: []string{"t", "p", "Speed"},
+		Value: []string{"21.5", "3", "1200"},
+		Asset: []string{"Site/Line3/Press2/Temperature", "Site/Line3/Press2/Pressure", "Speed"},
+		Devices: []deviceValue{
+			{Name: "press1", UnitIdentifier: 1, Field: []string{"t"}, Value: []string{"19"}, Asset: []string{"Site/Line3/Press1/Temperature"}},
+			{Name: "press4", UnitIdentifier: 4, Error: "timed out"},
+		},
+	}
+	l.apply(v)
+	got, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"tree":{"Site":{"Line3":{"Press1":{"Temperature":"19"},"Press2":{"Pressure":"3","Temperature":"21.5"}}},"Speed":"1200"},` +
+		`"errors":{"press4":"timed out"},"context":{"site":"Berlin"}}`
+	if string(got) != want {
+		t.Errorf("message =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMessageLayoutFlat(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout *messageLayout
+		want   string
+	}{
+		{"with asset paths", &messageLayout{assets: true},
+			`{"field":["t"],"value":["19"],"asset":["Site/Press1/Temperature"],` +
+				`"devices":[{"name":"press2","unit-identifier":2,"field":["t"],"value":["20"],"asset":["Site/Press2/Temperature"]}]}`},
+		{"without asset paths", &messageLayout{context: map[string]string{"line": "3"}},
+			`{"field":["t"],"value":["19"],"devices":[{"name":"press2","unit-identifier":2,"field":["t"],"value":["20"]}],"context":{"line":"3"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &rvalue{
+				Field:   []string{"t"},
+				Value:   []string{"19"},
+				Asset:   []string{"Site/Press1/Temperature"},
+				Devices: []deviceValue{{Name: "press2", UnitIdentifier: 2, Field: []string{"t"}, Value: []string{"20"}, Asset: []string{"Site/Press2/Temperature"}}},
+			}
+			tt.layout.apply(v)
+			got, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("message =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
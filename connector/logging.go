@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logger is the logrus standard logger, so that plc4go, which logs through it as well, shares
+// its level and format. Its level is the `log_level` of the dynamic config.
+var logger = logrus.StandardLogger()
+
+// auditLogger logs the audit records regardless of the level of logger
+var auditLogger = logrus.New()
+
+var logFormatters = map[string]func() logrus.Formatter{
+	"text": func() logrus.Formatter { return &logrus.TextFormatter{FullTimestamp: true} },
+	"json": func() logrus.Formatter { return &logrus.JSONFormatter{} },
+}
+
+// SetupLogging sets the format of the logs, `text` (the default if empty) or `json`, and routes
+// the logs of the standard library log package through the logger so that all logs share the format
+func SetupLogging(format string) error {
+	if format == "" {
+		format = "text"
+	}
+	newFormatter, ok := logFormatters[strings.ToLower(format)]
+	if !ok {
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	logger.SetFormatter(newFormatter())
+	auditLogger.SetFormatter(newFormatter())
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	return nil
+}
+
+// stdLogWriter logs the lines of the standard library log package at info level
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	logger.Info(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// logLevels are the levels `log_level` accepts
+var logLevels = []logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel}
+
+// parseLogLevel accepts the level names in any case, and WARNING for WARN
+func parseLogLevel(name string) (logrus.Level, error) {
+	level, err := logrus.ParseLevel(name)
+	if err == nil {
+		for _, l := range logLevels {
+			if l == level {
+				return level, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("expected one of DEBUG, INFO, WARN, ERROR, found %q", name)
+}
+
+func infof(format string, args ...interface{})  { logger.Infof(format, args...) }
+func warnf(format string, args ...interface{})  { logger.Warnf(format, args...) }
+func errorf(format string, args ...interface{}) { logger.Errorf(format, args...) }
+
+// streamLogger returns the logger for the messages of a stream
+func streamLogger(streamID string) *logrus.Entry {
+	return logger.WithField("stream", streamID)
+}
+
+// logSampler thins out messages that repeat every poll cycle or every message of a stream:
+// the first message of a kind is logged, the following ones at most once per
+// `log_sample_period` of the dynamic config, with the number of messages dropped since
+type logSampler struct {
+	mtx   sync.Mutex
+	kinds map[string]*sampledKind
+}
+
+type sampledKind struct {
+	last    time.Time
+	dropped int
+}
+
+func newLogSampler() *logSampler {
+	return &logSampler{kinds: make(map[string]*sampledKind)}
+}
+
+// logf logs the message of the given kind to entry, unless it is sampled out
+func (s *logSampler) logf(entry *logrus.Entry, level logrus.Level, kind string, format string, args ...interface{}) {
+	if !logger.IsLevelEnabled(level) {
+		return
+	}
+	period := getDynamicConfig().LogSamplePeriod
+
+	s.mtx.Lock()
+	k, ok := s.kinds[kind]
+	if !ok {
+		k = &sampledKind{}
+		s.kinds[kind] = k
+	}
+	now := time.Now()
+	if period > 0 && now.Sub(k.last) < period {
+		k.dropped++
+		s.mtx.Unlock()
+		return
+	}
+	dropped := k.dropped
+	k.last = now
+	k.dropped = 0
+	s.mtx.Unlock()
+
+	if dropped > 0 {
+		entry = entry.WithField("dropped", dropped)
+	}
+	entry.Logf(level, format, args...)
+}
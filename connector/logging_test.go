@@ -0,0 +1,74 @@
+package connector
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// useLogSamplePeriod makes period the `log_sample_period` for the rest of the test
+func useLogSamplePeriod(t *testing.T, period time.Duration) {
+	ConnectorCfg.Lock()
+	dynamic := ConnectorCfg.dynamic
+	cfg := *dynamic
+	cfg.LogSamplePeriod = period
+	ConnectorCfg.dynamic = &cfg
+	ConnectorCfg.Unlock()
+	t.Cleanup(func() {
+		ConnectorCfg.Lock()
+		ConnectorCfg.dynamic = dynamic
+		ConnectorCfg.Unlock()
+	})
+}
+
+// bufferEntry returns an entry logging lines with only their message and fields to buf
+func bufferEntry(buf *bytes.Buffer) *logrus.Entry {
+	l := logrus.New()
+	l.SetOutput(buf)
+	l.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
+	return logrus.NewEntry(l)
+}
+
+func TestLogSampler(t *testing.T) {
+	useLogSamplePeriod(t, 100*time.Millisecond)
+	var buf bytes.Buffer
+	entry := bufferEntry(&buf)
+	s := newLogSampler()
+
+	// the first message of a kind is logged, the repeats within the period are dropped
+	for i := 0; i < 50; i++ {
+		s.logf(entry, logrus.WarnLevel, "read", "read failed %d", i)
+	}
+	s.logf(entry, logrus.WarnLevel, "publish", "publish failed")
+	// messages of a disabled level are neither logged nor counted
+	s.logf(entry, logrus.DebugLevel, "read", "read debug")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `msg="read failed 0"`) || !strings.Contains(lines[1], `msg="publish failed"`) {
+		t.Fatalf("logged %q, want the first read and publish messages", lines)
+	}
+
+	// once the period passed, the next message is logged with the number of messages dropped
+	time.Sleep(120 * time.Millisecond)
+	buf.Reset()
+	s.logf(entry, logrus.WarnLevel, "read", "read failed %d", 50)
+	s.logf(entry, logrus.WarnLevel, "read", "read failed %d", 51)
+	if got := strings.TrimSpace(buf.String()); !strings.Contains(got, `msg="read failed 50"`) || !strings.Contains(got, "dropped=49") || strings.Contains(got, "\n") {
+		t.Errorf("logged %q, want read failed 50 with dropped=49", got)
+	}
+}
+
+func TestLogSamplerWithoutPeriod(t *testing.T) {
+	useLogSamplePeriod(t, 0)
+	var buf bytes.Buffer
+	entry := bufferEntry(&buf)
+	s := newLogSampler()
+	for i := 0; i < 10; i++ {
+		s.logf(entry, logrus.WarnLevel, "read", "read failed")
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 10 {
+		t.Errorf("logged %d messages, want all 10", n)
+	}
+}
@@ -0,0 +1,800 @@
+package connector
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-template/modbus"
+	"github.com/nutanix/kps-connector-go-template/schedule"
+)
+
+const (
+	// defaultPollingInterval is used for ingress streams without `polling-interval`, unless the
+	// dynamic config sets `default_polling_interval`
+	defaultPollingInterval = 5 * time.Second
+	minPollingInterval     = 100 * time.Millisecond
+)
+
+// Address is a named PLC address read by an ingress stream
+type Address struct {
+	Name    string
+	Address string
+	// Asset is the ISA-95 style asset path of the address, like `Site/Area/Line3/Press2/Temperature`,
+	// split into its levels, nil if the address has none
+	Asset []string
+
+	// path locates the address in the stream metadata for error messages
+	path string
+}
+
+// Device is a group of addresses read from one Modbus unit behind a gateway
+type Device struct {
+	Name           string
+	UnitIdentifier uint8
+	Addresses      []Address
+
+	// path locates the device in the stream metadata for error messages, it is the path of
+	// its first address for the devices of addresses with their own unit identifier
+	path string
+}
+
+// AdaptivePolling scales the polling interval of an ingress stream, see adaptiveInterval
+type AdaptivePolling struct {
+	// MinInterval is the floor the interval shortens toward while the values change, 0
+	// keeps the polling interval of the stream as the floor
+	MinInterval time.Duration
+	// MaxInterval is the ceiling the interval grows toward while the reads fail
+	MaxInterval time.Duration
+	// Factor is what the interval is multiplied or divided by in each step
+	Factor float64
+}
+
+// ScanSchedule aligns the polls of an ingress stream to the wall clock instead of the polling
+// interval, each poll reads the values of a slot of the schedule
+type ScanSchedule struct {
+	schedule.Schedule
+	// Spec describes the schedule, like `every 15m0s` or `cron 0 6,14,22 * * *`, slots recorded
+	// for a different spec are not compared with this schedule
+	Spec string
+}
+
+// ModbusServer configures the Modbus TCP server of an egress stream
+type ModbusServer struct {
+	Port           int
+	UnitIdentifier uint8
+}
+
+// Register maps a field of the pipeline messages onto a Modbus address.
+// The served value is `value * Scale + Offset`.
+type Register struct {
+	Field   string
+	Address string
+	Scale   float64
+	Offset  float64
+
+	path string
+}
+
+// streamMetadata is the typed form of the `streamParameterSchema` in deploy/class_plc4x.json
+type streamMetadata struct {
+	// Name names the stream in the message envelopes, it defaults to the ID of the stream
+	Name      string
+	Plc       string
+	Addresses []Address
+	Devices   []Device
+
+	// Context holds the static labels added to every message, like the site or line
+	Context map[string]string
+	// TreeLayout is set for streams with `layout: tree`, whose messages nest their values
+	// into a tree following the asset paths
+	TreeLayout bool
+
+	// PollingInterval is the time between two reads of an ingress stream, 0 if the stream uses
+	// the default polling interval of the dynamic config
+	PollingInterval time.Duration
+	// AdaptivePolling is set for streams whose polling interval adapts to errors and changes
+	AdaptivePolling *AdaptivePolling
+	// Schedule is set for streams polled on wall-clock slots, the polling interval is ignored
+	Schedule *ScanSchedule
+
+	// ConnectTimeout, ReadTimeout and WriteTimeout bound connecting to the PLC, a read request
+	// and writing a message, 0 if the stream uses the default
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	// StallTimeout is how long a poll cycle may run before the watchdog restarts the stream,
+	// 0 if the stream uses the default
+	StallTimeout time.Duration
+
+	// Priority is the class of the reads of an ingress stream, priorityAlarm for
+	// `priority: alarm`, priorityPoll otherwise
+	Priority requestPriority
+
+	// ModbusServer turns an egress stream into a Modbus TCP server serving Registers
+	ModbusServer *ModbusServer
+	Registers    []Register
+}
+
+// metadataError reports invalid stream metadata together with the path of the offending key
+type metadataError struct {
+	Path    string
+	Message string
+}
+
+func (e *metadataError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func newMetadataError(path string, format string, args ...interface{}) error {
+	return &metadataError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// mapToStreamMetadata translates the stream metadata into the corresponding streamMetadata struct.
+// It only checks the structure of the metadata, see validateStreamMetadata for the semantic checks.
+func mapToStreamMetadata(metadata map[string]interface{}) (*streamMetadata, error) {
+	name, _, err := getString(metadata, "name", "")
+	if err != nil {
+		return nil, err
+	}
+	plc, _, err := getString(metadata, "plc", "")
+	if err != nil {
+		return nil, err
+	}
+
+	addressObjs, err := getArray(metadata, "addresses", "")
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]Address, 0, len(addressObjs))
+	devices := make([]Device, 0)
+	// addressUnits holds the path of the first address with each unit identifier
+	addressUnits := make(map[uint8]string)
+	for i, obj := range addressObjs {
+		path := indexPath("addresses", i)
+		addressMap, err := asObject(obj, path)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := mapToAddress(addressMap, path)
+		if err != nil {
+			return nil, err
+		}
+		// an address with its own unit identifier is read through the gateway as a device of its own
+		unitIdentifier, ok, err := getUnitIdentifier(addressMap, path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if _, ok := addressUnits[unitIdentifier]; !ok {
+				addressUnits[unitIdentifier] = path
+			}
+			devices = appendToDevice(devices, unitIdentifier, addr)
+			continue
+		}
+		addresses = append(addresses, addr)
+	}
+
+	deviceObjs, err := getArray(metadata, "devices", "")
+	if err != nil {
+		return nil, err
+	}
+	for i, obj := range deviceObjs {
+		path := indexPath("devices", i)
+		device, err := mapToDevice(obj, path)
+		if err != nil {
+			return nil, err
+		}
+		// the addresses of a unit are either listed with the unit in devices or carry its
+		// unit identifier, a device and an address can't both claim the unit
+		if addressPath, ok := addressUnits[device.UnitIdentifier]; ok {
+			return nil, newMetadataError(joinPath(path, "unit-identifier"), "unit %d is also the unit-identifier of %s", device.UnitIdentifier, addressPath)
+		}
+		devices = append(devices, device)
+	}
+
+	context, err := mapToContext(metadata)
+	if err != nil {
+		return nil, err
+	}
+	treeLayout := false
+	if layout, ok, err := getString(metadata, "layout", ""); err != nil {
+		return nil, err
+	} else if ok {
+		switch layout {
+		case "tree":
+			treeLayout = true
+		case "flat":
+		default:
+			return nil, newMetadataError("layout", "expected flat or tree, found %q", layout)
+		}
+	}
+
+	var pollingInterval time.Duration
+	if ms, ok, err := getInteger(metadata, "polling-interval", "", int(minPollingInterval/time.Millisecond), math.MaxInt32); err != nil {
+		return nil, err
+	} else if ok {
+		pollingInterval = time.Duration(ms) * time.Millisecond
+	}
+
+	connectTimeout, err := getTimeout(metadata, "connect-timeout")
+	if err != nil {
+		return nil, err
+	}
+	readTimeout, err := getTimeout(metadata, "read-timeout")
+	if err != nil {
+		return nil, err
+	}
+	writeTimeout, err := getTimeout(metadata, "write-timeout")
+	if err != nil {
+		return nil, err
+	}
+	stallTimeout, err := getTimeout(metadata, "stall-timeout")
+	if err != nil {
+		return nil, err
+	}
+	priority := priorityPoll
+	if name, ok, err := getString(metadata, "priority", ""); err != nil {
+		return nil, err
+	} else if ok {
+		switch name {
+		case "alarm":
+			priority = priorityAlarm
+		case "poll":
+		default:
+			return nil, newMetadataError("priority", "expected alarm or poll, found %q", name)
+		}
+	}
+
+	adaptivePolling, err := mapToAdaptivePolling(metadata)
+	if err != nil {
+		return nil, err
+	}
+	scanSchedule, err := mapToScanSchedule(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if scanSchedule != nil && adaptivePolling != nil {
+		return nil, newMetadataError("schedule", "a stream with a schedule can't use adaptive-polling")
+	}
+
+	modbusServer, err := mapToModbusServer(metadata)
+	if err != nil {
+		return nil, err
+	}
+	registers, err := mapToRegisters(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamMetadata{
+		Name:            name,
+		Plc:             plc,
+		Addresses:       addresses,
+		Devices:         devices,
+		Context:         context,
+		TreeLayout:      treeLayout,
+		PollingInterval: pollingInterval,
+		AdaptivePolling: adaptivePolling,
+		Schedule:        scanSchedule,
+		ConnectTimeout:  connectTimeout,
+		ReadTimeout:     readTimeout,
+		WriteTimeout:    writeTimeout,
+		StallTimeout:    stallTimeout,
+		Priority:        priority,
+		ModbusServer:    modbusServer,
+		Registers:       registers,
+	}, nil
+}
+
+// getTimeout reads a timeout in milliseconds, it is 0 if the key is not set
+func getTimeout(metadata map[string]interface{}, key string) (time.Duration, error) {
+	ms, _, err := getInteger(metadata, key, "", 1, math.MaxInt32)
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+// orDefault returns timeout, or def if it is 0
+func orDefault(timeout, def time.Duration) time.Duration {
+	if timeout == 0 {
+		return def
+	}
+	return timeout
+}
+
+func mapToAddress(addressMap map[string]interface{}, path string) (Address, error) {
+	name, ok, err := getString(addressMap, "name", path)
+	if err != nil {
+		return Address{}, err
+	}
+	if !ok || name == "" {
+		return Address{}, newMetadataError(joinPath(path, "name"), "required non-empty string")
+	}
+	address, ok, err := getString(addressMap, "address", path)
+	if err != nil {
+		return Address{}, err
+	}
+	if !ok || address == "" {
+		return Address{}, newMetadataError(joinPath(path, "address"), "required non-empty string")
+	}
+	var levels []string
+	if asset, ok, err := getString(addressMap, "asset", path); err != nil {
+		return Address{}, err
+	} else if ok {
+		if levels, err = parseAssetPath(asset, path); err != nil {
+			return Address{}, err
+		}
+	}
+	return Address{Name: name, Address: address, Asset: levels, path: path}, nil
+}
+
+// mapToContext translates the `context` stream metadata, labels with string values
+func mapToContext(metadata map[string]interface{}) (map[string]string, error) {
+	obj, ok := metadata["context"]
+	if !ok {
+		return nil, nil
+	}
+	contextMap, err := asObject(obj, "context")
+	if err != nil {
+		return nil, err
+	}
+	context := make(map[string]string, len(contextMap))
+	for key := range contextMap {
+		if key == "" {
+			return nil, newMetadataError("context", "labels must have a non-empty name")
+		}
+		if context[key], _, err = getString(contextMap, key, "context"); err != nil {
+			return nil, err
+		}
+	}
+	return context, nil
+}
+
+func mapToDevice(obj interface{}, path string) (Device, error) {
+	deviceMap, err := asObject(obj, path)
+	if err != nil {
+		return Device{}, err
+	}
+	unitIdentifier, ok, err := getUnitIdentifier(deviceMap, path)
+	if err != nil {
+		return Device{}, err
+	}
+	if !ok {
+		return Device{}, newMetadataError(joinPath(path, "unit-identifier"), "required")
+	}
+	name, ok, err := getString(deviceMap, "name", path)
+	if err != nil {
+		return Device{}, err
+	}
+	if !ok {
+		name = fmt.Sprintf("unit-%d", unitIdentifier)
+	}
+	addressObjs, err := getArray(deviceMap, "addresses", path)
+	if err != nil {
+		return Device{}, err
+	}
+	if len(addressObjs) == 0 {
+		return Device{}, newMetadataError(joinPath(path, "addresses"), "at least one address is required")
+	}
+	device := Device{
+		Name:           name,
+		UnitIdentifier: unitIdentifier,
+		Addresses:      make([]Address, 0, len(addressObjs)),
+		path:           path,
+	}
+	for i, obj := range addressObjs {
+		addressPath := indexPath(joinPath(path, "addresses"), i)
+		addressMap, err := asObject(obj, addressPath)
+		if err != nil {
+			return Device{}, err
+		}
+		addr, err := mapToAddress(addressMap, addressPath)
+		if err != nil {
+			return Device{}, err
+		}
+		device.Addresses = append(device.Addresses, addr)
+	}
+	return device, nil
+}
+
+// appendToDevice adds the address to the device with the given unit identifier, creating it if needed
+func appendToDevice(devices []Device, unitIdentifier uint8, addr Address) []Device {
+	for i := range devices {
+		if devices[i].UnitIdentifier == unitIdentifier {
+			devices[i].Addresses = append(devices[i].Addresses, addr)
+			return devices
+		}
+	}
+	return append(devices, Device{
+		Name:           fmt.Sprintf("unit-%d", unitIdentifier),
+		UnitIdentifier: unitIdentifier,
+		Addresses:      []Address{addr},
+		path:           addr.path,
+	})
+}
+
+// mapToAdaptivePolling translates the `adaptive-polling` stream metadata, it returns nil if the key is missing
+func mapToAdaptivePolling(metadata map[string]interface{}) (*AdaptivePolling, error) {
+	obj, ok := metadata["adaptive-polling"]
+	if !ok {
+		return nil, nil
+	}
+	adaptiveMap, err := asObject(obj, "adaptive-polling")
+	if err != nil {
+		return nil, err
+	}
+	adaptive := &AdaptivePolling{MaxInterval: defaultMaxAdaptiveInterval, Factor: defaultAdaptiveFactor}
+	minMs := int(minPollingInterval / time.Millisecond)
+	if ms, ok, err := getInteger(adaptiveMap, "min-interval", "adaptive-polling", minMs, math.MaxInt32); err != nil {
+		return nil, err
+	} else if ok {
+		adaptive.MinInterval = time.Duration(ms) * time.Millisecond
+	}
+	if ms, ok, err := getInteger(adaptiveMap, "max-interval", "adaptive-polling", minMs, math.MaxInt32); err != nil {
+		return nil, err
+	} else if ok {
+		adaptive.MaxInterval = time.Duration(ms) * time.Millisecond
+	}
+	if adaptive.MinInterval > adaptive.MaxInterval {
+		return nil, newMetadataError("adaptive-polling.min-interval", "expected at most max-interval %d, found %d",
+			adaptive.MaxInterval/time.Millisecond, adaptive.MinInterval/time.Millisecond)
+	}
+	if factor, ok, err := getNumber(adaptiveMap, "backoff-factor", "adaptive-polling"); err != nil {
+		return nil, err
+	} else if ok {
+		if factor <= 1 || factor > maxAdaptiveFactor {
+			return nil, newMetadataError("adaptive-polling.backoff-factor", "expected a factor above 1 and at most %v, found %v", maxAdaptiveFactor, factor)
+		}
+		adaptive.Factor = factor
+	}
+	return adaptive, nil
+}
+
+// mapToScanSchedule translates the `schedule` stream metadata, it returns nil if the key is
+// missing. The schedule either has `every` and an optional `offset` in milliseconds, or a
+// `cron` expression, its slots are wall-clock times in `timezone`, UTC by default.
+func mapToScanSchedule(metadata map[string]interface{}) (*ScanSchedule, error) {
+	obj, ok := metadata["schedule"]
+	if !ok {
+		return nil, nil
+	}
+	scheduleMap, err := asObject(obj, "schedule")
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if name, ok, err := getString(scheduleMap, "timezone", "schedule"); err != nil {
+		return nil, err
+	} else if ok {
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, newMetadataError("schedule.timezone", "unknown time zone %q", name)
+		}
+	}
+	everyMs, hasEvery, err := getInteger(scheduleMap, "every", "schedule", int(minPollingInterval/time.Millisecond), math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	offsetMs, hasOffset, err := getInteger(scheduleMap, "offset", "schedule", 0, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	expr, hasCron, err := getString(scheduleMap, "cron", "schedule")
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case hasEvery && hasCron:
+		return nil, newMetadataError("schedule", "expected either every or cron, found both")
+	case hasCron:
+		if hasOffset {
+			return nil, newMetadataError("schedule.offset", "only allowed with every")
+		}
+		s, err := schedule.Cron(expr, loc)
+		if err != nil {
+			return nil, newMetadataError("schedule.cron", "%s", err)
+		}
+		if s.Next(time.Now()).IsZero() {
+			return nil, newMetadataError("schedule.cron", "%q has no slot in the next five years", expr)
+		}
+		return &ScanSchedule{Schedule: s, Spec: fmt.Sprintf("cron %s in %s", expr, loc)}, nil
+	case hasEvery:
+		every, offset := time.Duration(everyMs)*time.Millisecond, time.Duration(offsetMs)*time.Millisecond
+		s, err := schedule.Every(every, offset, loc)
+		if err != nil {
+			return nil, newMetadataError("schedule.every", "%s", err)
+		}
+		return &ScanSchedule{Schedule: s, Spec: fmt.Sprintf("every %s offset %s in %s", every, offset, loc)}, nil
+	}
+	return nil, newMetadataError("schedule", "expected every or cron")
+}
+
+// mapToModbusServer translates the `modbus-server` stream metadata, it returns nil if the key is missing
+func mapToModbusServer(metadata map[string]interface{}) (*ModbusServer, error) {
+	obj, ok := metadata["modbus-server"]
+	if !ok {
+		return nil, nil
+	}
+	serverMap, err := asObject(obj, "modbus-server")
+	if err != nil {
+		return nil, err
+	}
+	port, ok, err := getInteger(serverMap, "port", "modbus-server", 1, math.MaxUint16)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, newMetadataError("modbus-server.port", "required")
+	}
+	unitIdentifier, _, err := getUnitIdentifier(serverMap, "modbus-server")
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusServer{
+		Port:           port,
+		UnitIdentifier: unitIdentifier,
+	}, nil
+}
+
+// mapToRegisters translates the `registers` stream metadata
+func mapToRegisters(metadata map[string]interface{}) ([]Register, error) {
+	registerObjs, err := getArray(metadata, "registers", "")
+	if err != nil {
+		return nil, err
+	}
+	registers := make([]Register, 0, len(registerObjs))
+	for i, obj := range registerObjs {
+		path := indexPath("registers", i)
+		registerMap, err := asObject(obj, path)
+		if err != nil {
+			return nil, err
+		}
+		register := Register{Scale: 1, path: path}
+		var ok bool
+		if register.Field, ok, err = getString(registerMap, "field", path); err != nil {
+			return nil, err
+		} else if !ok || register.Field == "" {
+			return nil, newMetadataError(joinPath(path, "field"), "required non-empty string")
+		}
+		if register.Address, ok, err = getString(registerMap, "address", path); err != nil {
+			return nil, err
+		} else if !ok || register.Address == "" {
+			return nil, newMetadataError(joinPath(path, "address"), "required non-empty string")
+		}
+		if scale, ok, err := getNumber(registerMap, "scale", path); err != nil {
+			return nil, err
+		} else if ok {
+			register.Scale = scale
+		}
+		if register.Offset, _, err = getNumber(registerMap, "offset", path); err != nil {
+			return nil, err
+		}
+		registers = append(registers, register)
+	}
+	return registers, nil
+}
+
+// parseStreamMetadata translates and validates the metadata of a stream
+func parseStreamMetadata(stream *connectorpb.Stream) (*streamMetadata, error) {
+	metadata, err := mapToStreamMetadata(stream.GetMetadata().AsMap())
+	if err == nil {
+		err = validateStreamMetadata(metadata, stream.GetDirection())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata for stream %s: %w", stream.GetId(), err)
+	}
+	return metadata, nil
+}
+
+// validateStreamMetadata checks the metadata of a stream for the given direction: the
+// required keys, unique field names and every address against the driver that reads it
+func validateStreamMetadata(metadata *streamMetadata, direction connectorpb.StreamDirection) error {
+	if direction == connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS {
+		if metadata.ModbusServer == nil {
+			return nil
+		}
+		fields := make(map[string]bool)
+		for _, register := range metadata.Registers {
+			if fields[register.Field] {
+				return newMetadataError(joinPath(register.path, "field"), "duplicate field %q", register.Field)
+			}
+			fields[register.Field] = true
+			field, err := modbus.ParseField(register.Address)
+			if err != nil {
+				return newMetadataError(joinPath(register.path, "address"), "%s", err)
+			}
+			if err := checkServedField(field); err != nil {
+				return newMetadataError(joinPath(register.path, "address"), "%s", err)
+			}
+		}
+		return nil
+	}
+
+	if metadata.Plc == "" {
+		return newMetadataError("plc", "required non-empty string")
+	}
+	if len(metadata.Addresses) == 0 && len(metadata.Devices) == 0 {
+		return newMetadataError("addresses", "at least one address is required")
+	}
+	if err := checkUniqueNames(metadata.Addresses); err != nil {
+		return err
+	}
+	deviceNames := make(map[string]bool)
+	for _, device := range metadata.Devices {
+		if deviceNames[device.Name] {
+			return newMetadataError(joinPath(device.path, "name"), "duplicate device name %q", device.Name)
+		}
+		deviceNames[device.Name] = true
+		if err := checkUniqueNames(device.Addresses); err != nil {
+			return err
+		}
+	}
+	if metadata.TreeLayout {
+		if err := checkAssetTree(allAddresses(metadata)); err != nil {
+			return err
+		}
+	}
+
+	if usesModbusClient(metadata) {
+		if _, _, _, err := parseModbusConnectionString(metadata.Plc); err != nil {
+			return newMetadataError("plc", "%s", err)
+		}
+		return checkAddresses(metadata, func(address string) error {
+			field, err := modbus.ParseField(address)
+			if err != nil {
+				return err
+			}
+			return checkReadField(field)
+		})
+	}
+
+	u, err := url.Parse(metadata.Plc)
+	if err != nil {
+		return newMetadataError("plc", "%s", err)
+	}
+	driver, err := newDriverManager().GetDriver(u.Scheme)
+	if err != nil {
+		return newMetadataError("plc", "unsupported protocol %q", u.Scheme)
+	}
+	return checkAddresses(metadata, driver.CheckQuery)
+}
+
+// checkReadField checks that the in-tree Modbus client can read a field
+func checkReadField(field modbus.Field) error {
+	if !field.Area.Readable() {
+		return fmt.Errorf("reading %s is not supported", field.Area)
+	}
+	return nil
+}
+
+func checkUniqueNames(addresses []Address) error {
+	names := make(map[string]bool)
+	for _, addr := range addresses {
+		if names[addr.Name] {
+			return newMetadataError(joinPath(addr.path, "name"), "duplicate field name %q", addr.Name)
+		}
+		names[addr.Name] = true
+	}
+	return nil
+}
+
+func checkAddresses(metadata *streamMetadata, checkQuery func(string) error) error {
+	for _, addr := range allAddresses(metadata) {
+		if err := checkQuery(addr.Address); err != nil {
+			return newMetadataError(joinPath(addr.path, "address"), "%s", err)
+		}
+	}
+	return nil
+}
+
+// allAddresses returns the addresses of a stream and of its devices
+func allAddresses(metadata *streamMetadata) []Address {
+	addresses := metadata.Addresses
+	for _, device := range metadata.Devices {
+		addresses = append(addresses[:len(addresses):len(addresses)], device.Addresses...)
+	}
+	return addresses
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+func asObject(obj interface{}, path string) (map[string]interface{}, error) {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return nil, newMetadataError(path, "expected object, found %s", jsonType(obj))
+	}
+	return m, nil
+}
+
+func getString(obj map[string]interface{}, key, path string) (string, bool, error) {
+	v, ok := obj[key]
+	if !ok {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, newMetadataError(joinPath(path, key), "expected string, found %s", jsonType(v))
+	}
+	return s, true, nil
+}
+
+func getNumber(obj map[string]interface{}, key, path string) (float64, bool, error) {
+	v, ok := obj[key]
+	if !ok {
+		return 0, false, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, false, newMetadataError(joinPath(path, key), "expected number, found %s", jsonType(v))
+	}
+	return f, true, nil
+}
+
+func getBool(obj map[string]interface{}, key, path string) (bool, bool, error) {
+	v, ok := obj[key]
+	if !ok {
+		return false, false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, false, newMetadataError(joinPath(path, key), "expected boolean, found %s", jsonType(v))
+	}
+	return b, true, nil
+}
+
+func getInteger(obj map[string]interface{}, key, path string, min, max int) (int, bool, error) {
+	f, ok, err := getNumber(obj, key, path)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	if f != math.Trunc(f) || f < float64(min) || f > float64(max) {
+		return 0, false, newMetadataError(joinPath(path, key), "expected integer in %d..%d, found %v", min, max, f)
+	}
+	return int(f), true, nil
+}
+
+func getUnitIdentifier(obj map[string]interface{}, path string) (uint8, bool, error) {
+	unitIdentifier, ok, err := getInteger(obj, "unit-identifier", path, 0, math.MaxUint8)
+	return uint8(unitIdentifier), ok, err
+}
+
+func getArray(obj map[string]interface{}, key, path string) ([]interface{}, error) {
+	v, ok := obj[key]
+	if !ok {
+		return nil, nil
+	}
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, newMetadataError(joinPath(path, key), "expected array, found %s", jsonType(v))
+	}
+	return a, nil
+}
+
+// jsonType names the JSON type of a value decoded from a structpb.Struct
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,206 @@
+package connector
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+)
+
+// checkMetadata parses and validates the JSON stream metadata doc the way setStreams does
+func checkMetadata(doc string, direction connectorpb.StreamDirection) (*streamMetadata, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &m); err != nil {
+		return nil, err
+	}
+	metadata, err := mapToStreamMetadata(m)
+	if err != nil {
+		return nil, err
+	}
+	return metadata, validateStreamMetadata(metadata, direction)
+}
+
+func TestStreamMetadata(t *testing.T) {
+	metadata, err := checkMetadata(`{
+		"plc": "modbus-rtu:tcp://127.0.0.1:5020",
+		"addresses": [
+			{"name": "Speed", "address": "holding-register:1:INT"},
+			{"name": "Alarm", "address": "coil:1", "unit-identifier": 3},
+			{"name": "Fault", "address": "coil:2", "unit-identifier": 3}
+		],
+		"devices": [
+			{"name": "press", "unit-identifier": 4, "addresses": [{"name": "Speed", "address": "input-register:1:UINT"}]}
+		]
+	}`, connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Addresses) != 1 || metadata.Addresses[0].Name != "Speed" {
+		t.Errorf("Addresses = %+v, want Speed", metadata.Addresses)
+	}
+	if len(metadata.Devices) != 2 {
+		t.Fatalf("Devices = %+v, want unit-3 and press", metadata.Devices)
+	}
+	// the addresses with the same unit identifier form one device
+	if d := metadata.Devices[0]; d.Name != "unit-3" || d.UnitIdentifier != 3 || len(d.Addresses) != 2 {
+		t.Errorf("Devices[0] = %+v, want unit-3 with Alarm and Fault", d)
+	}
+	if d := metadata.Devices[1]; d.Name != "press" || d.UnitIdentifier != 4 || len(d.Addresses) != 1 {
+		t.Errorf("Devices[1] = %+v, want press with Speed", d)
+	}
+}
+
+func TestStreamMetadataErrors(t *testing.T) {
+	ingress := connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS
+	egress := connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS
+	tests := []struct {
+		name      string
+		doc       string
+		direction connectorpb.StreamDirection
+		path      string
+		err       string
+	}{
+		{"plc of the wrong type",
+			`{"plc": 1, "addresses": [{"name": "a", "address": "coil:1"}]}`,
+			ingress, "plc", "expected string, found number"},
+		{"addresses of the wrong type",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": {}}`,
+			ingress, "addresses", "expected array, found object"},
+		{"address of the wrong type",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": ["coil:1"]}`,
+			ingress, "addresses[0]", "expected object, found string"},
+		{"name of the wrong type",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": [{"name": true, "address": "coil:1"}]}`,
+			ingress, "addresses[0].name", "expected string, found boolean"},
+		{"unit identifier out of range",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": [{"name": "a", "address": "coil:1", "unit-identifier": 256}]}`,
+			ingress, "addresses[0].unit-identifier", "expected integer in 0..255, found 256"},
+		{"polling interval of the wrong type",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "polling-interval": "1s", "addresses": [{"name": "a", "address": "coil:1"}]}`,
+			ingress, "polling-interval", "expected number, found string"},
+		{"missing plc",
+			`{"addresses": [{"name": "a", "address": "coil:1"}]}`,
+			ingress, "plc", "required non-empty string"},
+		{"empty plc",
+			`{"plc": "", "addresses": [{"name": "a", "address": "coil:1"}]}`,
+			ingress, "plc", "required non-empty string"},
+		{"no addresses",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020"}`,
+			ingress, "addresses", "at least one address is required"},
+		{"duplicate names",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": [
+				{"name": "a", "address": "coil:1"},
+				{"name": "a", "address": "coil:2"}
+			]}`,
+			ingress, "addresses[1].name", `duplicate field name "a"`},
+		{"duplicate names in a device",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [
+				{"name": "press", "unit-identifier": 1, "addresses": [
+					{"name": "a", "address": "coil:1"},
+					{"name": "a", "address": "coil:2"}
+				]}
+			]}`,
+			ingress, "devices[0].addresses[1].name", `duplicate field name "a"`},
+		{"duplicate names of addresses with a unit identifier",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": [
+				{"name": "a", "address": "coil:1", "unit-identifier": 1},
+				{"name": "a", "address": "coil:2", "unit-identifier": 1}
+			]}`,
+			ingress, "addresses[1].name", `duplicate field name "a"`},
+		{"duplicate device names",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [
+				{"name": "press", "unit-identifier": 1, "addresses": [{"name": "a", "address": "coil:1"}]},
+				{"name": "press", "unit-identifier": 2, "addresses": [{"name": "a", "address": "coil:1"}]}
+			]}`,
+			ingress, "devices[1].name", `duplicate device name "press"`},
+		{"device named like the unit of an address",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020",
+				"addresses": [{"name": "a", "address": "coil:1", "unit-identifier": 1}],
+				"devices": [{"name": "unit-1", "unit-identifier": 2, "addresses": [{"name": "a", "address": "coil:1"}]}]
+			}`,
+			ingress, "devices[0].name", `duplicate device name "unit-1"`},
+		{"device unit of an address",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020",
+				"addresses": [
+					{"name": "a", "address": "coil:1"},
+					{"name": "b", "address": "coil:2", "unit-identifier": 7}
+				],
+				"devices": [{"name": "press", "unit-identifier": 7, "addresses": [{"name": "c", "address": "coil:1"}]}]
+			}`,
+			ingress, "devices[0].unit-identifier", "unit 7 is also the unit-identifier of addresses[1]"},
+		{"device without unit identifier",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [{"name": "press", "addresses": [{"name": "a", "address": "coil:1"}]}]}`,
+			ingress, "devices[0].unit-identifier", "required"},
+		{"device without addresses",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [{"name": "press", "unit-identifier": 1, "addresses": []}]}`,
+			ingress, "devices[0].addresses", "at least one address is required"},
+		{"invalid address",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": [{"name": "a", "address": "coil:1"}, {"name": "b", "address": "register:1"}]}`,
+			ingress, "addresses[1].address", "invalid address format"},
+		{"invalid address in a device",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [
+				{"name": "press", "unit-identifier": 1, "addresses": [
+					{"name": "a", "address": "coil:1"},
+					{"name": "b", "address": "holding-register:1:FOO"}
+				]}
+			]}`,
+			ingress, "devices[0].addresses[1].address", "FOO"},
+		{"missing address in a device",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [
+				{"name": "press", "unit-identifier": 1, "addresses": [{"name": "a"}]}
+			]}`,
+			ingress, "devices[0].addresses[0].address", "required non-empty string"},
+		{"extended register",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "addresses": [{"name": "a", "address": "extended-register:1:INT"}]}`,
+			ingress, "addresses[0].address", "reading extended-register is not supported"},
+		{"extended register in a device",
+			`{"plc": "modbus-rtu:tcp://127.0.0.1:5020", "devices": [
+				{"name": "press", "unit-identifier": 1, "addresses": [{"name": "a", "address": "600001:INT"}]}
+			]}`,
+			ingress, "devices[0].addresses[0].address", "reading extended-register is not supported"},
+		{"invalid modbus connection string",
+			`{"plc": "modbus-rtu:udp://127.0.0.1:5020", "addresses": [{"name": "a", "address": "coil:1"}]}`,
+			ingress, "plc", `unsupported transport "udp"`},
+		{"unsupported protocol",
+			`{"plc": "foo://127.0.0.1", "addresses": [{"name": "a", "address": "coil:1"}]}`,
+			ingress, "plc", `unsupported protocol "foo"`},
+		{"registers of the wrong type",
+			`{"modbus-server": {"port": 5502}, "registers": {}}`,
+			egress, "registers", "expected array, found object"},
+		{"register without field",
+			`{"modbus-server": {"port": 5502}, "registers": [{"address": "holding-register:1:INT"}]}`,
+			egress, "registers[0].field", "required non-empty string"},
+		{"register scale of the wrong type",
+			`{"modbus-server": {"port": 5502}, "registers": [{"field": "a", "address": "holding-register:1:INT", "scale": "2"}]}`,
+			egress, "registers[0].scale", "expected number, found string"},
+		{"duplicate register fields",
+			`{"modbus-server": {"port": 5502}, "registers": [
+				{"field": "a", "address": "holding-register:1:INT"},
+				{"field": "a", "address": "holding-register:2:INT"}
+			]}`,
+			egress, "registers[1].field", `duplicate field "a"`},
+		{"invalid register address",
+			`{"modbus-server": {"port": 5502}, "registers": [{"field": "a", "address": "holding-register:x"}]}`,
+			egress, "registers[0].address", "invalid address format"},
+		{"served array",
+			`{"modbus-server": {"port": 5502}, "registers": [{"field": "a", "address": "holding-register:1:INT[2]"}]}`,
+			egress, "registers[0].address", "only single values can be served"},
+		{"served extended register",
+			`{"modbus-server": {"port": 5502}, "registers": [{"field": "a", "address": "extended-register:1:INT"}]}`,
+			egress, "registers[0].address", "serving extended-register is not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := checkMetadata(tt.doc, tt.direction)
+			var metadataErr *metadataError
+			if !errors.As(err, &metadataErr) {
+				t.Fatalf("checkMetadata = %v, want an error at %s", err, tt.path)
+			}
+			if metadataErr.Path != tt.path || !strings.Contains(metadataErr.Message, tt.err) {
+				t.Errorf("checkMetadata = %q, want %s: %q", err, tt.path, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package connector
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+const metricsNamespace = "plc4xconnector"
+
+var (
+	plcReadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_read_duration_seconds",
+		Help:      "Duration of a poll cycle reading all addresses of a stream from its PLC.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"stream", "plc"})
+	plcReadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_reads_total",
+		Help:      "Poll cycles of a stream by result, `ok`, `timeout` or `error`.",
+	}, []string{"stream", "plc", "result"})
+	plcReconnectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_reconnects_total",
+		Help:      "Connections to a PLC that were re-established after being lost.",
+	}, []string{"stream", "plc"})
+	plcConnected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_connected",
+		Help:      "Whether the connection of a stream to its PLC is up (1) or down (0).",
+	}, []string{"stream", "plc"})
+	pollOverrunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "poll_overruns_total",
+		Help:      "Poll cycles that took longer than the polling interval of the stream.",
+	}, []string{"stream"})
+	pollIntervalSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "poll_interval_seconds",
+		Help:      "Polling interval in effect for a stream, as scaled by adaptive polling.",
+	}, []string{"stream"})
+	pollJitterSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "poll_jitter_seconds",
+		Help:      "How late a poll cycle started after it was due, one polling interval after the previous cycle or at its slot.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"stream"})
+	pollLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "poll_last_success_timestamp_seconds",
+		Help:      "Unix time of the last poll cycle of a stream that published its message without a failure.",
+	}, []string{"stream"})
+	streamRestartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "stream_restarts_total",
+		Help:      "Restarts of an ingress stream by the watchdog after its poll cycles stalled.",
+	}, []string{"stream"})
+	scheduleSlotsMissedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "schedule_slots_missed_total",
+		Help:      "Slots of a stream with a schedule that were not read, by reason `restart`, `skipped` or `failed`.",
+	}, []string{"stream", "reason"})
+	plcRequestQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_request_queue_wait_seconds",
+		Help:      "Time a request waited for its device to admit it, by priority `alarm` or `poll`.",
+		Buckets:   []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"device", "priority"})
+	plcRequestsQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_requests_queued",
+		Help:      "Requests waiting for their device to admit them, by priority.",
+	}, []string{"device", "priority"})
+	plcRequestsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plc_requests_in_flight",
+		Help:      "Requests sent to a device and not yet answered.",
+	}, []string{"device"})
+	sharedReadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "shared_reads_total",
+		Help:      "Values of a stream taken from the read of another stream polling the same address at the same rate.",
+	}, []string{"stream"})
+	badQualityTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bad_quality_total",
+		Help:      "Reads of a field that failed or returned a non-OK response code.",
+	}, []string{"stream", "field"})
+	samplesPublishedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "samples_published_total",
+		Help:      "Field values published to the transport by an ingress stream.",
+	}, []string{"stream"})
+	messagesPublishedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_published_total",
+		Help:      "Messages published to the transport by an ingress stream.",
+	}, []string{"stream"})
+	writesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "writes_total",
+		Help:      "Messages of an egress stream written to its data service, by result `ok`, `error`, `timeout` or `disarmed`.",
+	}, []string{"stream", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		plcReadDuration,
+		plcReadsTotal,
+		plcReconnectsTotal,
+		plcConnected,
+		pollOverrunsTotal,
+		pollIntervalSeconds,
+		pollJitterSeconds,
+		pollLastSuccessTimestamp,
+		streamRestartsTotal,
+		scheduleSlotsMissedTotal,
+		plcRequestQueueWait,
+		plcRequestsQueued,
+		plcRequestsInFlight,
+		sharedReadsTotal,
+		badQualityTotal,
+		samplesPublishedTotal,
+		messagesPublishedTotal,
+		writesTotal,
+	)
+}
+
+// deleteStreamMetrics drops the gauges of a stream that has stopped, so that it doesn't keep
+// reporting its last connection state and poll
+func deleteStreamMetrics(streamID, plc string) {
+	plcConnected.DeleteLabelValues(streamID, plc)
+	pollIntervalSeconds.DeleteLabelValues(streamID)
+	pollLastSuccessTimestamp.DeleteLabelValues(streamID)
+}
+
+// boolToFloat converts a state into a gauge value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// MetricsHandler serves the metrics of the default prometheus registry in the text exposition format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			// like promhttp, serve what could be gathered
+			errorf("error gathering metrics: %s", err)
+		}
+		format := expfmt.Negotiate(r.Header)
+		w.Header().Set("Content-Type", string(format))
+		encoder := expfmt.NewEncoder(w, format)
+		for _, family := range families {
+			if err := encoder.Encode(family); err != nil {
+				errorf("error encoding metrics: %s", err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package connector
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the metrics served by MetricsHandler
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	plc := "modbus:tcp://10.0.0.1:502"
+	// the counters outlive the stream, drop the count of an earlier run of the test
+	plcReadsTotal.DeleteLabelValues("metrics-s1", plc, "timeout")
+	plcConnected.WithLabelValues("metrics-s1", plc).Set(boolToFloat(true))
+	plcReadsTotal.WithLabelValues("metrics-s1", plc, "timeout").Inc()
+	pollIntervalSeconds.WithLabelValues("metrics-s1").Set(0.5)
+
+	body := scrape(t)
+	for _, want := range []string{
+		`plc4xconnector_plc_connected{plc="modbus:tcp://10.0.0.1:502",stream="metrics-s1"} 1`,
+		`plc4xconnector_plc_reads_total{plc="modbus:tcp://10.0.0.1:502",result="timeout",stream="metrics-s1"} 1`,
+		`plc4xconnector_poll_interval_seconds{stream="metrics-s1"} 0.5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+
+	// a stopped stream drops its gauges and keeps its counters
+	deleteStreamMetrics("metrics-s1", plc)
+	body = scrape(t)
+	if strings.Contains(body, `plc4xconnector_plc_connected{plc="modbus:tcp://10.0.0.1:502",stream="metrics-s1"}`) ||
+		strings.Contains(body, `plc4xconnector_poll_interval_seconds{stream="metrics-s1"}`) {
+		t.Error("the gauges of the stopped stream are still served")
+	}
+	if !strings.Contains(body, `plc4xconnector_plc_reads_total{plc="modbus:tcp://10.0.0.1:502",result="timeout",stream="metrics-s1"} 1`) {
+		t.Error("the counters of the stopped stream are dropped")
+	}
+}
@@ -2,189 +2,819 @@ package connector
 
 import (
 	"context"
-	"log"
-	"time"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nutanix/kps-connector-go-sdk/transport"
+	"github.com/nutanix/kps-connector-go-template/tracing"
+	"github.com/sirupsen/logrus"
 
 	"github.com/apache/plc4x/plc4go/pkg/plc4go"
 	"github.com/apache/plc4x/plc4go/pkg/plc4go/drivers"
 	"github.com/apache/plc4x/plc4go/pkg/plc4go/model"
 	"github.com/apache/plc4x/plc4go/pkg/plc4go/transports"
-
 )
 
-type Address struct {
-	Name    string
-	Address string
-}
+type rvalue struct {
+	Field []string `json:"field,omitempty"`
+	Value []string `json:"value,omitempty"`
 
-type rvalue struct{
-	Field []string `json:"field"`
-	Value []string `json:"value"`
-}
+	// Asset lists the asset paths of the fields, only for streams with asset paths
+	Asset []string `json:"asset,omitempty"`
+
+	// Devices holds the results per unit when reading through a Modbus gateway
+	Devices []deviceValue `json:"devices,omitempty"`
 
+	// Tree nests the values at their asset paths instead of Field, Value, Asset and Devices
+	// for streams with `layout: tree`, and Errors holds the errors of the devices by name
+	Tree   map[string]interface{} `json:"tree,omitempty"`
+	Errors map[string]string      `json:"errors,omitempty"`
+	// Context holds the `context` labels of the stream
+	Context map[string]string `json:"context,omitempty"`
 
-type streamMetadata struct {
-	Plc       string
-	Addresses []Address
+	// Slot and ReadTime are the slot of the schedule the values were read for, and when they
+	// were read. They are only set for streams with a schedule.
+	Slot     string `json:"slot,omitempty"`
+	ReadTime string `json:"read-time,omitempty"`
 
-	
-	//PollingIntervall int  // removed for testing
+	// TraceParent is the W3C trace context of the poll cycle, so that a pipeline can continue
+	// its trace. It is only set while tracing is enabled.
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
-// mapToStreamMetadata translates the stream metadata into the corresponding streamMetadata struct
-func mapToStreamMetadata(metadata map[string]interface{}) *streamMetadata {
-	plc := metadata["plc"].(string)
-	addressObjs, ok := metadata["addresses"].([]interface{})
-	if !ok {
-		log.Printf("unable to type infer Addresses: expected []interface found %T", metadata["addresses"])
+// spanAttributes are the attributes of the spans of a stream
+func spanAttributes(streamID, plc string) []tracing.Attribute {
+	return []tracing.Attribute{tracing.String("stream.id", streamID), tracing.String("plc.url", plc)}
+}
+
+// encodeMessage encodes the values of a poll cycle with its slot and the trace context of ctx,
+// the span of the cycle. It records the digest of the values in cycle, which doesn't depend on
+// the slot or the trace. A cycle that wraps its message in an envelope leaves the slot and the
+// trace context to the envelope.
+func encodeMessage(ctx context.Context, cycle *pollCycle, v *rvalue, attributes ...tracing.Attribute) ([]byte, error) {
+	_, span := tracing.Start(ctx, "encode", tracing.KindInternal, attributes...)
+	defer span.End()
+	v.Slot, v.ReadTime, v.TraceParent = "", "", ""
+	data, err := json.Marshal(v)
+	if err == nil {
+		cycle.setDigest(valuesDigest(data))
 	}
-	addresses := make([]Address, 0, len(addressObjs))
-	for _, obj := range addressObjs {
-		addressMap, ok := obj.(map[string]interface{})
-		if !ok {
-			// bail out
+	if err == nil && !cycle.envelope {
+		if !cycle.slot.IsZero() {
+			v.Slot = formatSlot(cycle.slot)
+			v.ReadTime = formatSlot(cycle.readTime.In(cycle.slot.Location()))
 		}
-		addressName, ok := addressMap["name"].(string)
-		if !ok {
-			// bail out
+		v.TraceParent = tracing.TraceParent(ctx)
+		if v.Slot != "" || v.TraceParent != "" {
+			data, err = json.Marshal(v)
 		}
-		log.Printf("add Name: %s", addressName)
-		addressAddress, ok := addressMap["address"].(string)
-		if !ok {
-			// bail out
-		}
-		log.Printf("add Address: %s", addressAddress)
-		
-		addr := Address{
-			Name: addressName,
-			Address: addressAddress,
-		}
-		addresses = append(addresses, addr)
 	}
+	span.SetAttributes(tracing.Int("message.size", len(data)))
+	span.RecordError(err)
+	return data, err
+}
 
+// newDriverManager returns a plc4go driver manager with the drivers supported by the connector
+func newDriverManager() plc4go.PlcDriverManager {
+	driverManager := plc4go.NewPlcDriverManager()
+	transports.RegisterTcpTransport(driverManager)
+	drivers.RegisterModbusDriver(driverManager)
+	return driverManager
+}
+
+const (
+	// plcCloseTimeout bounds how long closing a plc4go connection may take
+	plcCloseTimeout = 5 * time.Second
+	// defaultConnectTimeout is used for streams without `connect-timeout`
+	defaultConnectTimeout = 10 * time.Second
+	// defaultReadTimeout is used for streams without `read-timeout`, except for gateways, see gatewayRequestTimeout
+	defaultReadTimeout = 5 * time.Second
+	// defaultWriteTimeout is used for streams without `write-timeout`
+	defaultWriteTimeout = 5 * time.Second
+	// minConnectBackoff and maxConnectBackoff bound the time between two attempts to connect to
+	// a PLC that can't be reached, it doubles with every failed attempt
+	minConnectBackoff = 1 * time.Second
+	maxConnectBackoff = 30 * time.Second
+)
+
+// connectBackoff spaces the attempts to connect to a PLC that can't be reached, so that not
+// every poll waits for the connect timeout. It is not safe for concurrent use.
+type connectBackoff struct {
+	delay time.Duration
+	next  time.Time
+	// err is the error of the last attempt, nil if it succeeded
+	err error
+}
+
+// wait returns the error of the last attempt if the next one is not due yet at now
+func (b *connectBackoff) wait(now time.Time) error {
+	if b.err == nil || !now.Before(b.next) {
+		return nil
+	}
+	return fmt.Errorf("%w (connecting again in %s)", b.err, b.next.Sub(now).Round(time.Millisecond))
+}
 
-	return &streamMetadata{
-		Plc: plc,
-		Addresses: addresses,		
+// done records the outcome of an attempt made at now. An attempt abandoned because the stream
+// is stopping doesn't count.
+func (b *connectBackoff) done(now time.Time, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
 	}
+	if err == nil {
+		*b = connectBackoff{}
+		return
+	}
+	b.delay *= 2
+	if b.delay < minConnectBackoff {
+		b.delay = minConnectBackoff
+	}
+	if b.delay > maxConnectBackoff {
+		b.delay = maxConnectBackoff
+	}
+	b.next = now.Add(b.delay)
+	b.err = err
 }
 
 type consumer struct {
-	transport  transport.Client
-	metadata   *streamMetadata
-	connection *plc4go.PlcConnection    //?????
-	rr         model.PlcReadRequest // ???? ReadRequest}
+	streamID  string
+	plc       string
+	addresses []Address
+	// name and plcRedacted identify the stream and its PLC in the message envelopes
+	name        string
+	plcRedacted string
+	// layout shapes the messages with the context labels and asset paths
+	layout *messageLayout
+
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	// stallTimeout is how long the loop may make no progress before the watchdog restarts the stream
+	stallTimeout time.Duration
+	// loop is the progress of the consumer loop, checked by the watchdog
+	loop loopState
+	// adaptive is set for streams with `adaptive-polling`
+	adaptive *adaptiveInterval
+	// schedule is set for streams with a `schedule`, they poll on its slots
+	schedule *ScanSchedule
+
+	// scheduler admits the read-requests to the PLC, with priority
+	scheduler *deviceScheduler
+	priority  requestPriority
+
+	// connMtx guards connection, rr and backoff. The consumer loop connects when it starts,
+	// the connection is dropped after a read timed out and set again by the next poll.
+	connMtx       sync.Mutex
+	driverManager plc4go.PlcDriverManager
+	connection    plc4go.PlcConnection
+	rr            model.PlcReadRequest
+	backoff       connectBackoff
+	// hasConnected is set once the first connection succeeded, later ones are reconnects
+	hasConnected bool
+
+	// gateway is set instead of rr for streams read with the in-tree Modbus client, see usesModbusClient
+	gateway *modbusGateway
+
+	// pollingInterval is accessed atomically, so it can be updated while the stream is running
+	pollingInterval int64
+	// health holds the error of the last poll cycle
+	health healthState
+	events *streamEvents
+	stats  *streamStats
+
+	log     *logrus.Entry
+	sampler *logSampler
 }
 
 // producer consumes the data from the relevant client or service and publishes them to KPS data pipelines
-func newConsumer() *consumer {
-	// TODO: Add the relevant clients and fields
-	return &consumer{}
+func newConsumer(streamID string, events *streamEvents) *consumer {
+	return &consumer{streamID: streamID, events: events, stats: newStreamStats(), log: streamLogger(streamID), sampler: newLogSampler()}
 }
 
 // nextMsg wraps the logic for consuming iteratively a transport.Message
-// from the relevant client or service
-func (c *consumer) nextMsg() ([]byte, error) {
-
+// from the relevant client or service. The samples read and the failures observed are
+// recorded in cycle, ctx carries the trace of the cycle. The addresses that other streams
+// read for the cycle are not read again, see sharedReadCache.
+func (c *consumer) nextMsg(ctx context.Context, cycle *pollCycle) ([]byte, error) {
+	if c.gateway != nil {
+		return c.gateway.nextMsg(ctx, cycle)
+	}
+	queries := make([]string, len(c.addresses))
+	for i, address := range c.addresses {
+		queries[i] = address.Address
+	}
+	share := cycle.shareReads(c.plc, queries)
+	defer share.abandon()
+	values, errs, err := c.readOwn(ctx, share)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		// the stream is stopping
+		return nil, ctx.Err()
+	}
 
+	rvalues := make([]string, 0)
+	rfields := make([]string, 0)
+	rassets := make([]string, 0)
 
-	// manual slow down Polling
-	time.Sleep(5 * time.Second )
-	rrc := c.rr.Execute()
-	log.Printf("nexmsg")
-	// Wait for the response to finish
-	rrr := <-rrc
-	if rrr.Err != nil {
-		log.Printf("error executing read-request: %s", rrr.Err.Error())
-		return nil, nil
-	}
-
-	// Do something with the response
-	
-	rvalues := make([]string,0)
-	rfields := make([]string,0)
-	
-	for _, fieldname := range rrr.Response.GetFieldNames() {
-		log.Printf("processing field: %s", fieldname)
-		if rrr.Response.GetResponseCode(fieldname) != model.PlcResponseCode_OK {
-			log.Printf("error an non-ok return code: %s", rrr.Response.GetResponseCode(fieldname).GetName())
+	waitCtx, cancel := context.WithTimeout(ctx, c.readTimeout)
+	defer cancel()
+	for i, address := range c.addresses {
+		fieldname := address.Name
+		value, err := values[i], errs[i]
+		if !share.owns(i) {
+			var started time.Time
+			value, started, err = share.wait(waitCtx, i, c.readTimeout)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			cycle.readAt(started)
+			sharedReadsTotal.WithLabelValues(c.streamID).Inc()
+		}
+		var codeErr *responseCodeError
+		if errors.As(err, &codeErr) {
+			c.sampler.logf(c.log.WithField("field", fieldname), logrus.WarnLevel, "response:"+fieldname, "error a non-ok return code: %s", codeErr.code.GetName())
+			badQualityTotal.WithLabelValues(c.streamID, fieldname).Inc()
+			cycle.fail(responseCodeCondition(fieldname, codeErr.code))
+			return nil, nil
+		}
+		if err != nil {
+			c.sampler.logf(c.log, logrus.WarnLevel, "read", "error executing read-request: %s", err)
+			cycle.fail(classifyError("read", err))
 			return nil, nil
 		}
-	
-		value := rrr.Response.GetValue(fieldname)
-		log.Printf("Returned Value: %s", value.GetString())
-		rfields = append(rfields,fieldname)
-		rvalues = append(rvalues,value.GetString())
+		c.sampler.logf(c.log.WithFields(logrus.Fields{"field": fieldname, "value": value}), logrus.DebugLevel, "value:"+fieldname, "read field")
+		rfields = append(rfields, fieldname)
+		rvalues = append(rvalues, value)
+		rassets = append(rassets, assetName(address))
 	}
-	
+
 	toMarshal := &rvalue{
 		Field: rfields,
 		Value: rvalues,
+		Asset: rassets,
 	}
-	
-	return json.Marshal(toMarshal)
+	c.layout.apply(toMarshal)
+
+	cycle.addSamples(len(rvalues))
+	return encodeMessage(ctx, cycle, toMarshal, spanAttributes(c.streamID, c.plc)...)
 }
 
-// subscribe wraps the logic to connect or subscribe to the corresponding stream
-// from the relevant client or service
-func (c *consumer) subscribe(ctx context.Context, metadata *streamMetadata) error {
+// readOwn reads the addresses the cycle owns and shares their results. It returns the value
+// or the error of every address it read, and an error if it could not connect to the PLC.
+func (c *consumer) readOwn(ctx context.Context, share *readShare) ([]string, []error, error) {
+	values := make([]string, len(c.addresses))
+	errs := make([]error, len(c.addresses))
+	own := make([]int, 0, len(c.addresses))
+	for i := range c.addresses {
+		if share.owns(i) {
+			own = append(own, i)
+		}
+	}
 
-	driverManager := plc4go.NewPlcDriverManager()
-	transports.RegisterTcpTransport(driverManager)
-	drivers.RegisterModbusDriver(driverManager)
+	// the connection is dropped after a read timed out, it is re-established by the next poll,
+	// even if it reads nothing itself, so that the stream reports the state of its PLC
+	rr, reconnected, err := c.readRequest(ctx)
+	if reconnected {
+		plcReconnectsTotal.WithLabelValues(c.streamID, c.plc).Inc()
+	}
+	if err == nil && len(own) == 0 {
+		return values, errs, nil
+	}
+	if err == nil && len(own) < len(c.addresses) {
+		rr, err = c.partialReadRequest(own)
+	}
+	if err != nil {
+		for _, i := range own {
+			share.complete(i, "", err)
+		}
+		return nil, nil, err
+	}
+
+	_, span := tracing.Start(ctx, "plc.read", tracing.KindClient, spanAttributes(c.streamID, c.plc)...)
+	rrr, err := c.execute(ctx, rr)
+	span.RecordError(err)
+	span.End()
+	if ctx.Err() != nil {
+		// the stream is stopping, the reads are abandoned
+		return values, errs, nil
+	}
+	if isTimeout(err) {
+		// the connection may be half-open, don't send the next read-request over it
+		c.dropConnection()
+	}
+	for _, i := range own {
+		name := c.addresses[i].Name
+		if err != nil {
+			errs[i] = err
+		} else if code := rrr.Response.GetResponseCode(name); code != model.PlcResponseCode_OK {
+			errs[i] = &responseCodeError{code: code}
+		} else {
+			values[i] = rrr.Response.GetValue(name).GetString()
+		}
+		share.complete(i, values[i], errs[i])
+	}
+	return values, errs, nil
+}
+
+// partialReadRequest returns a read-request of some addresses of the stream, those the cycle
+// doesn't share with other streams
+func (c *consumer) partialReadRequest(indexes []int) (model.PlcReadRequest, error) {
+	c.connMtx.Lock()
+	defer c.connMtx.Unlock()
+	if c.connection == nil {
+		return nil, errors.New("the connection to the PLC was dropped")
+	}
+	rrb := c.connection.ReadRequestBuilder()
+	for _, i := range indexes {
+		rrb.AddItem(c.addresses[i].Name, c.addresses[i].Address)
+	}
+	readRequest, err := rrb.Build()
+	if err != nil {
+		return nil, fmt.Errorf("error preparing read-request: %w", err)
+	}
+	return readRequest, nil
+}
+
+// responseCodeError is the non-OK response code of a field, it is shared with the streams
+// that read the field like a value
+type responseCodeError struct {
+	code model.PlcResponseCode
+}
+
+func (e *responseCodeError) Error() string {
+	return e.code.GetName()
+}
+
+// responseCodeCondition maps the non-OK response code of a field to the alert it signals
+func responseCodeCondition(field string, code model.PlcResponseCode) condition {
+	alert := fieldBadQualityAlert
+	if code == model.PlcResponseCode_INVALID_ADDRESS {
+		alert = plcInvalidAddressAlert
+	}
+	return condition{
+		alert: alert,
+		key:   field,
+		err:   fmt.Errorf("field %s: %s", field, code.GetName()),
+		extra: map[string]interface{}{"field": field, "responseCode": code.GetName()},
+	}
+}
+
+// healthy reports whether the PLC is connected and the last poll cycle succeeded
+func (c *consumer) healthy() bool {
+	return c.health.healthy() && c.connected()
+}
+
+// connected reports whether the connection to the PLC is currently up
+func (c *consumer) connected() bool {
+	if c.gateway != nil {
+		return c.gateway.connected()
+	}
+	c.connMtx.Lock()
+	defer c.connMtx.Unlock()
+	return c.connection != nil && isConnected(c.connection)
+}
+
+// isConnected asks a connection whether it is connected. Not every plc4go driver implements
+// IsConnected, those that panic are assumed to be connected while the connection is open.
+func isConnected(connection plc4go.PlcConnection) (connected bool) {
+	defer func() {
+		if recover() != nil {
+			connected = true
+		}
+	}()
+	return connection.IsConnected()
+}
+
+// subscribe prepares the consumer to read the stream. It doesn't connect to the PLC, the
+// consumer loop does, see open.
+func (c *consumer) subscribe(metadata *streamMetadata) error {
+	c.plc = metadata.Plc
+	c.addresses = metadata.Addresses
+	c.layout = newMessageLayout(metadata)
+	c.plcRedacted = redactConnectionString(c.plc)
+	c.name = metadata.Name
+	if c.name == "" {
+		c.name = c.streamID
+	}
+	c.connectTimeout = orDefault(metadata.ConnectTimeout, defaultConnectTimeout)
+	c.readTimeout = orDefault(metadata.ReadTimeout, defaultReadTimeout)
+	c.stallTimeout = metadata.StallTimeout
+	c.scheduler = schedulers.forDevice(deviceAddress(metadata.Plc))
+	c.priority = metadata.Priority
+	c.log = c.log.WithField("plc", metadata.Plc)
+	c.setPollingInterval(metadata.PollingInterval)
+	if metadata.AdaptivePolling != nil {
+		c.adaptive = newAdaptiveInterval(*metadata.AdaptivePolling)
+	}
+	c.schedule = metadata.Schedule
+	if usesModbusClient(metadata) {
+		gateway, err := newModbusGateway(c.streamID, metadata, c.log, c.sampler)
+		if err != nil {
+			return err
+		}
+		c.gateway = gateway
+		return nil
+	}
+
+	c.driverManager = newDriverManager()
+	return nil
+}
+
+// open connects to the PLC when the consumer loop starts, so that the stream reports the state
+// of its PLC before the first poll
+func (c *consumer) open(ctx context.Context) error {
+	if c.gateway != nil {
+		_, err := c.gateway.connect(ctx)
+		return err
+	}
+	_, _, err := c.readRequest(ctx)
+	return err
+}
+
+// readRequest returns the read-request of the stream, connecting to the PLC first if there is
+// no connection and the backoff after a failed attempt has passed. It reports whether it had
+// to connect again after the connection was dropped.
+func (c *consumer) readRequest(ctx context.Context) (model.PlcReadRequest, bool, error) {
+	c.connMtx.Lock()
+	defer c.connMtx.Unlock()
+	if c.rr != nil {
+		return c.rr, false, nil
+	}
+	if err := c.backoff.wait(time.Now()); err != nil {
+		return nil, false, err
+	}
+	err := c.connect(ctx)
+	c.backoff.done(time.Now(), err)
+	if err != nil {
+		return nil, false, err
+	}
+	reconnected := c.hasConnected
+	c.hasConnected = true
+	return c.rr, reconnected, nil
+}
+
+// connect connects to the PLC and prepares the read-request, giving up after the connect
+// timeout or when ctx is done. connMtx must be held.
+func (c *consumer) connect(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.connectTimeout)
+	defer cancel()
 
 	// Get a connection to a remote PLC
-	crc := driverManager.GetConnection(metadata.Plc)
-	 // Wait for the driver to connect (or not)
-	connectionResult := <-crc
+	_, span := tracing.Start(ctx, "plc.connect", tracing.KindClient, spanAttributes(c.streamID, c.plc)...)
+	defer span.End()
+	crc := c.driverManager.GetConnection(c.plc)
+	// Wait for the driver to connect (or not)
+	var connectionResult plc4go.PlcConnectionConnectResult
+	select {
+	case connectionResult = <-crc:
+	case <-ctx.Done():
+		// plc4go answers on an unbuffered channel, a connection it still makes is closed right away
+		go func() {
+			if result := <-crc; result.Err == nil {
+				closeConnection(c.log, result.Connection)
+			}
+		}()
+		err := fmt.Errorf("error connecting to PLC: %w", contextError(ctx, c.connectTimeout))
+		span.RecordError(err)
+		return err
+	}
 	if connectionResult.Err != nil {
-		log.Printf("error connecting to PLC: %s", connectionResult.Err.Error())
-		return nil
+		span.RecordError(connectionResult.Err)
+		return fmt.Errorf("error connecting to PLC: %w", connectionResult.Err)
 	}
 	connection := connectionResult.Connection
 
-	// Make sure the connection is closed at the end
-	defer connection.Close()
-
 	// Prepare a read-request
 	rrb := connection.ReadRequestBuilder()
 	//rrb.AddItem("field1","holding-register:4:INT")
-	for _, address := range metadata.Addresses {
-		log.Printf("added name: %s", address.Name)
-		log.Printf("added address: %s", address.Address)
+	for _, address := range c.addresses {
+		c.log.WithFields(logrus.Fields{"field": address.Name, "address": address.Address}).Debug("added field to read-request")
 		rrb.AddItem(address.Name, address.Address)
 	}
-	
+
 	readRequest, err := rrb.Build()
 	if err != nil {
-		log.Printf("error preparing read-request: %s", connectionResult.Err.Error())
-		return nil
+		closeConnection(c.log, connection)
+		return fmt.Errorf("error preparing read-request: %w", err)
 	}
+	// the connection is kept open for the reads and closed by close
+	c.connection = connection
 	c.rr = readRequest
 	return nil
 }
 
+// execute executes the read-request once the scheduler of the PLC admits it, giving up after
+// the read timeout or when ctx is done. Waiting for the scheduler has a read timeout of its own.
+func (c *consumer) execute(ctx context.Context, rr model.PlcReadRequest) (model.PlcReadRequestResult, error) {
+	queueCtx, cancelQueue := context.WithTimeout(ctx, c.readTimeout)
+	release, err := c.scheduler.acquire(queueCtx, c.priority)
+	cancelQueue()
+	if err != nil {
+		return model.PlcReadRequestResult{}, err
+	}
+	// a read that timed out is released as well, its connection is dropped
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, c.readTimeout)
+	defer cancel()
+	rrc := rr.Execute()
+	// Wait for the response to finish
+	select {
+	case rrr := <-rrc:
+		return rrr, rrr.Err
+	case <-ctx.Done():
+		// plc4go answers on an unbuffered channel, drain it so that its goroutine can finish
+		go func() { <-rrc }()
+		return model.PlcReadRequestResult{}, contextError(ctx, c.readTimeout)
+	}
+}
+
+// dropConnection closes the connection, the next poll connects again
+func (c *consumer) dropConnection() {
+	c.connMtx.Lock()
+	connection := c.connection
+	c.connection = nil
+	c.rr = nil
+	c.connMtx.Unlock()
+	if connection != nil {
+		closeConnection(c.log, connection)
+	}
+}
+
+// setPollingInterval changes the time between two reads, it takes effect with the next read
+func (c *consumer) setPollingInterval(interval time.Duration) {
+	atomic.StoreInt64(&c.pollingInterval, int64(interval))
+}
+
+// getPollingInterval returns the polling interval of the stream, or the default of the dynamic
+// config if the stream doesn't set one
+func (c *consumer) getPollingInterval() time.Duration {
+	if interval := time.Duration(atomic.LoadInt64(&c.pollingInterval)); interval != 0 {
+		return interval
+	}
+	return getDynamicConfig().DefaultPollingInterval
+}
+
+// nextInterval returns the time until the next poll: the polling interval of the stream, as
+// scaled by adaptive polling
+func (c *consumer) nextInterval() time.Duration {
+	if c.adaptive == nil {
+		return c.getPollingInterval()
+	}
+	return c.adaptive.interval(c.getPollingInterval())
+}
+
+// firstSlot returns the slot the first cycle of a stream with a schedule reads, the first
+// one after now, and reports the slots missed since the stream last read one. It returns
+// the zero time for a stream without a schedule.
+func (c *consumer) firstSlot(now time.Time) time.Time {
+	if c.schedule == nil {
+		return time.Time{}
+	}
+	if last, ok := lastSlots.last(c.streamID, c.schedule.Spec); ok {
+		if missed := passedSlots(c.schedule, last, now); missed.count > 0 {
+			c.events.raise(c.missedSlotsCondition("restart", missed))
+		}
+	}
+	return c.schedule.Next(now)
+}
+
+// advance moves a stream with a schedule on to the next slot, and records the slot of the
+// cycle if it was read. If the cycle ended after further slots passed, the latest of them is
+// read right away and those before it are reported as skipped.
+func (c *consumer) advance(now time.Time, read bool) {
+	if c.schedule == nil {
+		return
+	}
+	slot := c.loop.nextSlot()
+	if read {
+		lastSlots.record(c.streamID, c.schedule.Spec, slot)
+	}
+	next := c.schedule.Next(slot)
+	var skipped missedSlots
+	for {
+		after := c.schedule.Next(next)
+		if after.IsZero() || after.After(now) {
+			break
+		}
+		if skipped.count == maxMissedSlots {
+			next = c.schedule.Next(now)
+			break
+		}
+		skipped.add(next)
+		next = after
+	}
+	if skipped.count > 0 {
+		c.events.raise(c.missedSlotsCondition("skipped", skipped))
+	}
+	c.loop.setSlot(next)
+}
+
+// slotInterval returns the time from a slot of the schedule to the next one, the interval
+// overruns and jitter of the cycle reading the slot are measured against
+func (c *consumer) slotInterval(slot time.Time) time.Duration {
+	return c.schedule.Next(slot).Sub(slot)
+}
+
+// getStallTimeout returns the stall timeout of the stream, defaultStallTimeout if it doesn't
+// set one
+func (c *consumer) getStallTimeout() time.Duration {
+	return orDefault(c.stallTimeout, defaultStallTimeout)
+}
+
+// stalled reports for how long the consumer loop has made no progress, and whether the
+// watchdog should restart the stream
+func (c *consumer) stalled(now time.Time) (time.Duration, bool) {
+	return c.loop.stalled(now, c.nextInterval(), c.getStallTimeout())
+}
+
+// close releases what subscribe acquired
+func (c *consumer) close() {
+	if c.gateway != nil {
+		c.gateway.close()
+	}
+	c.dropConnection()
+}
+
+// closeConnection closes a plc4go connection, waiting at most plcCloseTimeout
+func closeConnection(log *logrus.Entry, connection plc4go.PlcConnection) {
+	select {
+	case result := <-connection.Close():
+		if result.Err != nil {
+			log.Warnf("error closing PLC connection: %s", result.Err)
+		}
+	case <-time.After(plcCloseTimeout):
+		log.Warnf("PLC connection did not close within %s", plcCloseTimeout)
+	}
+}
+
 // producer produces data received from KPS data pipelines to the relevant client
 type producer struct {
-	// TODO: Add the relevant client and fields
+	streamID string
+	events   *streamEvents
+	stats    *streamStats
+	// server is set for streams serving the messages to Modbus clients
+	server *registerMapServer
+
+	// ctx is cancelled by close, so that messages waiting to be written are dropped
+	ctx          context.Context
+	cancel       context.CancelFunc
+	writeTimeout time.Duration
+
+	log     *logrus.Entry
+	sampler *logSampler
 }
 
-func newProducer() *producer {
-	// TODO: Add the relevant client and fields
-	return &producer{}
+func newProducer(streamID string, events *streamEvents) *producer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &producer{
+		streamID:     streamID,
+		events:       events,
+		stats:        newStreamStats(),
+		ctx:          ctx,
+		cancel:       cancel,
+		writeTimeout: defaultWriteTimeout,
+		log:          streamLogger(streamID),
+		sampler:      newLogSampler(),
+	}
 }
 
 func (p *producer) connect(ctx context.Context, metadata *streamMetadata) error {
-	// TODO: Add the code for connecting to the relevant client or service for publishing to it
+	p.writeTimeout = orDefault(metadata.WriteTimeout, defaultWriteTimeout)
+	if metadata.ModbusServer == nil {
+		return nil
+	}
+	server, err := newRegisterMapServer(metadata, p.log, p.sampler)
+	if err != nil {
+		return err
+	}
+	p.server = server
 	return nil
 }
 
+// close releases what connect acquired
+func (p *producer) close() {
+	p.cancel()
+	if p.server != nil {
+		p.server.close()
+	}
+}
+
+// messageTraceContext returns ctx continuing the trace of the `traceparent` of a pipeline
+// message, messages without one start a new trace
+func messageTraceContext(ctx context.Context, payload []byte) context.Context {
+	if !tracing.Enabled() {
+		return ctx
+	}
+	var msg struct {
+		TraceParent string `json:"traceparent"`
+	}
+	if err := json.Unmarshal(payload, &msg); err == nil && msg.TraceParent != "" {
+		ctx = tracing.ContextWithRemoteParent(ctx, msg.TraceParent)
+	}
+	return ctx
+}
+
 // subscribeMsgHandler is a callback function that wraps the logic for producing a transport.Message
 // from the data pipelines into the relevant client or service
-func (*producer) subscribeMsgHandler(message *transport.Message) {
-	// TODO: Add code for producing data into the relevant client
-	log.Printf("msg received: %s", string(message.Payload))
+func (p *producer) subscribeMsgHandler(message *transport.Message) {
+	if !getDynamicConfig().WritesArmed {
+		p.stats.recordWrite(len(message.Payload), errWritesDisarmed)
+		writesTotal.WithLabelValues(p.streamID, "disarmed").Inc()
+		p.sampler.logf(p.log, logrus.WarnLevel, "disarmed", "message rejected: %s", errWritesDisarmed)
+		p.events.report(condition{alert: writeRejectedAlert, key: "write", err: errWritesDisarmed})
+		return
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, p.writeTimeout)
+	defer cancel()
+	// messages queue up on the transport subscription while the limit is reached
+	if err := writeLimiter.wait(ctx); err != nil {
+		if p.ctx.Err() != nil {
+			// the stream is stopping
+			return
+		}
+		err = fmt.Errorf("message waited for the write rate limit: %w", contextError(ctx, p.writeTimeout))
+		p.stats.recordWrite(len(message.Payload), err)
+		writesTotal.WithLabelValues(p.streamID, "timeout").Inc()
+		p.sampler.logf(p.log, logrus.WarnLevel, "timeout", "message dropped: %s", err)
+		p.events.report(condition{alert: writeRejectedAlert, key: "write", err: err})
+		return
+	}
+	if p.server != nil {
+		_, span := tracing.Start(messageTraceContext(ctx, message.Payload), "plc.write", tracing.KindInternal,
+			tracing.String("stream.id", p.streamID), tracing.Int("message.size", len(message.Payload)))
+		err := p.server.update(message.Payload)
+		span.RecordError(err)
+		span.End()
+		p.stats.recordWrite(len(message.Payload), err)
+		if err != nil {
+			writesTotal.WithLabelValues(p.streamID, "error").Inc()
+			p.events.report(condition{alert: writeRejectedAlert, key: "write", err: err})
+			return
+		}
+		writesTotal.WithLabelValues(p.streamID, "ok").Inc()
+		p.events.report()
+		return
+	}
+	p.sampler.logf(p.log.WithField("payload", string(message.Payload)), logrus.DebugLevel, "received", "msg received")
+	p.stats.recordWrite(len(message.Payload), nil)
+	writesTotal.WithLabelValues(p.streamID, "ok").Inc()
+}
+
+// producerSubscription ties the lifetime of a producer to its transport subscription
+type producerSubscription struct {
+	transport.Subscription
+	producer *producer
+	// done stops the statistics of the stream
+	done chan struct{}
+}
+
+func newProducerSubscription(sub transport.Subscription, p *producer) *producerSubscription {
+	s := &producerSubscription{Subscription: sub, producer: p, done: make(chan struct{})}
+	go s.statsLoop()
+	return s
+}
+
+// statsLoop publishes the statistics of the producer until the subscription is unsubscribed
+func (s *producerSubscription) statsLoop() {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			publishStats(s.producer.streamID, s.producer.stats.egressSnapshot(s.pending()))
+		}
+	}
+}
+
+// pending returns the number of messages received from the transport and not yet handled
+func (s *producerSubscription) pending() int {
+	sub, ok := s.Subscription.(interface{ Pending() (int, int, error) })
+	if !ok {
+		return 0
+	}
+	msgs, _, err := sub.Pending()
+	if err != nil {
+		return 0
+	}
+	return msgs
+}
+
+// valid reports whether the transport subscription is still active
+func (s *producerSubscription) valid() bool {
+	sub, ok := s.Subscription.(interface{ IsValid() bool })
+	return !ok || sub.IsValid()
+}
+
+// Unsubscribe unsubscribes from the transport and closes the producer
+func (s *producerSubscription) Unsubscribe() error {
+	close(s.done)
+	defer s.producer.close()
+	return s.Subscription.Unsubscribe()
 }
@@ -0,0 +1,96 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/plc4x/plc4go/pkg/plc4go"
+)
+
+func TestConnectBackoff(t *testing.T) {
+	refused := errors.New("connection refused")
+	start := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// errs are the outcomes of the attempts, each made when the previous backoff passed
+		errs      []error
+		wantDelay time.Duration
+	}{
+		{"first failure", []error{refused}, minConnectBackoff},
+		{"doubles", []error{refused, refused, refused}, 4 * time.Second},
+		{"clamped at the maximum", []error{refused, refused, refused, refused, refused, refused, refused}, maxConnectBackoff},
+		{"reset by a success", []error{refused, refused, nil, refused}, minConnectBackoff},
+		{"cancelled attempt doesn't count", []error{refused, context.Canceled}, minConnectBackoff},
+		{"cancelled first attempt", []error{context.Canceled}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b connectBackoff
+			now := start
+			for _, err := range tt.errs {
+				if err := b.wait(now); err != nil {
+					t.Fatalf("wait(%s) = %s, want the attempt due", now, err)
+				}
+				b.done(now, err)
+				now = now.Add(b.delay)
+			}
+			if b.delay != tt.wantDelay {
+				t.Errorf("delay = %s, want %s", b.delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestConnectBackoffWait(t *testing.T) {
+	refused := errors.New("connection refused")
+	now := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	var b connectBackoff
+	b.done(now, refused)
+
+	// the attempts before the backoff passed fail with the error of the last one
+	err := b.wait(now.Add(400 * time.Millisecond))
+	if !errors.Is(err, refused) || !strings.Contains(err.Error(), "connecting again in 600ms") {
+		t.Errorf("wait = %v, want %s connecting again in 600ms", err, refused)
+	}
+	if err := b.wait(now.Add(minConnectBackoff)); err != nil {
+		t.Errorf("wait after the backoff = %s, want nil", err)
+	}
+	b.done(now.Add(minConnectBackoff), nil)
+	if err := b.wait(now.Add(minConnectBackoff)); err != nil {
+		t.Errorf("wait after a success = %s, want nil", err)
+	}
+}
+
+// stateConnection is a plc4go connection reporting its connection state, the methods it
+// doesn't override panic like those of drivers that don't implement them
+type stateConnection struct {
+	plc4go.PlcConnection
+	connected bool
+}
+
+func (c *stateConnection) IsConnected() bool {
+	return c.connected
+}
+
+func TestIsConnected(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection plc4go.PlcConnection
+		want       bool
+	}{
+		{"connected", &stateConnection{connected: true}, true},
+		{"disconnected", &stateConnection{connected: false}, false},
+		// the connection is open, it is assumed to be connected
+		{"IsConnected not implemented", struct{ plc4go.PlcConnection }{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConnected(tt.connection); got != tt.want {
+				t.Errorf("isConnected = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package connector
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all streams, allowing up to one second worth of
+// burst. A rate of 0 disables it. Changing the rate wakes the waiting callers, which wait again
+// at the new rate, and a caller that stops waiting returns its token.
+type rateLimiter struct {
+	mtx    sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+	// changed is closed when the rate changes, the tokens taken before are void then
+	changed chan struct{}
+}
+
+var (
+	// readLimiter limits the PLC reads of all ingress streams, see dynamicConfig.MaxReadsPerSecond
+	readLimiter = &rateLimiter{}
+	// writeLimiter limits the messages written by all egress streams, see dynamicConfig.MaxWritesPerSecond
+	writeLimiter = &rateLimiter{}
+)
+
+func (l *rateLimiter) setRate(rate float64) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.rate = rate
+	l.tokens = rate
+	l.last = time.Now()
+	if l.changed != nil {
+		close(l.changed)
+	}
+	l.changed = make(chan struct{})
+}
+
+// reserve takes a token and returns how long to wait before it may be used, and the channel
+// closed when the rate changes
+func (l *rateLimiter) reserve() (time.Duration, <-chan struct{}) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+	if l.rate <= 0 {
+		return 0, l.changed
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0, l.changed
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second)), l.changed
+}
+
+// cancel returns a token that was not used, unless the rate has changed since it was taken
+func (l *rateLimiter) cancel(changed <-chan struct{}) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if changed == l.changed {
+		l.tokens++
+	}
+}
+
+// wait blocks until a token is available or ctx is done
+func (l *rateLimiter) wait(ctx context.Context) error {
+	for {
+		delay, changed := l.reserve()
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			return nil
+		case <-changed:
+			// the token was taken at the old rate, take one at the new rate
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			l.cancel(changed)
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurst(t *testing.T) {
+	l := &rateLimiter{}
+	l.setRate(5)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("the burst of 5 tokens took %s", elapsed)
+	}
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("the 6th token came after %s, want 200ms", elapsed)
+	}
+}
+
+func TestRateLimiterRateChangeWakesWaiters(t *testing.T) {
+	for _, rate := range []float64{0, 1000} {
+		l := &rateLimiter{}
+		l.setRate(1)
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// the next token is 1s away at the old rate
+		start := time.Now()
+		done := make(chan error)
+		go func() { done <- l.wait(context.Background()) }()
+		time.Sleep(20 * time.Millisecond)
+		l.setRate(rate)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("wait returned %s after the rate changed to %v", elapsed, rate)
+		}
+	}
+}
+
+func TestRateLimiterCancelReturnsToken(t *testing.T) {
+	l := &rateLimiter{}
+	l.setRate(10)
+	for i := 0; i < 10; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the bucket is empty, every cancelled wait must give its token back
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		if err := l.wait(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("wait = %v, want %v", err, context.DeadlineExceeded)
+		}
+		cancel()
+	}
+	start := time.Now()
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("the next token came after %s, want at most 100ms", elapsed)
+	}
+}
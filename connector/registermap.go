@@ -0,0 +1,154 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nutanix/kps-connector-go-template/modbus"
+	"github.com/sirupsen/logrus"
+)
+
+// mappedRegister is a Register with its parsed address
+type mappedRegister struct {
+	Register
+	field modbus.Field
+}
+
+// registerMapServer serves the latest values of the pipeline messages to Modbus clients
+type registerMapServer struct {
+	server    *modbus.Server
+	registers []mappedRegister
+
+	log     *logrus.Entry
+	sampler *logSampler
+}
+
+func newRegisterMapServer(metadata *streamMetadata, log *logrus.Entry, sampler *logSampler) (*registerMapServer, error) {
+	registers := make([]mappedRegister, 0, len(metadata.Registers))
+	for _, register := range metadata.Registers {
+		field, err := modbus.ParseField(register.Address)
+		if err != nil {
+			return nil, fmt.Errorf("register %s: %w", register.Field, err)
+		}
+		if err := checkServedField(field); err != nil {
+			return nil, fmt.Errorf("register %s: %w", register.Field, err)
+		}
+		registers = append(registers, mappedRegister{Register: register, field: field})
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", metadata.ModbusServer.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for modbus clients: %w", err)
+	}
+	s := &registerMapServer{
+		server:    modbus.NewServer(metadata.ModbusServer.UnitIdentifier),
+		registers: registers,
+		log:       log.WithField("listen", l.Addr().String()),
+		sampler:   sampler,
+	}
+	// the registers are served at zero until the first message sets them
+	for _, register := range registers {
+		if err := s.server.Map(register.field); err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("register %s: %w", register.Field, err)
+		}
+	}
+	go func() {
+		if err := s.server.Serve(l); err != nil {
+			s.log.Warnf("modbus server stopped: %s", err)
+		}
+	}()
+	s.log.Infof("serving %d registers to modbus clients", len(registers))
+	return s, nil
+}
+
+// checkServedField reports whether the field can be served by a registerMapServer
+func checkServedField(field modbus.Field) error {
+	if field.Quantity != 1 {
+		return fmt.Errorf("only single values can be served")
+	}
+	if field.Area == modbus.ExtendedRegister {
+		return fmt.Errorf("serving %s is not supported", field.Area)
+	}
+	return nil
+}
+
+func (s *registerMapServer) close() {
+	_ = s.server.Close()
+}
+
+// update stores the mapped fields of a JSON pipeline message in the register image. Fields
+// missing from the message keep their previous value, so messages may carry partial updates.
+// Fields that can't be stored are logged and skipped, the first such error is returned.
+func (s *registerMapServer) update(payload []byte) error {
+	var msg interface{}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		s.sampler.logf(s.log, logrus.WarnLevel, "decode", "unable to decode message for modbus server: %s", err)
+		return err
+	}
+	var firstErr error
+	for _, register := range s.registers {
+		value, ok := lookupField(msg, register.Field)
+		if payload, isEnvelope := envelopePayload(msg); !ok && isEnvelope {
+			// the message of an ingress stream of another connector
+			value, ok = lookupField(payload, register.Field)
+		}
+		if !ok {
+			continue
+		}
+		data, err := modbus.Encode(register.field, scaleValue(value, register.Scale, register.Offset))
+		if err == nil {
+			err = s.server.Set(register.field, data)
+		}
+		if err != nil {
+			s.sampler.logf(s.log.WithField("field", register.Field), logrus.WarnLevel, "serve:"+register.Field, "unable to serve field: %s", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// lookupField resolves a dotted path like `line3.press2.temperature` in a decoded JSON
+// message. Numeric path elements index into arrays.
+func lookupField(msg interface{}, path string) (interface{}, bool) {
+	value := msg
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// scaleValue applies scale and offset to numeric values. Strings holding a number or
+// boolean, like the values published by ingress streams, are converted first.
+func scaleValue(value interface{}, scale, offset float64) interface{} {
+	if s, ok := value.(string); ok {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			value = f
+		} else if b, err := strconv.ParseBool(s); err == nil {
+			value = b
+		}
+	}
+	if f, ok := value.(float64); ok {
+		return f*scale + offset
+	}
+	return value
+}
@@ -0,0 +1,221 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// requestPriority is the class of a request to a device. Queued requests of a class are
+// admitted before those of the classes after it, in the order they were queued.
+type requestPriority int
+
+const (
+	// priorityAlarm is the class of the reads of streams with `priority: alarm`
+	priorityAlarm requestPriority = iota
+	// priorityPoll is the class of the reads of all other streams
+	priorityPoll
+
+	priorityCount
+)
+
+var priorityNames = [priorityCount]string{"alarm", "poll"}
+
+func (p requestPriority) String() string {
+	return priorityNames[p]
+}
+
+// errQueueTimeout is returned for a request that was still queued when its deadline passed
+var errQueueTimeout = errors.New("request was not admitted to the device in time")
+
+// deviceLimits bound the requests of all streams to one device
+type deviceLimits struct {
+	// MaxInFlight is the number of requests sent to the device and not yet answered, 0 is unlimited
+	MaxInFlight int
+	// MinGap is the minimum time between a request and the previous one sent or answered
+	MinGap time.Duration
+}
+
+// queuedRequest is a request waiting to be admitted by a deviceScheduler
+type queuedRequest struct {
+	admitted chan struct{}
+	// granted is set when the request is admitted, guarded by the mutex of the scheduler
+	granted bool
+}
+
+// deviceScheduler admits the requests of all streams to one device, a PLC or a Modbus gateway,
+// within its limits and by priority. It reports the time requests wait as metrics.
+type deviceScheduler struct {
+	device string
+
+	mtx      sync.Mutex
+	limits   deviceLimits
+	inFlight int
+	// last is when a request was last sent or answered, MinGap is measured from it
+	last   time.Time
+	queues [priorityCount][]*queuedRequest
+	// timer admits the next request once MinGap has passed
+	timer *time.Timer
+}
+
+// schedulers holds the scheduler of every device by its `host:port`, and the limits of the
+// dynamic config they apply
+var schedulers = &schedulerRegistry{devices: make(map[string]*deviceScheduler)}
+
+type schedulerRegistry struct {
+	mtx       sync.Mutex
+	devices   map[string]*deviceScheduler
+	limits    deviceLimits
+	overrides map[string]deviceLimits
+}
+
+// forDevice returns the scheduler of a device, creating it on first use
+func (r *schedulerRegistry) forDevice(device string) *deviceScheduler {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	s, ok := r.devices[device]
+	if !ok {
+		s = &deviceScheduler{device: device, limits: r.limitsOf(device)}
+		r.devices[device] = s
+	}
+	return s
+}
+
+// setLimits changes the limits of all devices, queued requests are admitted under the new limits
+func (r *schedulerRegistry) setLimits(limits deviceLimits, overrides map[string]deviceLimits) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.limits = limits
+	r.overrides = overrides
+	for device, s := range r.devices {
+		s.setLimits(r.limitsOf(device))
+	}
+}
+
+// limitsOf returns the limits of a device, r.mtx must be held
+func (r *schedulerRegistry) limitsOf(device string) deviceLimits {
+	if limits, ok := r.overrides[device]; ok {
+		return limits
+	}
+	return r.limits
+}
+
+// deviceAddress returns the `host:port` of the device a connection string targets, the
+// port defaults to the Modbus port 502
+func deviceAddress(plc string) string {
+	address, _, _, err := parseModbusConnectionString(plc)
+	if err != nil {
+		return plc
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "502")
+	}
+	return address
+}
+
+func (s *deviceScheduler) setLimits(limits deviceLimits) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.limits = limits
+	s.dispatch()
+}
+
+// acquire waits until the device admits a request of the given priority, or ctx is done.
+// The returned function must be called once the request is answered or abandoned.
+func (s *deviceScheduler) acquire(ctx context.Context, priority requestPriority) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, s.queueError(ctx)
+	}
+	start := time.Now()
+	r := &queuedRequest{admitted: make(chan struct{})}
+	s.mtx.Lock()
+	s.queues[priority] = append(s.queues[priority], r)
+	plcRequestsQueued.WithLabelValues(s.device, priority.String()).Inc()
+	s.dispatch()
+	s.mtx.Unlock()
+
+	select {
+	case <-r.admitted:
+	case <-ctx.Done():
+		s.mtx.Lock()
+		granted := r.granted
+		if !granted {
+			s.remove(priority, r)
+		}
+		s.mtx.Unlock()
+		if granted {
+			// admitted just as ctx was done, the request won't be sent
+			s.release()
+		}
+		return nil, s.queueError(ctx)
+	}
+	plcRequestQueueWait.WithLabelValues(s.device, priority.String()).Observe(time.Since(start).Seconds())
+	var once sync.Once
+	return func() { once.Do(s.release) }, nil
+}
+
+// queueError returns why a request left the queue of a done ctx
+func (s *deviceScheduler) queueError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %s is busy", errQueueTimeout, s.device)
+	}
+	return ctx.Err()
+}
+
+// remove drops a request that is no longer waiting from its queue, s.mtx must be held
+func (s *deviceScheduler) remove(priority requestPriority, r *queuedRequest) {
+	queue := s.queues[priority]
+	for i := range queue {
+		if queue[i] == r {
+			s.queues[priority] = append(queue[:i], queue[i+1:]...)
+			plcRequestsQueued.WithLabelValues(s.device, priority.String()).Dec()
+			return
+		}
+	}
+}
+
+func (s *deviceScheduler) release() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.inFlight--
+	s.last = time.Now()
+	plcRequestsInFlight.WithLabelValues(s.device).Set(float64(s.inFlight))
+	s.dispatch()
+}
+
+// dispatch admits the queued requests the limits allow, by priority. If MinGap holds up the
+// next request, a timer dispatches again once it has passed. s.mtx must be held.
+func (s *deviceScheduler) dispatch() {
+	for priority := requestPriority(0); priority < priorityCount; {
+		if len(s.queues[priority]) == 0 {
+			priority++
+			continue
+		}
+		if s.limits.MaxInFlight > 0 && s.inFlight >= s.limits.MaxInFlight {
+			return
+		}
+		now := time.Now()
+		if wait := s.last.Add(s.limits.MinGap).Sub(now); wait > 0 {
+			if s.timer == nil {
+				s.timer = time.AfterFunc(wait, func() {
+					s.mtx.Lock()
+					defer s.mtx.Unlock()
+					s.timer = nil
+					s.dispatch()
+				})
+			}
+			return
+		}
+		r := s.queues[priority][0]
+		s.queues[priority] = s.queues[priority][1:]
+		plcRequestsQueued.WithLabelValues(s.device, priority.String()).Dec()
+		r.granted = true
+		close(r.admitted)
+		s.inFlight++
+		s.last = now
+		plcRequestsInFlight.WithLabelValues(s.device).Set(float64(s.inFlight))
+	}
+}
@@ -0,0 +1,148 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queued returns the number of requests waiting in the queues of s
+func (s *deviceScheduler) queued() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	n := 0
+	for _, queue := range s.queues {
+		n += len(queue)
+	}
+	return n
+}
+
+// waitQueued waits until n requests are queued in s
+func waitQueued(t *testing.T, s *deviceScheduler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for s.queued() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", s.queued(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDeviceSchedulerAdmitsByPriority(t *testing.T) {
+	s := &deviceScheduler{device: "priority:502", limits: deviceLimits{MaxInFlight: 1}}
+	release, err := s.acquire(context.Background(), priorityPoll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mtx sync.Mutex
+	var admitted []string
+	var wg sync.WaitGroup
+	queue := func(name string, priority requestPriority) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.acquire(context.Background(), priority)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mtx.Lock()
+			admitted = append(admitted, name)
+			mtx.Unlock()
+			release()
+		}()
+	}
+	// the requests are queued one after another, so that their order within a class is known
+	for i, r := range []struct {
+		name     string
+		priority requestPriority
+	}{
+		{"poll 1", priorityPoll},
+		{"alarm 1", priorityAlarm},
+		{"poll 2", priorityPoll},
+		{"alarm 2", priorityAlarm},
+	} {
+		queue(r.name, r.priority)
+		waitQueued(t, s, i+1)
+	}
+	release()
+	wg.Wait()
+
+	want := []string{"alarm 1", "alarm 2", "poll 1", "poll 2"}
+	if !reflect.DeepEqual(admitted, want) {
+		t.Errorf("admitted %q, want %q", admitted, want)
+	}
+}
+
+func TestDeviceSchedulerMinGap(t *testing.T) {
+	s := &deviceScheduler{device: "gap:502", limits: deviceLimits{MinGap: 50 * time.Millisecond}}
+	release, err := s.acquire(context.Background(), priorityPoll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	start := time.Now()
+	release, err = s.acquire(context.Background(), priorityPoll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("second request admitted after %s, want about 50ms", waited)
+	}
+}
+
+func TestDeviceSchedulerCancelledAcquire(t *testing.T) {
+	s := &deviceScheduler{device: "cancel:502", limits: deviceLimits{MaxInFlight: 1}}
+	release, err := s.acquire(context.Background(), priorityPoll)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a request still queued at its deadline leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, priorityPoll); !errors.Is(err, errQueueTimeout) {
+		t.Errorf("acquire = %v, want %s", err, errQueueTimeout)
+	}
+	if n := s.queued(); n != 0 {
+		t.Errorf("%d requests queued after the timeout, want none", n)
+	}
+	release()
+
+	// a request admitted just as it is cancelled gives its slot back
+	for i := 0; i < 100; i++ {
+		release, err := s.acquire(context.Background(), priorityPoll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if release, err := s.acquire(ctx, priorityAlarm); err == nil {
+				release()
+			}
+		}()
+		waitQueued(t, s, 1)
+		go cancel()
+		release()
+		<-done
+	}
+	s.mtx.Lock()
+	inFlight := s.inFlight
+	s.mtx.Unlock()
+	if inFlight != 0 {
+		t.Fatalf("%d requests in flight, want none", inFlight)
+	}
+	release, err = s.acquire(context.Background(), priorityPoll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+}
@@ -0,0 +1,150 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// sharedReadSweepInterval is how often the reads no stream shared for a while are dropped
+const sharedReadSweepInterval = 1 * time.Minute
+
+// errSharedReadAbandoned is the result of a shared read whose stream stopped before reading it
+var errSharedReadAbandoned = errors.New("the stream sharing the read stopped")
+
+// sharedReadKey identifies the reads streams share: those of the same address of the same
+// device at the same rate. The device is the `plc` of the streams, and the unit for the
+// devices behind a gateway, so that streams whose values are formatted alike share them.
+type sharedReadKey struct {
+	device  string
+	address string
+	// rate is the polling interval of the streams or the spec of their schedule
+	rate string
+}
+
+// sharedRead is the read of an address by one stream, whose value the other streams polling
+// the address at the same rate use instead of reading it themselves
+type sharedRead struct {
+	started time.Time
+	// slot is the slot of the schedule the read is for, zero for streams without a schedule
+	slot time.Time
+	// expires is when the read is too old for any stream to share it
+	expires time.Time
+
+	once sync.Once
+	done chan struct{}
+	// value and err are the result of the read, set before done is closed
+	value string
+	err   error
+}
+
+// complete sets the result of the read and wakes up the streams waiting for it, only the
+// first result counts
+func (r *sharedRead) complete(value string, err error) {
+	r.once.Do(func() {
+		r.value, r.err = value, err
+		close(r.done)
+	})
+}
+
+// wait returns the result of the read once it is complete, or the reason ctx is done
+func (r *sharedRead) wait(ctx context.Context, timeout time.Duration) (string, error) {
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return "", contextError(ctx, timeout)
+	}
+}
+
+// sharedReadCache holds the latest read of every sharedReadKey. A poll cycle shares the read
+// of another stream if it is in progress or fresh: for the same slot of a schedule, or
+// started less than half the polling interval ago. Two streams polling an address at the
+// same interval thus read it once per interval between them, whatever their phase, and a
+// shared value is at most half an interval older than a value read by the stream itself.
+type sharedReadCache struct {
+	mtx       sync.Mutex
+	reads     map[sharedReadKey]*sharedRead
+	lastSweep time.Time
+}
+
+var sharedReads = &sharedReadCache{reads: make(map[sharedReadKey]*sharedRead)}
+
+// claim returns the reads of the given addresses of a device for a poll cycle. owned[i] is
+// set for the reads the cycle must do itself and complete, the others belong to other streams.
+func (s *sharedReadCache) claim(device string, addresses []string, cycle *pollCycle, now time.Time) (reads []*sharedRead, owned []bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sweep(now)
+	reads = make([]*sharedRead, len(addresses))
+	owned = make([]bool, len(addresses))
+	for i, address := range addresses {
+		key := sharedReadKey{device: device, address: address, rate: cycle.shareRate}
+		if r, ok := s.reads[key]; ok && cycle.shares(r, now) {
+			reads[i] = r
+			continue
+		}
+		r := &sharedRead{started: now, slot: cycle.slot, expires: now.Add(cycle.shareWindow), done: make(chan struct{})}
+		s.reads[key] = r
+		reads[i], owned[i] = r, true
+	}
+	return reads, owned
+}
+
+// sweep drops the reads that expired, s.mtx must be held
+func (s *sharedReadCache) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sharedReadSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, r := range s.reads {
+		if now.After(r.expires) {
+			delete(s.reads, key)
+		}
+	}
+}
+
+// readShare is the part of the reads of a poll cycle that is shared with other streams, for
+// the addresses of one device. Without sharing the cycle owns all its reads.
+type readShare struct {
+	reads []*sharedRead
+	owned []bool
+}
+
+// shareReads claims the reads of the addresses of a device for the cycle, see sharedReadCache
+func (p *pollCycle) shareReads(device string, addresses []string) *readShare {
+	if p.shareRate == "" {
+		return &readShare{}
+	}
+	reads, owned := sharedReads.claim(device, addresses, p, time.Now())
+	return &readShare{reads: reads, owned: owned}
+}
+
+// owns reports whether the cycle reads address i itself
+func (r *readShare) owns(i int) bool {
+	return r.reads == nil || r.owned[i]
+}
+
+// complete shares the result of the read of address i, which the cycle owns
+func (r *readShare) complete(i int, value string, err error) {
+	if r.reads != nil {
+		r.reads[i].complete(value, err)
+	}
+}
+
+// abandon completes the reads the cycle owns and has not completed, so that no stream waits
+// for them. It must be called once the cycle is done with its own reads.
+func (r *readShare) abandon() {
+	for i, read := range r.reads {
+		if r.owned[i] {
+			read.complete("", errSharedReadAbandoned)
+		}
+	}
+}
+
+// wait returns the result of the read of address i, which another stream owns, and when it started
+func (r *readShare) wait(ctx context.Context, i int, timeout time.Duration) (string, time.Time, error) {
+	value, err := r.reads[i].wait(ctx, timeout)
+	return value, r.reads[i].started, err
+}
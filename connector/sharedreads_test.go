@@ -0,0 +1,203 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestReadCache() *sharedReadCache {
+	return &sharedReadCache{reads: make(map[sharedReadKey]*sharedRead)}
+}
+
+// shareCycle returns a poll cycle sharing its reads at a polling interval of 1s
+func shareCycle() *pollCycle {
+	return &pollCycle{shareRate: "1s", shareWindow: 500 * time.Millisecond}
+}
+
+func TestSharedReadCacheClaim(t *testing.T) {
+	now := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	s := newTestReadCache()
+	reads, owned := s.claim("plc", []string{"a", "b"}, shareCycle(), now)
+	if !owned[0] || !owned[1] {
+		t.Fatalf("owned = %v, want the first cycle to own all its reads", owned)
+	}
+
+	tests := []struct {
+		name      string
+		device    string
+		addresses []string
+		cycle     *pollCycle
+		at        time.Duration
+		wantOwned []bool
+		// shared[i] is the read of the first cycle address i shares, -1 for none
+		shared []int
+	}{
+		{"same addresses", "plc", []string{"a", "b"}, shareCycle(), 100 * time.Millisecond, []bool{false, false}, []int{0, 1}},
+		{"in part", "plc", []string{"b", "c"}, shareCycle(), 100 * time.Millisecond, []bool{false, true}, []int{1, -1}},
+		{"other device", "other", []string{"a"}, shareCycle(), 100 * time.Millisecond, []bool{true}, []int{-1}},
+		{"other rate", "plc", []string{"a"}, &pollCycle{shareRate: "2s", shareWindow: time.Second}, 100 * time.Millisecond, []bool{true}, []int{-1}},
+		{"read expired", "plc", []string{"a"}, shareCycle(), 500 * time.Millisecond, []bool{true}, []int{-1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// every case claims from a copy of the cache, so that its own reads don't count
+			c := newTestReadCache()
+			for key, r := range s.reads {
+				c.reads[key] = r
+			}
+			got, gotOwned := c.claim(tt.device, tt.addresses, tt.cycle, now.Add(tt.at))
+			for i := range tt.addresses {
+				if gotOwned[i] != tt.wantOwned[i] {
+					t.Errorf("owned[%d] = %t, want %t", i, gotOwned[i], tt.wantOwned[i])
+				}
+				if tt.shared[i] >= 0 && got[i] != reads[tt.shared[i]] {
+					t.Errorf("reads[%d] is not the read of %s of the first cycle", i, tt.addresses[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSharedReadCacheClaimSlots(t *testing.T) {
+	slot := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	s := newTestReadCache()
+	cycle := func(slot time.Time) *pollCycle {
+		return &pollCycle{shareRate: "every 15m0s", shareWindow: 15 * time.Minute, slot: slot}
+	}
+	reads, _ := s.claim("plc", []string{"a"}, cycle(slot), slot)
+
+	// the read of a slot is shared however late the cycle of the other stream is
+	got, owned := s.claim("plc", []string{"a"}, cycle(slot), slot.Add(10*time.Minute))
+	if owned[0] || got[0] != reads[0] {
+		t.Error("the read of the same slot is not shared")
+	}
+	if _, owned := s.claim("plc", []string{"a"}, cycle(slot.Add(15*time.Minute)), slot.Add(10*time.Minute)); !owned[0] {
+		t.Error("the read of the previous slot is shared")
+	}
+}
+
+func TestSharedReadsBetweenStreams(t *testing.T) {
+	s := newTestReadCache()
+	now := time.Now()
+	owner, ownerOwned := s.claim("plc", []string{"a", "b"}, shareCycle(), now)
+	share := &readShare{reads: owner, owned: ownerOwned}
+
+	// the other stream waits for the reads of the owner
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	errs := make([]error, 2)
+	reads, owned := s.claim("plc", []string{"a", "b"}, shareCycle(), now.Add(100*time.Millisecond))
+	other := &readShare{reads: reads, owned: owned}
+	for i := range reads {
+		if other.owns(i) {
+			t.Fatalf("the other stream owns the read of address %d", i)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var started time.Time
+			results[i], started, errs[i] = other.wait(context.Background(), i, time.Second)
+			if !started.Equal(now) {
+				t.Errorf("read %d started at %s, want %s", i, started, now)
+			}
+		}(i)
+	}
+	refused := errors.New("connection refused")
+	share.complete(0, "42", nil)
+	share.complete(1, "", refused)
+	wg.Wait()
+	if results[0] != "42" || errs[0] != nil {
+		t.Errorf("shared read of a = %q, %v, want 42", results[0], errs[0])
+	}
+	if !errors.Is(errs[1], refused) {
+		t.Errorf("shared read of b = %q, %v, want %s", results[1], errs[1], refused)
+	}
+
+	// abandoning after the reads completed keeps their results
+	share.abandon()
+	if value, _, err := other.wait(context.Background(), 0, time.Second); value != "42" || err != nil {
+		t.Errorf("shared read of a after abandon = %q, %v, want 42", value, err)
+	}
+}
+
+func TestSharedReadAbandonedByItsOwner(t *testing.T) {
+	s := newTestReadCache()
+	now := time.Now()
+	owner, ownerOwned := s.claim("plc", []string{"a"}, shareCycle(), now)
+	reads, _ := s.claim("plc", []string{"a"}, shareCycle(), now)
+	other := &readShare{reads: reads, owned: []bool{false}}
+
+	done := make(chan error)
+	go func() {
+		_, _, err := other.wait(context.Background(), 0, time.Second)
+		done <- err
+	}()
+	// the owner stopped before reading a
+	(&readShare{reads: owner, owned: ownerOwned}).abandon()
+	select {
+	case err := <-done:
+		if !errors.Is(err, errSharedReadAbandoned) {
+			t.Errorf("shared read = %v, want %s", err, errSharedReadAbandoned)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiting stream was not woken up")
+	}
+
+	// the next cycle reads itself instead of sharing the abandoned read
+	if _, owned := s.claim("plc", []string{"a"}, shareCycle(), now.Add(time.Millisecond)); !owned[0] {
+		t.Error("the abandoned read is shared")
+	}
+}
+
+func TestSharedReadWaitTimeout(t *testing.T) {
+	s := newTestReadCache()
+	s.claim("plc", []string{"a"}, shareCycle(), time.Now())
+	reads, owned := s.claim("plc", []string{"a"}, shareCycle(), time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := (&readShare{reads: reads, owned: owned}).wait(ctx, 0, 20*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait = %v, want %s", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSharedReadCacheSweep(t *testing.T) {
+	now := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	s := newTestReadCache()
+	s.claim("plc", []string{"a"}, shareCycle(), now)
+	s.claim("plc", []string{"b"}, &pollCycle{shareRate: "10m", shareWindow: 5 * time.Minute}, now)
+	s.claim("other", []string{"c"}, shareCycle(), now.Add(sharedReadSweepInterval))
+	if len(s.reads) != 2 {
+		t.Errorf("%d reads after the sweep, want b and c", len(s.reads))
+	}
+	if _, ok := s.reads[sharedReadKey{device: "plc", address: "a", rate: "1s"}]; ok {
+		t.Error("the expired read of a was not swept")
+	}
+}
+
+func TestSharedReadCacheConcurrentStreams(t *testing.T) {
+	s := newTestReadCache()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				reads, owned := s.claim("plc", []string{"a", "b"}, shareCycle(), time.Now())
+				share := &readShare{reads: reads, owned: owned}
+				for k := range reads {
+					if share.owns(k) {
+						share.complete(k, "42", nil)
+					} else if value, _, err := share.wait(context.Background(), k, time.Second); err != nil && !errors.Is(err, errSharedReadAbandoned) || err == nil && value != "42" {
+						t.Errorf("shared read = %q, %v, want 42", value, err)
+					}
+				}
+				share.abandon()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,195 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// slotsFileName is the file in the state directory holding the last slot read by every
+	// stream with a schedule
+	slotsFileName = "slots.json"
+	// maxMissedSlots bounds the slots counted in one report of missed slots
+	maxMissedSlots = 100000
+	// slotsFlushInterval is how long a recorded slot may wait to be saved, a crash loses the
+	// slots read meanwhile and reports them as missed after the restart
+	slotsFlushInterval = 10 * time.Second
+)
+
+// recordedSlot is the last slot read by a stream with a schedule
+type recordedSlot struct {
+	Slot time.Time `json:"slot"`
+	// Spec is the ScanSchedule.Spec of the schedule the slot belongs to
+	Spec string `json:"schedule"`
+}
+
+// slotLog remembers the last slot read by every stream with a schedule, so that the slots
+// missed while the stream was not running can be reported when it starts again: after the
+// watchdog restarted it, or after a restart of the connector if a state directory is set.
+// The slots are saved every slotsFlushInterval and by flush. It is safe for concurrent use.
+type slotLog struct {
+	mtx    sync.Mutex
+	loaded bool
+	slots  map[string]recordedSlot
+	// dirty is set while slots are recorded that are not saved yet, timer saves them
+	dirty bool
+	timer *time.Timer
+}
+
+var lastSlots = &slotLog{slots: make(map[string]recordedSlot)}
+
+// last returns the last slot a stream read with the schedule of the given spec
+func (l *slotLog) last(streamID, spec string) (time.Time, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.load()
+	recorded, ok := l.slots[streamID]
+	if !ok || recorded.Spec != spec {
+		return time.Time{}, false
+	}
+	return recorded.Slot, true
+}
+
+// record remembers the slot a stream read last
+func (l *slotLog) record(streamID, spec string, slot time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.load()
+	l.slots[streamID] = recordedSlot{Slot: slot, Spec: spec}
+	if ConnectorCfg.StateDir == "" {
+		return
+	}
+	l.dirty = true
+	if l.timer == nil {
+		l.timer = time.AfterFunc(slotsFlushInterval, l.flush)
+	}
+}
+
+// flush saves the slots recorded since they were last saved
+func (l *slotLog) flush() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	if l.dirty {
+		l.save()
+	}
+}
+
+// prune forgets the slots of the streams that are not in streams
+func (l *slotLog) prune(streams map[string]bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.load()
+	pruned := false
+	for streamID := range l.slots {
+		if !streams[streamID] {
+			delete(l.slots, streamID)
+			pruned = true
+		}
+	}
+	if pruned {
+		l.save()
+	}
+}
+
+// load reads the slots saved in the state directory on first use, l.mtx must be held
+func (l *slotLog) load() {
+	if l.loaded || ConnectorCfg.StateDir == "" {
+		return
+	}
+	l.loaded = true
+	path := filepath.Join(ConnectorCfg.StateDir, slotsFileName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &l.slots)
+	}
+	if err != nil {
+		warnf("ignoring the slots saved in %s: %s", path, err)
+	}
+}
+
+// save writes the slots to the state directory, l.mtx must be held
+func (l *slotLog) save() {
+	l.dirty = false
+	if ConnectorCfg.StateDir == "" {
+		return
+	}
+	data, err := json.Marshal(l.slots)
+	if err == nil {
+		err = writeStateFile(slotsFileName, data)
+	}
+	if err != nil {
+		warnf("failed to save the slots to %s: %s", ConnectorCfg.StateDir, err)
+	}
+}
+
+// missedSlots are consecutive slots of a schedule that were not read
+type missedSlots struct {
+	count       int
+	first, last time.Time
+}
+
+func (m *missedSlots) add(slot time.Time) {
+	if m.count == 0 {
+		m.first = slot
+	}
+	m.count++
+	m.last = slot
+}
+
+// passedSlots returns the slots of the schedule after slot that are not after now, it counts
+// at most maxMissedSlots
+func passedSlots(s *ScanSchedule, slot, now time.Time) missedSlots {
+	var m missedSlots
+	for next := s.Next(slot); !next.IsZero() && !next.After(now) && m.count < maxMissedSlots; next = s.Next(next) {
+		m.add(next)
+	}
+	return m
+}
+
+// missedSlotsReasons explains the reasons of missedSlotsCondition
+var missedSlotsReasons = map[string]string{
+	"restart": "the stream was not running",
+	"skipped": "a poll cycle ran past them",
+	"failed":  "the read failed",
+}
+
+// missedSlotsCondition counts and logs slots a stream with a schedule did not read, and
+// returns the condition reporting them. The reason is `restart`, `skipped` or `failed`.
+func (c *consumer) missedSlotsCondition(reason string, m missedSlots) condition {
+	scheduleSlotsMissedTotal.WithLabelValues(c.streamID, reason).Add(float64(m.count))
+	err := fmt.Errorf("%d slots from %s to %s were not read, %s", m.count, formatSlot(m.first), formatSlot(m.last), missedSlotsReasons[reason])
+	if m.count == 1 {
+		err = fmt.Errorf("slot %s was not read, %s", formatSlot(m.first), missedSlotsReasons[reason])
+	}
+	c.sampler.logf(c.log.WithField("reason", reason), logrus.WarnLevel, "missed:"+reason, "%s", err)
+	return condition{
+		alert: scanSlotMissedAlert,
+		key:   reason,
+		err:   err,
+		extra: map[string]interface{}{
+			"missedSlots":     m.count,
+			"firstMissedSlot": formatSlot(m.first),
+			"lastMissedSlot":  formatSlot(m.last),
+			"schedule":        c.schedule.Spec,
+		},
+	}
+}
+
+// formatSlot renders a slot in the time zone of its schedule
+func formatSlot(slot time.Time) string {
+	return slot.Format(time.RFC3339Nano)
+}
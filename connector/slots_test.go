@@ -0,0 +1,41 @@
+package connector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSlotLogFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := ConnectorCfg.StateDir
+	ConnectorCfg.StateDir = dir
+	defer func() { ConnectorCfg.StateDir = stateDir }()
+
+	l := &slotLog{slots: make(map[string]recordedSlot)}
+	slot := time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC)
+	l.record("s1", "@hourly", slot)
+	path := filepath.Join(dir, slotsFileName)
+	// the slots are saved on the flush timer, not on every record
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("%s was written on record: %v", path, err)
+	}
+	l.flush()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("%s was not written on flush: %s", path, err)
+	}
+
+	restored := &slotLog{slots: make(map[string]recordedSlot)}
+	if last, ok := restored.last("s1", "@hourly"); !ok || !last.Equal(slot) {
+		t.Errorf("last = %s, %t, want %s", last, ok, slot)
+	}
+	if _, ok := restored.last("s1", "@daily"); ok {
+		t.Errorf("last returned the slot of another schedule")
+	}
+}
@@ -0,0 +1,198 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// StreamFile is the configuration of the standalone mode: the dynamic config and the streams
+// that KPS would otherwise set with SetPayload. It is written in YAML or JSON:
+//
+//	config:                       # optional, the dynamic config
+//	  log_level: INFO
+//	streams:
+//	  - id: plc4x-stream-in
+//	    direction: ingress        # ingress (default) or egress
+//	    transportChannel: plc4x   # defaults to the id
+//	    metadata:                 # the stream metadata, see deploy/class_plc4x.json
+//	      plc: modbus:tcp://127.0.0.1:5020
+//	      addresses:
+//	        - name: Field1
+//	          address: holding-register:123:INT
+//
+// A file without `streams` is the metadata of a single ingress stream named after the file,
+// where `plc` may also hold the `connection` and `addresses` as in
+// deploy/example-connect-string-plc4x.yaml.
+type StreamFile struct {
+	Config  *connectorpb.Config
+	Streams []*connectorpb.Stream
+}
+
+// LoadStreamFile reads a stream file, as YAML if its extension is `.yaml` or `.yml` and as
+// JSON otherwise
+func LoadStreamFile(path string) (*StreamFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		doc, err = parseYAML(data)
+	default:
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream file %s: %w", path, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	file, err := mapToStreamFile(name, doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+func mapToStreamFile(name string, doc interface{}) (*StreamFile, error) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected object, found %s", jsonType(doc))
+	}
+	if _, ok := obj["streams"]; !ok {
+		stream, err := mapToStream(map[string]interface{}{"id": name, "metadata": obj}, "")
+		if err != nil {
+			return nil, err
+		}
+		return &StreamFile{Streams: []*connectorpb.Stream{stream}}, nil
+	}
+
+	file := &StreamFile{}
+	for key, value := range obj {
+		switch key {
+		case "config":
+			config, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, newMetadataError("config", "expected object, found %s", jsonType(value))
+			}
+			metadata, err := structpb.NewStruct(config)
+			if err != nil {
+				return nil, newMetadataError("config", "%s", err)
+			}
+			file.Config = &connectorpb.Config{Metadata: metadata}
+		case "streams":
+			streams, ok := value.([]interface{})
+			if !ok {
+				return nil, newMetadataError("streams", "expected array, found %s", jsonType(value))
+			}
+			ids := make(map[string]bool, len(streams))
+			for i, value := range streams {
+				stream, err := mapToStream(value, indexPath("streams", i))
+				if err != nil {
+					return nil, err
+				}
+				if ids[stream.Id] {
+					return nil, newMetadataError(indexPath("streams", i), "duplicate stream id %q", stream.Id)
+				}
+				ids[stream.Id] = true
+				file.Streams = append(file.Streams, stream)
+			}
+		default:
+			return nil, newMetadataError(key, "unknown key")
+		}
+	}
+	return file, nil
+}
+
+func mapToStream(value interface{}, path string) (*connectorpb.Stream, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, newMetadataError(path, "expected object, found %s", jsonType(value))
+	}
+	stream := &connectorpb.Stream{Direction: connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS}
+	for key, value := range obj {
+		s, isString := value.(string)
+		switch key {
+		case "id", "transportChannel", "direction":
+			if !isString || s == "" {
+				return nil, newMetadataError(joinPath(path, key), "expected non-empty string")
+			}
+		}
+		switch key {
+		case "id":
+			stream.Id = s
+		case "transportChannel":
+			stream.TransportChannel = s
+		case "direction":
+			switch strings.ToLower(s) {
+			case "ingress":
+				stream.Direction = connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS
+			case "egress":
+				stream.Direction = connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS
+			default:
+				return nil, newMetadataError(joinPath(path, key), "expected ingress or egress, found %q", s)
+			}
+		case "metadata":
+			metadata, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, newMetadataError(joinPath(path, key), "expected object, found %s", jsonType(value))
+			}
+			var err error
+			if stream.Metadata, err = structpb.NewStruct(normalizeConnectString(metadata)); err != nil {
+				return nil, newMetadataError(joinPath(path, key), "%s", err)
+			}
+		default:
+			return nil, newMetadataError(joinPath(path, key), "unknown key")
+		}
+	}
+	if stream.Id == "" {
+		return nil, newMetadataError(joinPath(path, "id"), "missing")
+	}
+	if stream.TransportChannel == "" {
+		stream.TransportChannel = stream.Id
+	}
+	return stream, nil
+}
+
+// normalizeConnectString moves the `connection` and `addresses` of an object `plc` to the top
+// level of the metadata, where parseStreamMetadata expects them
+func normalizeConnectString(metadata map[string]interface{}) map[string]interface{} {
+	plc, ok := metadata["plc"].(map[string]interface{})
+	if !ok {
+		return metadata
+	}
+	normalized := make(map[string]interface{}, len(metadata))
+	for key, value := range metadata {
+		normalized[key] = value
+	}
+	delete(normalized, "plc")
+	for key, value := range plc {
+		if key == "connection" {
+			key = "plc"
+		}
+		normalized[key] = value
+	}
+	return normalized
+}
+
+// ApplyStreamFile applies the config and starts the streams of a stream file, the same way
+// SetPayload does for the payloads sent by KPS
+func (d *Connector) ApplyStreamFile(ctx context.Context, file *StreamFile) error {
+	for _, stream := range file.Streams {
+		if _, err := parseStreamMetadata(stream); err != nil {
+			return err
+		}
+	}
+	if file.Config != nil {
+		if err := d.updateConfig(ctx, []*connectorpb.Config{file.Config}); err != nil {
+			return err
+		}
+	}
+	return d.setStreams(ctx, file.Streams)
+}
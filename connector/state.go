@@ -0,0 +1,137 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// stateFileName is the file in the state directory holding the last accepted payloads
+const stateFileName = "state.json"
+
+// saveState writes the running streams and the dynamic config to the state directory, as the
+// SetPayload request that would restore them. It is a no-op if no state directory is set.
+func (d *Connector) saveState() error {
+	if ConnectorCfg.StateDir == "" {
+		return nil
+	}
+
+	d.mtx.RLock()
+	req := &connectorpb.SetPayloadRequest{ConnectorId: d.id}
+	for _, stream := range d.streams {
+		req.Payloads = append(req.Payloads, &connectorpb.Payload{Object: &connectorpb.Payload_Stream{Stream: stream}})
+	}
+	d.mtx.RUnlock()
+
+	ConnectorCfg.RLock()
+	config, err := structpb.NewStruct(ConnectorCfg.dynamicConfig)
+	ConnectorCfg.RUnlock()
+	if err != nil {
+		return err
+	}
+	req.Payloads = append(req.Payloads, &connectorpb.Payload{Object: &connectorpb.Payload_Config{Config: &connectorpb.Config{Metadata: config}}})
+
+	data, err := protojson.MarshalOptions{Multiline: true}.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return writeStateFile(stateFileName, data)
+}
+
+// writeStateFile writes a file of the state directory. It writes to a temporary file first,
+// so that a crash never leaves a truncated file behind.
+func writeStateFile(name string, data []byte) error {
+	tmp, err := ioutil.TempFile(ConnectorCfg.StateDir, name+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(ConnectorCfg.StateDir, name))
+}
+
+// RestoreState starts the streams and applies the config saved in the state directory, so
+// that the connector resumes streaming after a restart without waiting for KPS. When KPS
+// sends its payloads, the restored streams are reconciled like any running streams: those
+// that are unchanged keep running. It is a no-op if no state directory is set, nothing has
+// been saved yet or KPS has already sent a payload. A stream or config that fails to restore
+// doesn't keep the others from being restored, the returned error lists all that failed.
+func (d *Connector) RestoreState(ctx context.Context) error {
+	if ConnectorCfg.StateDir == "" {
+		return nil
+	}
+	path := filepath.Join(ConnectorCfg.StateDir, stateFileName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		infof("no state to restore in %s", ConnectorCfg.StateDir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	req := &connectorpb.SetPayloadRequest{}
+	if err := protojson.Unmarshal(data, req); err != nil {
+		return fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	if req.GetConnectorId() != d.id {
+		warnf("ignoring state of connector %s in %s", req.GetConnectorId(), path)
+		return nil
+	}
+
+	configs := make([]*connectorpb.Config, 0)
+	streams := make([]*connectorpb.Stream, 0)
+	errs := make(streamErrors)
+	for _, payload := range req.GetPayloads() {
+		if stream := payload.GetStream(); stream != nil {
+			// a stream saved by an older version may no longer be valid, restore the others
+			if _, err := parseStreamMetadata(stream); err != nil {
+				errs.add(stream.GetId(), err)
+				continue
+			}
+			streams = append(streams, stream)
+		} else if config := payload.GetConfig(); config != nil {
+			configs = append(configs, config)
+		}
+	}
+
+	d.payloadMtx.Lock()
+	defer d.payloadMtx.Unlock()
+	if d.payloadSet {
+		infof("not restoring the state in %s, KPS has already sent a payload", path)
+		return nil
+	}
+	infof("restoring %d streams from %s", len(streams), path)
+	// the streams are restored with the default config if the saved one is invalid
+	configErr := d.updateConfig(ctx, configs)
+	err = d.setStreams(ctx, streams)
+	if failed, ok := err.(streamErrors); ok {
+		for streamID, err := range failed {
+			errs.add(streamID, err)
+		}
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case configErr != nil && len(errs) > 0:
+		return fmt.Errorf("%s; %w", configErr, errs)
+	case configErr != nil:
+		return configErr
+	}
+	return errs.orNil()
+}
@@ -0,0 +1,97 @@
+package connector
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// useStateDir makes a temporary directory the state directory for the rest of the test
+func useStateDir(t *testing.T) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	stateDir := ConnectorCfg.StateDir
+	ConnectorCfg.StateDir = dir
+	t.Cleanup(func() { ConnectorCfg.StateDir = stateDir })
+}
+
+// writeState saves the streams to the state directory as the payload of the connector d
+func writeState(t *testing.T, d *Connector, streams ...*connectorpb.Stream) {
+	t.Helper()
+	req := &connectorpb.SetPayloadRequest{ConnectorId: d.id}
+	for _, stream := range streams {
+		req.Payloads = append(req.Payloads, &connectorpb.Payload{Object: &connectorpb.Payload_Stream{Stream: stream}})
+	}
+	data, err := protojson.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(ConnectorCfg.StateDir, stateFileName), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestoreStateStreamWhosePLCComesUpLater(t *testing.T) {
+	useStateDir(t)
+
+	d := NewConnector()
+	tclt := newChanTransport()
+	d.SetTransportClient(tclt)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = d.Shutdown(ctx)
+	})
+	address := freeAddress(t)
+	invalid := ingressStream(t, "s2", `{"plc": "modbus:tcp://`+address+`"}`)
+	writeState(t, d, gatewayStream(t, "s1", address), invalid)
+
+	// the PLC of s1 is down, only the invalid stream fails to restore
+	err := d.RestoreState(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "stream s2: ") || strings.Contains(err.Error(), "stream s1: ") {
+		t.Fatalf("RestoreState = %v, want the error of s2 only", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	startModbusServer(t, address)
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+}
+
+func TestRestoreStateAfterPayload(t *testing.T) {
+	useStateDir(t)
+
+	d := NewConnector()
+	d.SetTransportClient(newChanTransport())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = d.Shutdown(ctx)
+	})
+	_, err := d.SetPayload(context.Background(), &connectorpb.SetPayloadRequest{ConnectorId: d.id})
+	if err != nil {
+		t.Fatalf("SetPayload: %s", err)
+	}
+
+	// the empty payload of KPS is not undone by a restore that starts late, of a state saved
+	// before the payload
+	writeState(t, d, gatewayStream(t, "s1", freeAddress(t)))
+	if err := d.RestoreState(context.Background()); err != nil {
+		t.Fatalf("RestoreState: %s", err)
+	}
+	d.supervisor.streamsMtx.RLock()
+	running := len(d.supervisor.activeInStreams)
+	d.supervisor.streamsMtx.RUnlock()
+	if running != 0 {
+		t.Errorf("%d streams running after the restore, want none", running)
+	}
+}
@@ -0,0 +1,163 @@
+package connector
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nutanix/kps-connector-go-sdk/events"
+)
+
+const (
+	// statsInterval is how often the statistics of a stream are published
+	statsInterval = 1 * time.Minute
+	// statsWindow is the period the rates and latencies of the statistics are computed over
+	statsWindow = 1 * time.Minute
+)
+
+// readSample is one poll cycle of an ingress stream
+type readSample struct {
+	at      time.Time
+	latency time.Duration
+	samples int
+}
+
+// streamStats keeps rolling statistics of a stream. Rates and latencies cover the last
+// statsWindow, counters cover the lifetime of the stream.
+type streamStats struct {
+	mtx    sync.Mutex
+	window []readSample
+
+	reads             int
+	readErrors        int
+	lastRead          time.Time
+	messagesPublished int
+	bytesPublished    int
+	publishErrors     int
+
+	writes        int
+	writeErrors   int
+	lastWrite     time.Time
+	bytesReceived int
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{}
+}
+
+// recordRead adds a poll cycle, failed is set if it didn't yield a message
+func (s *streamStats) recordRead(latency time.Duration, samples int, failed bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	s.reads++
+	if failed {
+		s.readErrors++
+	} else {
+		s.lastRead = now
+	}
+	s.window = append(s.window, readSample{at: now, latency: latency, samples: samples})
+	s.trim(now)
+}
+
+// recordPublish adds a message published to the transport, err is the result of the publish
+func (s *streamStats) recordPublish(bytes int, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err != nil {
+		s.publishErrors++
+		return
+	}
+	s.messagesPublished++
+	s.bytesPublished += bytes
+}
+
+// recordWrite adds a message of an egress stream written to its data service
+func (s *streamStats) recordWrite(bytes int, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.writes++
+	s.bytesReceived += bytes
+	if err != nil {
+		s.writeErrors++
+		return
+	}
+	s.lastWrite = time.Now()
+}
+
+// trim drops the samples that have left the window
+func (s *streamStats) trim(now time.Time) {
+	i := 0
+	for i < len(s.window) && now.Sub(s.window[i].at) > statsWindow {
+		i++
+	}
+	s.window = s.window[i:]
+}
+
+// ingressSnapshot returns the statistics of an ingress stream as event metadata. It has no
+// queueDepth, messages are published as soon as they are read and nothing is queued.
+func (s *streamStats) ingressSnapshot() map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	s.trim(now)
+
+	samples := 0
+	latencies := make([]float64, 0, len(s.window))
+	for _, sample := range s.window {
+		samples += sample.samples
+		latencies = append(latencies, float64(sample.latency)/float64(time.Millisecond))
+	}
+	sort.Float64s(latencies)
+	var avgLatency, p99Latency float64
+	if len(latencies) > 0 {
+		for _, latency := range latencies {
+			avgLatency += latency
+		}
+		avgLatency /= float64(len(latencies))
+		p99Latency = latencies[int(math.Ceil(0.99*float64(len(latencies))))-1]
+	}
+
+	return map[string]interface{}{
+		"samplesPerSecond":   float64(samples) / statsWindow.Seconds(),
+		"avgReadLatencyMs":   avgLatency,
+		"p99ReadLatencyMs":   p99Latency,
+		"lastSuccessfulRead": formatTime(s.lastRead),
+		"reads":              s.reads,
+		"readErrors":         s.readErrors,
+		"messagesPublished":  s.messagesPublished,
+		"bytesPublished":     s.bytesPublished,
+		"publishErrors":      s.publishErrors,
+	}
+}
+
+// egressSnapshot returns the statistics of an egress stream as event metadata. queueDepth is
+// the number of messages received from the transport and not yet written.
+func (s *streamStats) egressSnapshot(queueDepth int) map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return map[string]interface{}{
+		"writes":              s.writes,
+		"writeErrors":         s.writeErrors,
+		"lastSuccessfulWrite": formatTime(s.lastWrite),
+		"bytesReceived":       s.bytesReceived,
+		"queueDepth":          queueDepth,
+	}
+}
+
+// formatTime renders a timestamp for event metadata, the zero time as an empty string
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// publishStats publishes the statistics of a stream with streamStatisticsStatus
+func publishStats(streamID string, stats map[string]interface{}) {
+	_ = streamStatisticsStatus.Publish(events.StatusWithStreamID(streamID), events.StatusWithEventMetadata(&events.EventMetadata{
+		StreamID: streamID,
+		Extra:    stats,
+	}))
+}
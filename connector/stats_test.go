@@ -0,0 +1,90 @@
+package connector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIngressSnapshot(t *testing.T) {
+	s := newStreamStats()
+	for i := 1; i <= 100; i++ {
+		s.recordRead(time.Duration(i)*time.Millisecond, 3, false)
+	}
+	s.recordRead(500*time.Millisecond, 0, true)
+	s.recordPublish(10, nil)
+	s.recordPublish(20, nil)
+	s.recordPublish(30, errors.New("transport is down"))
+	// a cycle that left statsWindow counts in the counters only
+	s.mtx.Lock()
+	s.window = append([]readSample{{at: time.Now().Add(-2 * statsWindow), latency: time.Hour, samples: 1000}}, s.window...)
+	s.mtx.Unlock()
+
+	got := s.ingressSnapshot()
+	want := map[string]interface{}{
+		"samplesPerSecond":  300 / statsWindow.Seconds(),
+		"avgReadLatencyMs":  (5050.0 + 500) / 101,
+		"p99ReadLatencyMs":  100.0,
+		"reads":             101,
+		"readErrors":        1,
+		"messagesPublished": 2,
+		"bytesPublished":    30,
+		"publishErrors":     1,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+	if got["lastSuccessfulRead"] == "" {
+		t.Error("lastSuccessfulRead is empty")
+	}
+	if _, ok := got["queueDepth"]; ok {
+		t.Error("ingress statistics have a queueDepth")
+	}
+}
+
+func TestIngressSnapshotWithoutReads(t *testing.T) {
+	got := newStreamStats().ingressSnapshot()
+	if got["samplesPerSecond"] != 0.0 || got["avgReadLatencyMs"] != 0.0 || got["p99ReadLatencyMs"] != 0.0 || got["lastSuccessfulRead"] != "" {
+		t.Errorf("ingressSnapshot = %v, want zero rates and latencies", got)
+	}
+}
+
+func TestEgressSnapshot(t *testing.T) {
+	s := newStreamStats()
+	s.recordWrite(10, nil)
+	s.recordWrite(5, errors.New("illegal data address"))
+	got := s.egressSnapshot(7)
+	want := map[string]interface{}{
+		"writes":        2,
+		"writeErrors":   1,
+		"bytesReceived": 15,
+		"queueDepth":    7,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+	if got["lastSuccessfulWrite"] == "" {
+		t.Error("lastSuccessfulWrite is empty")
+	}
+}
+
+func TestPublishStats(t *testing.T) {
+	d := newTestRegistry()
+	s := newStreamStats()
+	s.recordWrite(10, nil)
+	publishStats("s1", s.egressSnapshot(3))
+
+	// the statistics are returned by GetEvents
+	status := lastEvent(t, d, "streamStatistics", "s1")
+	if status == nil {
+		t.Fatal("streamStatistics was not published")
+	}
+	got := extra(status)
+	if got["writes"] != 1.0 || got["bytesReceived"] != 10.0 || got["queueDepth"] != 3.0 {
+		t.Errorf("streamStatistics extra = %v, want 1 write of 10 bytes and queueDepth 3", got)
+	}
+}
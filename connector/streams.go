@@ -2,11 +2,16 @@ package connector
 
 import (
 	"context"
-	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
 
 	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
 	"github.com/nutanix/kps-connector-go-sdk/events"
 	"github.com/nutanix/kps-connector-go-sdk/transport"
+	"github.com/nutanix/kps-connector-go-template/tracing"
+	"github.com/sirupsen/logrus"
 )
 
 func (d *Connector) getStreams(context.Context) (*connectorpb.GetPayloadResponse, error) {
@@ -30,161 +35,309 @@ func (d *Connector) getStreams(context.Context) (*connectorpb.GetPayloadResponse
 }
 
 func (d *Connector) setStreams(ctx context.Context, streams []*connectorpb.Stream) error {
-	currStreams := make(map[string]bool)
-	log.Printf("number of streams to stream: %d", len(streams))
-	for _, stream := range streams {
-		currStreams[stream.Id] = true
-		switch stream.Direction {
-		case connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS:
-			err := d.setStreamToTransport(ctx, stream)
-			if err != nil {
-				return err
-			}
-		case connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS:
-			err := d.setStreamFromTransport(ctx, stream)
-			if err != nil {
-				return err
-			}
-		}
+	err := d.supervisor.apply(ctx, streams)
+	if err == errSupervisorClosed {
+		return err
 	}
 
-	// Unsubscribe from streams that are no longer being used
-	for streamID, cancelfunc := range d.activeInStreams {
-		inUse := false
-		for id := range currStreams {
-			if streamID == id {
-				inUse = true
-			}
-		}
-		if !inUse {
-			log.Printf("cancel context for stream %s", streamID)
-			cancelfunc()
-			delete(d.activeInStreams, streamID)
-		}
+	// the payload is applied to all streams but the failed ones, which health reports as failed
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.streams = streams
+	return err
+}
+
+// streamChanges lists what differs between two specs of a stream: `direction`,
+// `transportChannel` and the top level keys of the metadata
+func streamChanges(old, new *connectorpb.Stream) []string {
+	changes := make([]string, 0)
+	if old.GetDirection() != new.GetDirection() {
+		changes = append(changes, "direction")
 	}
-	for streamID, subscription := range d.activeOutStreams {
-		inUse := false
-		for id := range currStreams {
-			if streamID == id {
-				inUse = true
-			}
+	if old.GetTransportChannel() != new.GetTransportChannel() {
+		changes = append(changes, "transportChannel")
+	}
+	oldMetadata := old.GetMetadata().AsMap()
+	newMetadata := new.GetMetadata().AsMap()
+	keys := make([]string, 0, len(oldMetadata)+len(newMetadata))
+	for key := range oldMetadata {
+		keys = append(keys, key)
+	}
+	for key := range newMetadata {
+		if _, ok := oldMetadata[key]; !ok {
+			keys = append(keys, key)
 		}
-		if !inUse {
-			log.Printf("stopping streaming stream %s", streamID)
-			delete(d.activeOutStreams, streamID)
-			err := subscription.Unsubscribe()
-			if err != nil {
-				log.Println(err)
-				_ = transportUnsubscribeFailedAlert.Publish(events.AlertWithStreamID(streamID), events.AlertWithEventMetadata(&events.EventMetadata{
-					ErrorMessage: err.Error(),
-					StreamID:     streamID,
-				}))
-
-				return err
-			}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !reflect.DeepEqual(oldMetadata[key], newMetadata[key]) {
+			changes = append(changes, key)
 		}
 	}
+	return changes
+}
 
-	d.streams = streams
-	return nil
+// pollCycle collects what happens during one poll of a stream. Devices behind a gateway are
+// read concurrently, so it is safe for concurrent use.
+type pollCycle struct {
+	mtx        sync.Mutex
+	samples    int
+	conditions []condition
+	// published is set once a message with the values of the poll was published, a failed
+	// read publishes an empty message
+	published bool
+	// digest identifies the values of the message, see encodeMessage
+	digest uint64
+	// slot is the slot of the schedule the poll reads, zero for a stream without a schedule,
+	// and readTime is when the read started
+	slot     time.Time
+	readTime time.Time
+	// shareRate is the rate the reads of the poll are shared with other streams at, empty if
+	// they are not shared, and shareWindow how long a read of the poll may be shared. See
+	// sharedReadCache.
+	shareRate   string
+	shareWindow time.Duration
+	// envelope is set if the message of the poll is wrapped in an envelope, see envelope
+	envelope bool
+}
+
+// addSamples counts field values that were read successfully
+func (p *pollCycle) addSamples(n int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.samples += n
 }
 
-func (d *Connector) setStreamToTransport(ctx context.Context, stream *connectorpb.Stream) error {
-	log.Printf("setStreamToTransport: %+v", stream)
-	if _, ok := d.activeInStreams[stream.Id]; ok {
-		log.Printf("stream already streaming %s", stream.Id)
-		return nil
+// timedOut reports whether a request of the poll timed out
+func (p *pollCycle) timedOut() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, c := range p.conditions {
+		if c.alert == plcTimeoutAlert {
+			return true
+		}
 	}
+	return false
+}
 
-	ctx, cancelfunc := context.WithCancel(context.Background())
-	d.activeInStreams[stream.Id] = cancelfunc
+// setDigest records the digest of the values of the message
+func (p *pollCycle) setDigest(digest uint64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.digest = digest
+}
 
-	metadata := mapToStreamMetadata(stream.GetMetadata().AsMap())
-	consumer := newConsumer()
-	if err := consumer.subscribe(ctx, metadata); err != nil {
-		_ = streamUnhealthyStatus.Publish(events.StatusWithStreamID(stream.GetId()), events.StatusWithEventMetadata(&events.EventMetadata{
-			StreamID:     stream.GetId(),
-			ErrorMessage: err.Error(),
-		}))
-		return err
+// readAt records that values of the poll were read at t by another stream, the read time of
+// the poll is that of its oldest values
+func (p *pollCycle) readAt(t time.Time) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if t.Before(p.readTime) {
+		p.readTime = t
 	}
+}
 
-	tclt, err := transport.NewTransportClient()
-	if err != nil {
-		_ = streamUnhealthyStatus.Publish(events.StatusWithStreamID(stream.GetId()), events.StatusWithEventMetadata(&events.EventMetadata{
-			StreamID:     stream.GetId(),
-			ErrorMessage: err.Error(),
-		}))
-		return err
+// shares reports whether the poll uses a read of another stream instead of reading itself:
+// one for the same slot of the schedule, or one that is fresh enough
+func (p *pollCycle) shares(r *sharedRead, now time.Time) bool {
+	select {
+	case <-r.done:
+		if r.err == errSharedReadAbandoned {
+			return false
+		}
+	default:
 	}
+	if !p.slot.IsZero() {
+		return r.slot.Equal(p.slot)
+	}
+	return now.Before(r.expires)
+}
 
-	go consumerLoop(ctx, stream, consumer, tclt)
-
-	log.Printf("starting streaming stream %s", stream.Id)
-	return nil
+// fail records a failure observed during the poll
+func (p *pollCycle) fail(c condition) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.conditions = append(p.conditions, c)
 }
 
+// consumerLoop connects the consumer to its PLC, then polls it and publishes its messages until
+// ctx is cancelled. A PLC that can't be reached is connected to by the polls, spaced by
+// connectBackoff. A read that is in progress when ctx is cancelled is abandoned, so the loop
+// exits promptly.
 func consumerLoop(ctx context.Context, stream *connectorpb.Stream, c *consumer, tclt transport.Client) {
+	defer c.close()
+	defer deleteStreamMetrics(stream.GetId(), c.plc)
 	_ = streamStartedStatus.Publish(events.StatusWithStreamID(stream.GetId()))
+	statsTicker := time.NewTicker(statsInterval)
+	defer statsTicker.Stop()
+	c.loop.connecting(time.Now())
+	if err := c.open(ctx); err != nil && ctx.Err() == nil {
+		c.log.Warnf("unable to connect to the PLC: %s", err)
+		c.health.set(err)
+		c.events.report(classifyError("plc", err))
+	}
+	now := time.Now()
+	c.loop.reset(now, c.firstSlot(now))
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("stopping streaming stream %s", stream.GetId())
+			c.log.Info("stopping streaming stream")
 			return
-		default:
-			nextMsg, err := c.nextMsg()
-			if err != nil {
-				_ = streamUnhealthyStatus.Publish(events.StatusWithStreamID(stream.GetId()), events.StatusWithEventMetadata(&events.EventMetadata{
-					StreamID:     stream.GetId(),
-					ErrorMessage: err.Error(),
-				}))
-				continue
-			}
-			msg := transport.Message{
-				Payload: nextMsg,
+		case <-statsTicker.C:
+			stats := c.stats.ingressSnapshot()
+			stats["pollingIntervalMs"] = durationMs(c.nextInterval())
+			if c.schedule != nil {
+				stats["schedule"] = c.schedule.Spec
+				stats["nextSlot"] = formatSlot(c.loop.nextSlot())
 			}
-			err = tclt.Publish(stream.GetTransportChannel(), msg)
+			publishStats(stream.GetId(), stats)
+		case <-time.After(c.loop.dueIn(time.Now(), c.nextInterval())):
+			c.loop.setThrottled(true)
+			err := readLimiter.wait(ctx)
+			c.loop.setThrottled(false)
 			if err != nil {
-				log.Println(err)
-				_ = transportPublishFailedAlert.Publish(events.AlertWithStreamID(stream.GetId()), events.AlertWithEventMetadata(&events.EventMetadata{
-					ErrorMessage: err.Error(),
-					StreamID:     stream.GetId(),
-				}))
 				continue
 			}
-			_ = streamHealthyStatus.Publish(events.StatusWithStreamID(stream.GetId()))
-			log.Printf("msg sent: %s", string(msg.Payload))
+			read := c.runCycle(ctx, stream, tclt)
+			if ctx.Err() == nil {
+				c.advance(time.Now(), read)
+			}
 		}
 	}
 }
 
-func (d *Connector) setStreamFromTransport(ctx context.Context, stream *connectorpb.Stream) error {
-	log.Printf("setStreamFromTransport: %+v", stream)
-	log.Printf("starting streaming stream %s", stream.Id)
-	if _, ok := d.activeOutStreams[stream.Id]; ok {
-		log.Printf("stream already streaming %s", stream.Id)
-		return nil
+// runCycle runs one poll cycle and reports its failures, and its overrun and jitter measured
+// against the polling interval in effect, or the time to the next slot of a stream with a
+// schedule. Adaptive polling adapts the interval to the outcome. It returns whether the cycle
+// published its message.
+func (c *consumer) runCycle(ctx context.Context, stream *connectorpb.Stream, tclt transport.Client) bool {
+	cycle := &pollCycle{slot: c.loop.nextSlot(), envelope: getDynamicConfig().MessageEnvelope}
+	interval := c.nextInterval()
+	if c.schedule != nil {
+		interval = c.slotInterval(cycle.slot)
+	}
+	if getDynamicConfig().ShareReads {
+		cycle.shareRate, cycle.shareWindow = interval.String(), interval/2
+		if c.schedule != nil {
+			cycle.shareRate, cycle.shareWindow = c.schedule.Spec, interval
+		}
+	}
+	jitter := c.loop.begin(time.Now(), interval)
+	pollJitterSeconds.WithLabelValues(stream.GetId()).Observe(jitter.Seconds())
+	c.poll(ctx, stream, tclt, cycle)
+	if ctx.Err() != nil {
+		// the failures of an abandoned read are not the PLC's
+		return false
+	}
+	succeeded := cycle.published && len(cycle.conditions) == 0
+	elapsed := c.loop.end(time.Now(), succeeded)
+	if succeeded {
+		pollLastSuccessTimestamp.WithLabelValues(stream.GetId()).Set(float64(time.Now().UnixNano()) / 1e9)
+	}
+	if elapsed > interval {
+		pollOverrunsTotal.WithLabelValues(stream.GetId()).Inc()
 	}
+	if len(cycle.conditions) > 0 {
+		c.health.set(cycle.conditions[0].err)
+	} else {
+		c.health.set(nil)
+	}
+	conditions := append(cycle.conditions, timingConditions(elapsed, jitter, interval)...)
+	if c.schedule != nil && !cycle.published {
+		var missed missedSlots
+		missed.add(cycle.slot)
+		conditions = append(conditions, c.missedSlotsCondition("failed", missed))
+	}
+	c.events.report(conditions...)
+	c.adapt(stream.GetId(), cycle)
+	if c.schedule == nil {
+		interval = c.nextInterval()
+	}
+	pollIntervalSeconds.WithLabelValues(stream.GetId()).Set(interval.Seconds())
+	return cycle.published
+}
 
-	tclt, err := transport.NewTransportClient()
-	if err != nil {
-		return err
+// adapt updates the interval of a stream with adaptive polling after a poll, and publishes
+// pollingIntervalChangedStatus if it changed
+func (c *consumer) adapt(streamID string, cycle *pollCycle) {
+	if c.adaptive == nil {
+		return
 	}
-	streamProducer := newProducer()
-	streamMeta := mapToStreamMetadata(stream.Metadata.AsMap())
-	if err := streamProducer.connect(ctx, streamMeta); err != nil {
-		return err
+	baseline := c.getPollingInterval()
+	from, to, reason := c.adaptive.update(baseline, cycle.published, cycle.digest)
+	if from == to {
+		return
+	}
+	c.sampler.logf(c.log.WithField("reason", reason), logrus.InfoLevel, "interval", "polling interval changed from %s to %s", from, to)
+	_ = pollingIntervalChangedStatus.Publish(events.StatusWithStreamID(streamID), events.StatusWithEventMetadata(&events.EventMetadata{
+		StreamID: streamID,
+		Extra: map[string]interface{}{
+			"intervalMs":         durationMs(to),
+			"previousIntervalMs": durationMs(from),
+			"baselineMs":         durationMs(baseline),
+			"reason":             reason,
+		},
+	}))
+}
+
+// poll reads the next message from the consumer and publishes it. Each poll is a trace of its
+// own, the read, the encoding and the publish are spans of it.
+func (c *consumer) poll(ctx context.Context, stream *connectorpb.Stream, tclt transport.Client, cycle *pollCycle) {
+	ctx, span := tracing.Start(ctx, "poll", tracing.KindInternal, spanAttributes(stream.GetId(), c.plc)...)
+	defer span.End()
+	start := time.Now()
+	cycle.readTime = start
+	nextMsg, err := c.nextMsg(ctx, cycle)
+	if nextMsg == nil && ctx.Err() != nil {
+		// the stream is stopping and the read was abandoned
+		return
+	}
+	elapsed := time.Since(start)
+	plcReadDuration.WithLabelValues(stream.GetId(), c.plc).Observe(elapsed.Seconds())
+	plcConnected.WithLabelValues(stream.GetId(), c.plc).Set(boolToFloat(c.connected()))
+	c.stats.recordRead(elapsed, cycle.samples, err != nil || nextMsg == nil)
+	if cycle.timedOut() || isTimeout(err) {
+		plcReadsTotal.WithLabelValues(stream.GetId(), c.plc, "timeout").Inc()
+	} else if err != nil || nextMsg == nil {
+		plcReadsTotal.WithLabelValues(stream.GetId(), c.plc, "error").Inc()
+	} else {
+		plcReadsTotal.WithLabelValues(stream.GetId(), c.plc, "ok").Inc()
 	}
-	sub, err := tclt.Subscribe(stream.GetTransportChannel(), streamProducer.subscribeMsgHandler)
 	if err != nil {
-		log.Println(err)
-		_ = transportSubscribeFailedAlert.Publish(events.AlertWithStreamID(stream.GetId()), events.AlertWithEventMetadata(&events.EventMetadata{
-			ErrorMessage: err.Error(),
-			StreamID:     stream.GetId(),
-		}))
-		return err
+		span.RecordError(err)
+		cycle.fail(classifyError("plc", err))
+		return
+	}
+	if nextMsg == nil && len(cycle.conditions) == 0 {
+		cycle.fail(classifyError("plc", errReadFailed))
 	}
-	d.activeOutStreams[stream.Id] = sub
 
-	return nil
+	msg := transport.Message{
+		Payload: nextMsg,
+	}
+	if cycle.envelope {
+		msg.Payload, err = c.wrapMessage(ctx, cycle, nextMsg, start.Add(elapsed))
+		if err != nil {
+			span.RecordError(err)
+			cycle.fail(classifyError("plc", err))
+			return
+		}
+	}
+	_, publishSpan := tracing.Start(ctx, "transport.publish", tracing.KindProducer,
+		append(spanAttributes(stream.GetId(), c.plc), tracing.String("messaging.destination", stream.GetTransportChannel()))...)
+	err = tclt.Publish(stream.GetTransportChannel(), msg)
+	publishSpan.RecordError(err)
+	publishSpan.End()
+	span.RecordError(err)
+	transportHealth.set(err)
+	c.stats.recordPublish(len(msg.Payload), err)
+	if err != nil {
+		c.sampler.logf(c.log.WithField("channel", stream.GetTransportChannel()), logrus.WarnLevel, "publish", "unable to publish: %s", err)
+		cycle.fail(condition{alert: transportPublishFailedAlert, key: "publish", err: err})
+		return
+	}
+	messagesPublishedTotal.WithLabelValues(stream.GetId()).Inc()
+	samplesPublishedTotal.WithLabelValues(stream.GetId()).Add(float64(cycle.samples))
+	cycle.published = nextMsg != nil
+	c.sampler.logf(c.log.WithField("payload", string(msg.Payload)), logrus.DebugLevel, "sent", "msg sent")
 }
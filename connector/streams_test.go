@@ -0,0 +1,72 @@
+package connector
+
+import (
+	"reflect"
+	"testing"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+)
+
+func TestStreamChanges(t *testing.T) {
+	const doc = `{
+		"plc": "modbus:tcp://127.0.0.1:5020",
+		"polling-interval": 100,
+		"addresses": [{"name": "a", "address": "coil:1"}],
+		"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "speed", "address": "holding-register:1:INT"}]}]
+	}`
+	tests := []struct {
+		name string
+		doc  string
+		edit func(stream *connectorpb.Stream)
+		want []string
+	}{
+		{"unchanged", doc, nil, []string{}},
+		{"polling interval", `{
+			"plc": "modbus:tcp://127.0.0.1:5020",
+			"polling-interval": 200,
+			"addresses": [{"name": "a", "address": "coil:1"}],
+			"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "speed", "address": "holding-register:1:INT"}]}]
+		}`, nil, []string{"polling-interval"}},
+		{"address of an address", `{
+			"plc": "modbus:tcp://127.0.0.1:5020",
+			"polling-interval": 100,
+			"addresses": [{"name": "a", "address": "coil:2"}],
+			"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "speed", "address": "holding-register:1:INT"}]}]
+		}`, nil, []string{"addresses"}},
+		{"address of a device", `{
+			"plc": "modbus:tcp://127.0.0.1:5020",
+			"polling-interval": 100,
+			"addresses": [{"name": "a", "address": "coil:1"}],
+			"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "speed", "address": "holding-register:2:INT"}]}]
+		}`, nil, []string{"devices"}},
+		{"unit of a device and polling interval", `{
+			"plc": "modbus:tcp://127.0.0.1:5020",
+			"polling-interval": 200,
+			"addresses": [{"name": "a", "address": "coil:1"}],
+			"devices": [{"name": "press", "unit-identifier": 2, "addresses": [{"name": "speed", "address": "holding-register:1:INT"}]}]
+		}`, nil, []string{"devices", "polling-interval"}},
+		{"removed parameter", `{
+			"plc": "modbus:tcp://127.0.0.1:5020",
+			"addresses": [{"name": "a", "address": "coil:1"}],
+			"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "speed", "address": "holding-register:1:INT"}]}]
+		}`, nil, []string{"polling-interval"}},
+		{"transport channel", doc, func(stream *connectorpb.Stream) {
+			stream.TransportChannel = "other"
+		}, []string{"transportChannel"}},
+		{"direction", doc, func(stream *connectorpb.Stream) {
+			stream.Direction = connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS
+		}, []string{"direction"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := ingressStream(t, "s1", tt.doc)
+			if tt.edit != nil {
+				tt.edit(stream)
+			}
+			got := streamChanges(ingressStream(t, "s1", doc), stream)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("streamChanges = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,408 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-sdk/events"
+	"github.com/nutanix/kps-connector-go-sdk/transport"
+)
+
+const (
+	// streamStopTimeout bounds how long a stream removed or restarted by SetPayload may take to stop
+	streamStopTimeout = 10 * time.Second
+)
+
+// errSupervisorClosed is returned for stream changes after the supervisor has been shut down
+var errSupervisorClosed = errors.New("connector is shutting down")
+
+// activeInStream is a running ingress stream
+type activeInStream struct {
+	// stream is the spec the stream was started with, see streamChanges
+	stream   *connectorpb.Stream
+	cancel   context.CancelFunc
+	consumer *consumer
+	// done is closed once the consumer loop has returned and the consumer is closed
+	done chan struct{}
+}
+
+// activeOutStream is a running egress stream
+type activeOutStream struct {
+	stream       *connectorpb.Stream
+	subscription transport.Subscription
+}
+
+// supervisor owns the running streams and their goroutines. All lifecycle changes are
+// serialized by its mutex, so concurrent SetPayload calls can't interleave.
+type supervisor struct {
+	mtx sync.Mutex
+	// streamsMtx guards the maps for readers that must not wait for a lifecycle change, like
+	// health probes. Writers hold mtx as well.
+	streamsMtx       sync.RWMutex
+	activeInStreams  map[string]*activeInStream
+	activeOutStreams map[string]*activeOutStream
+	// failedStreams holds the streams of the last payload that could not be started
+	failedStreams map[string]error
+	// events outlive restarts of a stream, so that its alerts are deduplicated across them
+	events map[string]*streamEvents
+	// restarts holds the ingress streams the watchdog starts again: those it stopped, and
+	// those that failed to start for a reason other than their metadata
+	restarts map[string]*pendingRestart
+	closed   bool
+	// stopWatchdog stops the watchdog on shutdown
+	stopWatchdog chan struct{}
+	// transportClient returns the client streams publish to and subscribe from
+	transportClient func() (transport.Client, error)
+}
+
+func newSupervisor() *supervisor {
+	s := &supervisor{
+		activeInStreams:  make(map[string]*activeInStream),
+		activeOutStreams: make(map[string]*activeOutStream),
+		failedStreams:    make(map[string]error),
+		events:           make(map[string]*streamEvents),
+		restarts:         make(map[string]*pendingRestart),
+		stopWatchdog:     make(chan struct{}),
+		transportClient:  transport.NewTransportClient,
+	}
+	go s.watchdog(s.stopWatchdog)
+	return s
+}
+
+// eventsFor returns the events of a stream, creating them on first use
+func (s *supervisor) eventsFor(streamID string) *streamEvents {
+	e, ok := s.events[streamID]
+	if !ok {
+		e = newStreamEvents(streamID)
+		s.events[streamID] = e
+	}
+	return e
+}
+
+// apply starts, restarts and stops streams so that exactly the given streams are running. A
+// stream that fails is recorded in failedStreams and the others are applied anyway, the
+// errors of all failed streams are returned together.
+func (s *supervisor) apply(ctx context.Context, streams []*connectorpb.Stream) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return errSupervisorClosed
+	}
+
+	currStreams := make(map[string]bool)
+	for _, stream := range streams {
+		currStreams[stream.Id] = true
+	}
+	s.streamsMtx.Lock()
+	for streamID := range s.failedStreams {
+		if !currStreams[streamID] {
+			delete(s.failedStreams, streamID)
+		}
+	}
+	s.streamsMtx.Unlock()
+	for streamID := range s.events {
+		if !currStreams[streamID] {
+			delete(s.events, streamID)
+		}
+	}
+	lastSlots.prune(currStreams)
+	sequences.prune(currStreams)
+	// the payload decides which streams run, including those the watchdog is to start again
+	for streamID := range s.restarts {
+		delete(s.restarts, streamID)
+	}
+
+	errs := make(streamErrors)
+	// Unsubscribe from streams that are no longer being used, first so that they release
+	// what the new streams may need, like the port of a Modbus server
+	for streamID := range s.activeInStreams {
+		if !currStreams[streamID] {
+			errs.add(streamID, s.stopStreamWithTimeout(streamID))
+		}
+	}
+	for streamID := range s.activeOutStreams {
+		if !currStreams[streamID] {
+			errs.add(streamID, s.stopStreamWithTimeout(streamID))
+		}
+	}
+
+	infof("number of streams to stream: %d", len(streams))
+	for _, stream := range streams {
+		err := s.updateStream(stream)
+		if err == nil {
+			switch stream.Direction {
+			case connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS:
+				err = s.setStreamToTransport(stream)
+			case connectorpb.StreamDirection_STREAM_DIRECTION_EGRESS:
+				err = s.setStreamFromTransport(ctx, stream)
+			}
+		}
+		s.streamsMtx.Lock()
+		if err != nil {
+			s.failedStreams[stream.Id] = err
+		} else {
+			delete(s.failedStreams, stream.Id)
+		}
+		s.streamsMtx.Unlock()
+		// an ingress stream that failed to start, e.g. because the transport is down, is
+		// retried by the watchdog, one with invalid metadata fails again
+		var metadataErr *metadataError
+		if err != nil && stream.Direction == connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS && !errors.As(err, &metadataErr) {
+			s.restarts[stream.Id] = &pendingRestart{stream: stream, next: time.Now().Add(restartRetryInterval)}
+		}
+		errs.add(stream.Id, err)
+	}
+	return errs.orNil()
+}
+
+// streamErrors collects the errors of the streams a change failed for, by stream ID, so that
+// one failing stream doesn't keep the change from being applied to the others
+type streamErrors map[string]error
+
+func (e streamErrors) add(streamID string, err error) {
+	if err != nil {
+		e[streamID] = err
+	}
+}
+
+// orNil returns the errors as an error, nil if there are none
+func (e streamErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e streamErrors) Error() string {
+	streamIDs := make([]string, 0, len(e))
+	for streamID := range e {
+		streamIDs = append(streamIDs, streamID)
+	}
+	sort.Strings(streamIDs)
+	msgs := make([]string, 0, len(e))
+	for _, streamID := range streamIDs {
+		msgs = append(msgs, fmt.Sprintf("stream %s: %s", streamID, e[streamID]))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// shutdown stops all streams and rejects further changes. It waits for in-flight publishes
+// to drain and for the PLC connections to close until ctx is done.
+func (s *supervisor) shutdown(ctx context.Context) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.closed {
+		close(s.stopWatchdog)
+	}
+	s.closed = true
+
+	var errs []error
+	for streamID := range s.activeOutStreams {
+		if err := s.stopStream(ctx, streamID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for streamID := range s.activeInStreams {
+		if err := s.stopStream(ctx, streamID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// the last cycles of the stopped streams have recorded their slots
+	lastSlots.flush()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// updateStream compares a stream with the running one of the same ID. Changes that can be
+// applied to the running stream are applied in place, on any other change the running stream
+// is stopped so that it is started again with the new spec.
+func (s *supervisor) updateStream(stream *connectorpb.Stream) error {
+	var running *connectorpb.Stream
+	if in, ok := s.activeInStreams[stream.Id]; ok {
+		running = in.stream
+	} else if out, ok := s.activeOutStreams[stream.Id]; ok {
+		running = out.stream
+	} else {
+		return nil
+	}
+
+	changes := streamChanges(running, stream)
+	if len(changes) == 0 {
+		return nil
+	}
+	changed := make([]interface{}, 0, len(changes))
+	for _, change := range changes {
+		changed = append(changed, change)
+	}
+
+	if in, ok := s.activeInStreams[stream.Id]; ok && len(changes) == 1 && changes[0] == "polling-interval" {
+		metadata, err := parseStreamMetadata(stream)
+		if err != nil {
+			return err
+		}
+		if metadata.PollingInterval == 0 {
+			streamLogger(stream.Id).Info("updating polling interval to the default")
+		} else {
+			streamLogger(stream.Id).Infof("updating polling interval to %s", metadata.PollingInterval)
+		}
+		in.consumer.setPollingInterval(metadata.PollingInterval)
+		in.stream = stream
+		_ = streamUpdatedStatus.Publish(events.StatusWithStreamID(stream.GetId()), events.StatusWithEventMetadata(&events.EventMetadata{
+			StreamID: stream.GetId(),
+			Extra:    map[string]interface{}{"changed": changed, "restarted": false},
+		}))
+		return nil
+	}
+
+	streamLogger(stream.Id).WithField("changed", changes).Info("restarting stream")
+	if err := s.stopStreamWithTimeout(stream.Id); err != nil {
+		return err
+	}
+	_ = streamUpdatedStatus.Publish(events.StatusWithStreamID(stream.GetId()), events.StatusWithEventMetadata(&events.EventMetadata{
+		StreamID: stream.GetId(),
+		Extra:    map[string]interface{}{"changed": changed, "restarted": true},
+	}))
+	return nil
+}
+
+func (s *supervisor) stopStreamWithTimeout(streamID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), streamStopTimeout)
+	defer cancel()
+	return s.stopStream(ctx, streamID)
+}
+
+// stopStream stops the running stream with the given ID and waits until ctx is done for
+// an ingress stream to publish its last message and close its PLC connection
+func (s *supervisor) stopStream(ctx context.Context, streamID string) error {
+	if in, ok := s.activeInStreams[streamID]; ok {
+		streamLogger(streamID).Info("cancel context for stream")
+		in.cancel()
+		s.streamsMtx.Lock()
+		delete(s.activeInStreams, streamID)
+		s.streamsMtx.Unlock()
+		select {
+		case <-in.done:
+		case <-ctx.Done():
+			streamLogger(streamID).Warnf("stream did not stop in time: %s", ctx.Err())
+			return ctx.Err()
+		}
+	}
+	if out, ok := s.activeOutStreams[streamID]; ok {
+		streamLogger(streamID).Info("stopping streaming stream")
+		s.streamsMtx.Lock()
+		delete(s.activeOutStreams, streamID)
+		s.streamsMtx.Unlock()
+		err := out.subscription.Unsubscribe()
+		if err != nil {
+			streamLogger(streamID).Warnf("unable to unsubscribe: %s", err)
+			_ = transportUnsubscribeFailedAlert.Publish(events.AlertWithStreamID(streamID), events.AlertWithEventMetadata(&events.EventMetadata{
+				ErrorMessage: err.Error(),
+				StreamID:     streamID,
+			}))
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *supervisor) setStreamToTransport(stream *connectorpb.Stream) error {
+	streamLogger(stream.Id).Debugf("setStreamToTransport: %+v", stream)
+	if _, ok := s.activeInStreams[stream.Id]; ok {
+		streamLogger(stream.Id).Info("stream already streaming")
+		return nil
+	}
+
+	in, start, err := s.prepareInStream(stream, s.eventsFor(stream.Id))
+	if err != nil {
+		return err
+	}
+	s.streamsMtx.Lock()
+	s.activeInStreams[stream.Id] = in
+	s.streamsMtx.Unlock()
+	start()
+
+	streamLogger(stream.Id).Info("starting streaming stream")
+	return nil
+}
+
+// prepareInStream prepares an ingress stream and connects it to the transport, its consumer
+// loop runs once start is called and connects to the PLC. It doesn't wait for the PLC, so it
+// is quick to call with s.mtx held.
+func (s *supervisor) prepareInStream(stream *connectorpb.Stream, events *streamEvents) (in *activeInStream, start func(), err error) {
+	metadata, err := parseStreamMetadata(stream)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	streamCtx, cancelfunc := context.WithCancel(context.Background())
+	consumer := newConsumer(stream.Id, events)
+	if err := consumer.subscribe(metadata); err != nil {
+		cancelfunc()
+		return nil, nil, err
+	}
+
+	tclt, err := s.transportClient()
+	transportHealth.set(err)
+	if err != nil {
+		cancelfunc()
+		consumer.close()
+		consumer.events.report(condition{alert: transportSubscribeFailedAlert, key: "transport", err: err})
+		return nil, nil, err
+	}
+
+	in = &activeInStream{stream: stream, cancel: cancelfunc, consumer: consumer, done: make(chan struct{})}
+	start = func() {
+		go func() {
+			defer close(in.done)
+			consumerLoop(streamCtx, stream, consumer, tclt)
+		}()
+	}
+	return in, start, nil
+}
+
+func (s *supervisor) setStreamFromTransport(ctx context.Context, stream *connectorpb.Stream) error {
+	streamLogger(stream.Id).Debugf("setStreamFromTransport: %+v", stream)
+	streamLogger(stream.Id).Info("starting streaming stream")
+	if _, ok := s.activeOutStreams[stream.Id]; ok {
+		streamLogger(stream.Id).Info("stream already streaming")
+		return nil
+	}
+
+	streamMeta, err := parseStreamMetadata(stream)
+	if err != nil {
+		return err
+	}
+	tclt, err := s.transportClient()
+	transportHealth.set(err)
+	if err != nil {
+		return err
+	}
+	streamProducer := newProducer(stream.Id, s.eventsFor(stream.Id))
+	if err := streamProducer.connect(ctx, streamMeta); err != nil {
+		return err
+	}
+	sub, err := tclt.Subscribe(stream.GetTransportChannel(), streamProducer.subscribeMsgHandler)
+	if err != nil {
+		transportHealth.set(err)
+		streamProducer.close()
+		streamProducer.log.Warnf("unable to subscribe to %s: %s", stream.GetTransportChannel(), err)
+		streamProducer.events.report(condition{alert: transportSubscribeFailedAlert, key: "transport", err: err})
+		return err
+	}
+	s.streamsMtx.Lock()
+	s.activeOutStreams[stream.Id] = &activeOutStream{
+		stream:       stream,
+		subscription: newProducerSubscription(sub, streamProducer),
+	}
+	s.streamsMtx.Unlock()
+
+	return nil
+}
@@ -0,0 +1,344 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-sdk/transport"
+	"github.com/nutanix/kps-connector-go-template/modbus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// chanTransport is a transport client handing the published messages to the test
+type chanTransport struct {
+	msgs chan transport.Message
+}
+
+func newChanTransport() *chanTransport {
+	return &chanTransport{msgs: make(chan transport.Message, 100)}
+}
+
+func (t *chanTransport) Publish(channel string, msg transport.Message) error {
+	select {
+	case t.msgs <- msg:
+	default:
+	}
+	return nil
+}
+
+func (t *chanTransport) Subscribe(channel string, callback transport.MessageHandler) (transport.Subscription, error) {
+	return nil, errors.New("subscribing is not supported")
+}
+
+// next returns the next published message whose payload contains want, failing the test if
+// none is published within timeout
+func (t *chanTransport) next(tb testing.TB, want string, timeout time.Duration) string {
+	tb.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-t.msgs:
+			if strings.Contains(string(msg.Payload), want) {
+				return string(msg.Payload)
+			}
+		case <-deadline:
+			tb.Fatalf("no message with %s published within %s", want, timeout)
+			return ""
+		}
+	}
+}
+
+// newTestSupervisor returns a supervisor publishing to tclt, shut down at the end of the test
+func newTestSupervisor(t *testing.T, tclt transport.Client) *supervisor {
+	s := newSupervisor()
+	s.transportClient = func() (transport.Client, error) {
+		return tclt, nil
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = s.shutdown(ctx)
+	})
+	return s
+}
+
+// ingressStream returns an ingress stream with the JSON metadata doc
+func ingressStream(t *testing.T, id, doc string) *connectorpb.Stream {
+	t.Helper()
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(doc), &m); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := structpb.NewStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &connectorpb.Stream{
+		Id:               id,
+		Direction:        connectorpb.StreamDirection_STREAM_DIRECTION_INGRESS,
+		TransportChannel: id,
+		Metadata:         metadata,
+	}
+}
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startModbusServer serves holding-register:1:INT at 42 for unit 1 on address
+func startModbusServer(t *testing.T, address string) {
+	t.Helper()
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := modbus.NewServer(1)
+	field, err := modbus.ParseField("holding-register:1:INT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := modbus.Encode(field, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Set(field, data); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = server.Serve(l) }()
+	t.Cleanup(func() { _ = server.Close() })
+}
+
+// gatewayStream returns a stream reading holding-register:1:INT of unit 1 at address
+func gatewayStream(t *testing.T, id, address string) *connectorpb.Stream {
+	return ingressStream(t, id, `{
+		"plc": "modbus:tcp://`+address+`",
+		"polling-interval": 100,
+		"connect-timeout": 500,
+		"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "speed", "address": "holding-register:1:INT"}]}]
+	}`)
+}
+
+func TestSupervisorConnectsToAPLCThatComesUpLater(t *testing.T) {
+	tclt := newChanTransport()
+	s := newTestSupervisor(t, tclt)
+	address := freeAddress(t)
+	// the stream starts although its PLC is down, the consumer loop connects
+	if err := s.apply(context.Background(), []*connectorpb.Stream{gatewayStream(t, "s1", address)}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	s.streamsMtx.RLock()
+	_, running := s.activeInStreams["s1"]
+	s.streamsMtx.RUnlock()
+	if !running {
+		t.Fatal("s1 is not running")
+	}
+	// the first attempts to connect fail
+	time.Sleep(300 * time.Millisecond)
+	startModbusServer(t, address)
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+}
+
+func TestSupervisorRetriesStreamsThatFailedToStart(t *testing.T) {
+	tclt := newChanTransport()
+	s := newTestSupervisor(t, tclt)
+	address := freeAddress(t)
+	startModbusServer(t, address)
+	s.mtx.Lock()
+	s.transportClient = func() (transport.Client, error) {
+		return nil, errors.New("transport is down")
+	}
+	s.mtx.Unlock()
+
+	stream := gatewayStream(t, "s1", address)
+	invalid := ingressStream(t, "s2", `{"plc": "modbus:tcp://`+address+`"}`)
+	err := s.apply(context.Background(), []*connectorpb.Stream{stream, invalid})
+	if err == nil || !strings.Contains(err.Error(), "stream s1: transport is down") {
+		t.Fatalf("apply = %v, want the transport error of s1", err)
+	}
+	s.mtx.Lock()
+	pending, ok := s.restarts["s1"]
+	_, invalidPending := s.restarts["s2"]
+	s.transportClient = func() (transport.Client, error) {
+		return tclt, nil
+	}
+	s.mtx.Unlock()
+	if !ok {
+		t.Fatal("s1 is not retried")
+	}
+	if invalidPending {
+		t.Error("s2 with invalid metadata is retried")
+	}
+
+	// the retry is due restartRetryInterval after the failure
+	s.checkStreams(pending.next.Add(-time.Millisecond))
+	s.streamsMtx.RLock()
+	_, running := s.activeInStreams["s1"]
+	s.streamsMtx.RUnlock()
+	if running {
+		t.Fatal("s1 was retried before restartRetryInterval")
+	}
+	s.checkStreams(pending.next)
+	s.streamsMtx.RLock()
+	_, running = s.activeInStreams["s1"]
+	failed := s.failedStreams["s1"]
+	s.streamsMtx.RUnlock()
+	if !running || failed != nil {
+		t.Fatalf("s1 running = %t with error %v after the retry, want it running", running, failed)
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+}
+
+// runningConsumer returns the consumer of the ingress stream id and the gateway connection
+// it reads through
+func runningConsumer(t *testing.T, s *supervisor, id string) (*consumer, *modbus.Client) {
+	t.Helper()
+	s.streamsMtx.RLock()
+	in, ok := s.activeInStreams[id]
+	s.streamsMtx.RUnlock()
+	if !ok {
+		t.Fatalf("%s is not running", id)
+	}
+	in.consumer.gateway.mtx.Lock()
+	defer in.consumer.gateway.mtx.Unlock()
+	return in.consumer, in.consumer.gateway.client
+}
+
+func TestSupervisorUpdatesPollingIntervalInPlace(t *testing.T) {
+	tclt := newChanTransport()
+	s := newTestSupervisor(t, tclt)
+	address := freeAddress(t)
+	startModbusServer(t, address)
+	if err := s.apply(context.Background(), []*connectorpb.Stream{gatewayStream(t, "s1", address)}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+	c, client := runningConsumer(t, s, "s1")
+
+	updated := gatewayStream(t, "s1", address)
+	updated.Metadata.Fields["polling-interval"] = structpb.NewNumberValue(200)
+	if err := s.apply(context.Background(), []*connectorpb.Stream{updated}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+	updatedConsumer, updatedClient := runningConsumer(t, s, "s1")
+	if updatedConsumer != c || updatedClient != client {
+		t.Error("changing the polling interval restarted the stream, want its consumer and connection kept")
+	}
+	if got := c.getPollingInterval(); got != 200*time.Millisecond {
+		t.Errorf("polling interval = %s, want 200ms", got)
+	}
+}
+
+func TestSupervisorRestartsStreamsWithChangedDevices(t *testing.T) {
+	tclt := newChanTransport()
+	s := newTestSupervisor(t, tclt)
+	address := freeAddress(t)
+	startModbusServer(t, address)
+	if err := s.apply(context.Background(), []*connectorpb.Stream{gatewayStream(t, "s1", address)}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+	c, _ := runningConsumer(t, s, "s1")
+
+	// renaming an address nested in a device restarts the stream
+	updated := ingressStream(t, "s1", `{
+		"plc": "modbus:tcp://`+address+`",
+		"polling-interval": 100,
+		"connect-timeout": 500,
+		"devices": [{"name": "press", "unit-identifier": 1, "addresses": [{"name": "rpm", "address": "holding-register:1:INT"}]}]
+	}`)
+	if err := s.apply(context.Background(), []*connectorpb.Stream{updated}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	if restarted, _ := runningConsumer(t, s, "s1"); restarted == c {
+		t.Fatal("changing the devices kept the consumer, want the stream restarted")
+	}
+	tclt.next(t, `"rpm"`, 5*time.Second)
+}
+
+func TestSupervisorRestartsStalledStreams(t *testing.T) {
+	d := newTestRegistry()
+	tclt := newChanTransport()
+	s := newTestSupervisor(t, tclt)
+	address := freeAddress(t)
+	startModbusServer(t, address)
+	if err := s.apply(context.Background(), []*connectorpb.Stream{gatewayStream(t, "s1", address)}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+	stalled, _ := runningConsumer(t, s, "s1")
+
+	// seen from two stall timeouts later, the next cycle is overdue
+	s.checkStreams(time.Now().Add(2 * defaultStallTimeout))
+	if lastEvent(t, d, "streamStalled", "s1") == nil {
+		t.Error("streamStalled was not published")
+	}
+	if restarted, _ := runningConsumer(t, s, "s1"); restarted == stalled {
+		t.Fatal("the stalled stream was not restarted")
+	}
+	s.mtx.Lock()
+	_, pending := s.restarts["s1"]
+	s.mtx.Unlock()
+	if pending {
+		t.Error("the restarted stream is still pending a restart")
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+}
+
+func TestSupervisorRetriesFailedRestarts(t *testing.T) {
+	tclt := newChanTransport()
+	s := newTestSupervisor(t, tclt)
+	address := freeAddress(t)
+	startModbusServer(t, address)
+	if err := s.apply(context.Background(), []*connectorpb.Stream{gatewayStream(t, "s1", address)}); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+
+	s.mtx.Lock()
+	s.transportClient = func() (transport.Client, error) {
+		return nil, errors.New("transport is down")
+	}
+	s.mtx.Unlock()
+	s.checkStreams(time.Now().Add(2 * defaultStallTimeout))
+	s.mtx.Lock()
+	pending, ok := s.restarts["s1"]
+	s.transportClient = func() (transport.Client, error) {
+		return tclt, nil
+	}
+	s.mtx.Unlock()
+	s.streamsMtx.RLock()
+	_, running := s.activeInStreams["s1"]
+	failed := s.failedStreams["s1"]
+	s.streamsMtx.RUnlock()
+	if !ok || running || failed == nil {
+		t.Fatalf("s1 pending %t, running %t with error %v, want a failed restart pending", ok, running, failed)
+	}
+	if wait := time.Until(pending.next); wait < restartRetryInterval-time.Second || wait > restartRetryInterval {
+		t.Errorf("retry in %s, want %s", wait, restartRetryInterval)
+	}
+
+	s.checkStreams(pending.next.Add(-time.Millisecond))
+	s.streamsMtx.RLock()
+	_, running = s.activeInStreams["s1"]
+	s.streamsMtx.RUnlock()
+	if running {
+		t.Fatal("s1 was retried before restartRetryInterval")
+	}
+	s.checkStreams(pending.next)
+	runningConsumer(t, s, "s1")
+	tclt.next(t, `"value":["42"]`, 5*time.Second)
+}
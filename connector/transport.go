@@ -0,0 +1,136 @@
+package connector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-sdk/transport"
+	"google.golang.org/protobuf/proto"
+)
+
+// SetTransportClient makes the streams publish to and subscribe from client instead of the
+// transport of the KPS data pipelines. It is used by the standalone mode and only affects
+// streams started afterwards.
+func (d *Connector) SetTransportClient(client transport.Client) {
+	d.supervisor.mtx.Lock()
+	defer d.supervisor.mtx.Unlock()
+	d.supervisor.transportClient = func() (transport.Client, error) {
+		return client, nil
+	}
+}
+
+// writerTransport writes the published messages to a writer as JSON lines. It never receives
+// messages, so egress streams using it stay idle.
+type writerTransport struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+var _ transport.Client = (*writerTransport)(nil)
+
+// NewWriterTransport returns a transport client writing every published message to w as a
+// JSON line with its channel, timestamp and payload
+func NewWriterTransport(w io.Writer) transport.Client {
+	return &writerTransport{w: w}
+}
+
+// Publish writes the message to the writer
+func (t *writerTransport) Publish(channel string, msg transport.Message) error {
+	line := struct {
+		Channel   string      `json:"channel"`
+		Timestamp string      `json:"timestamp"`
+		Payload   interface{} `json:"payload"`
+	}{channel, time.Now().UTC().Format(time.RFC3339Nano), string(msg.Payload)}
+	// payloads are JSON documents, embed them as such so the output stays readable
+	if json.Valid(msg.Payload) {
+		line.Payload = json.RawMessage(msg.Payload)
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	_, err = t.w.Write(append(data, '\n'))
+	return err
+}
+
+// Subscribe returns a subscription that never receives a message
+func (t *writerTransport) Subscribe(channel string, callback transport.MessageHandler) (transport.Subscription, error) {
+	infof("messages of channel %s are not received in standalone mode without NATS", channel)
+	return &writerSubscription{channel: channel}, nil
+}
+
+type writerSubscription struct {
+	channel string
+}
+
+// Unsubscribe unsubscribes the connection
+func (s *writerSubscription) Unsubscribe() error {
+	return nil
+}
+
+// Channel returns the channel the subscription belongs to
+func (s *writerSubscription) Channel() string {
+	return s.channel
+}
+
+// natsTransport publishes and subscribes to a NATS server given by URL, with the same message
+// format as the transport of the KPS data pipelines
+type natsTransport struct {
+	conn *nats.Conn
+}
+
+var _ transport.Client = (*natsTransport)(nil)
+
+// NewNATSTransport connects to the NATS server at url
+func NewNATSTransport(url string) (transport.Client, error) {
+	conn, err := nats.Connect(url, nats.Name(ConnectorCfg.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS at %s: %w", url, err)
+	}
+	return &natsTransport{conn: conn}, nil
+}
+
+// Publish publishes the message onto the provided channel
+func (t *natsTransport) Publish(channel string, msg transport.Message) error {
+	data, err := proto.Marshal(&connectorpb.TransportMessage{
+		Timestamp: time.Now().UnixNano(),
+		Payload:   [][]byte{msg.Payload},
+	})
+	if err != nil {
+		return err
+	}
+	return t.conn.Publish(channel, data)
+}
+
+// Subscribe subscribes all future messages on the channel and registers a callback
+func (t *natsTransport) Subscribe(channel string, callback transport.MessageHandler) (transport.Subscription, error) {
+	sub, err := t.conn.Subscribe(channel, func(msg *nats.Msg) {
+		var tMsg connectorpb.TransportMessage
+		if err := proto.Unmarshal(msg.Data, &tMsg); err != nil || len(tMsg.Payload) == 0 {
+			warnf("unable to unmarshal data from %s", msg.Subject)
+			return
+		}
+		callback(&transport.Message{Payload: tMsg.Payload[0]})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &natsSubscription{Subscription: sub}, nil
+}
+
+type natsSubscription struct {
+	*nats.Subscription
+}
+
+// Channel returns the channel the subscription belongs to
+func (s *natsSubscription) Channel() string {
+	return s.Subject
+}
@@ -0,0 +1,337 @@
+package connector
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+)
+
+const (
+	// watchdogInterval is how often the watchdog checks the consumer loops of the ingress streams
+	watchdogInterval = 1 * time.Second
+	// defaultStallTimeout is used for streams without `stall-timeout`
+	defaultStallTimeout = 1 * time.Minute
+	// restartRetryInterval is the time between two attempts to start a stream again whose
+	// restart by the watchdog failed
+	restartRetryInterval = 30 * time.Second
+)
+
+// loopState records the progress of the consumer loop of a stream, so that the watchdog can
+// tell a wedged loop from a quiet one. It is safe for concurrent use.
+type loopState struct {
+	mtx sync.Mutex
+	// started is the start of the cycle in progress, zero between two cycles
+	started time.Time
+	// throttled is set while the loop waits for `max_reads_per_second`
+	throttled bool
+	// slot is the slot the next cycle of a stream with a schedule reads, its due time
+	slot time.Time
+
+	lastStart    time.Time
+	lastEnd      time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	lastJitter   time.Duration
+}
+
+// connecting marks the loop as connecting to the PLC before its first cycle, the watchdog
+// checks the connect like a cycle in progress
+func (l *loopState) connecting(now time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.started = now
+}
+
+// reset marks the start of the loop. Its first cycle is due at slot for a stream with a
+// schedule, one polling interval later otherwise.
+func (l *loopState) reset(now, slot time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.started = time.Time{}
+	l.throttled = false
+	l.lastEnd = now
+	l.slot = slot
+}
+
+// setSlot sets the slot the next cycle of a stream with a schedule reads
+func (l *loopState) setSlot(slot time.Time) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.slot = slot
+}
+
+// nextSlot returns the slot the next cycle reads, zero for a stream without a schedule
+func (l *loopState) nextSlot() time.Time {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.slot
+}
+
+// due returns when the next cycle is due: at its slot, or one polling interval after the end
+// of the previous cycle. l.mtx must be held.
+func (l *loopState) due(interval time.Duration) time.Time {
+	if !l.slot.IsZero() {
+		return l.slot
+	}
+	return l.lastEnd.Add(interval)
+}
+
+// dueIn returns the time until the next cycle is due
+func (l *loopState) dueIn(now time.Time, interval time.Duration) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.due(interval).Sub(now)
+}
+
+// setThrottled marks the loop as waiting for the read limiter, the watchdog leaves it alone meanwhile
+func (l *loopState) setThrottled(throttled bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.throttled = throttled
+}
+
+// begin marks the start of a cycle and returns its jitter, how late it started after it was due
+func (l *loopState) begin(now time.Time, interval time.Duration) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	jitter := now.Sub(l.due(interval))
+	if jitter < 0 {
+		jitter = 0
+	}
+	l.started = now
+	l.throttled = false
+	l.lastStart = now
+	l.lastJitter = jitter
+	return jitter
+}
+
+// end marks the end of the cycle in progress and returns how long it took, succeeded is set
+// if the cycle published its message without a failure
+func (l *loopState) end(now time.Time, succeeded bool) time.Duration {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.lastDuration = now.Sub(l.started)
+	l.started = time.Time{}
+	l.lastEnd = now
+	if succeeded {
+		l.lastSuccess = now
+	}
+	return l.lastDuration
+}
+
+// stalled reports for how long the loop has made no progress, and whether that is longer
+// than timeout: a cycle has run for timeout, or the next cycle is overdue by timeout
+func (l *loopState) stalled(now time.Time, interval, timeout time.Duration) (time.Duration, bool) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if !l.started.IsZero() {
+		running := now.Sub(l.started)
+		return running, running > timeout
+	}
+	if l.throttled {
+		return 0, false
+	}
+	overdue := now.Sub(l.due(interval))
+	return overdue, overdue > timeout
+}
+
+// snapshot returns the timings of the last cycle as event metadata
+func (l *loopState) snapshot() map[string]interface{} {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return map[string]interface{}{
+		"lastCycleStart":      formatTime(l.lastStart),
+		"lastCycleEnd":        formatTime(l.lastEnd),
+		"lastSuccessfulCycle": formatTime(l.lastSuccess),
+		"lastCycleMs":         durationMs(l.lastDuration),
+		"lastJitterMs":        durationMs(l.lastJitter),
+	}
+}
+
+// durationMs renders a duration for event metadata
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timingConditions reports a cycle that took longer than the polling interval, and one that
+// started a polling interval or more after it was due
+func timingConditions(elapsed, jitter, interval time.Duration) []condition {
+	var conditions []condition
+	timings := map[string]interface{}{
+		"cycleMs":    durationMs(elapsed),
+		"jitterMs":   durationMs(jitter),
+		"intervalMs": durationMs(interval),
+	}
+	if elapsed > interval {
+		conditions = append(conditions, condition{
+			alert: scanOverrunAlert,
+			key:   "cycle",
+			err:   fmt.Errorf("poll cycle took %s, the polling interval is %s", elapsed, interval),
+			extra: timings,
+		})
+	}
+	if jitter >= interval {
+		conditions = append(conditions, condition{
+			alert: scanJitterAlert,
+			key:   "cycle",
+			err:   fmt.Errorf("poll cycle started %s late, the polling interval is %s", jitter, interval),
+			extra: timings,
+		})
+	}
+	return conditions
+}
+
+// pendingRestart is an ingress stream the watchdog starts again at next: one it stopped, or
+// one that failed to start
+type pendingRestart struct {
+	stream *connectorpb.Stream
+	next   time.Time
+}
+
+// restart is the work of the watchdog on one stream, done without holding s.mtx
+type restart struct {
+	pending *pendingRestart
+	events  *streamEvents
+	// stalled is the stream the watchdog stopped, nil when retrying a stream that failed to start
+	stalled *activeInStream
+}
+
+// watchdog checks the consumer loops every watchdogInterval until stop is closed
+func (s *supervisor) watchdog(stop <-chan struct{}) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.checkStreams(now)
+		}
+	}
+}
+
+// checkStreams restarts the ingress streams whose consumer loop stalled, and retries the
+// streams that failed to start. The restarts are collected under s.mtx and run concurrently
+// without it, so that neither SetPayload and the shutdown nor the other restarts wait for a
+// stalled loop to stop.
+func (s *supervisor) checkStreams(now time.Time) {
+	type stalledStream struct {
+		in         *activeInStream
+		stalledFor time.Duration
+	}
+	var stalled []stalledStream
+	s.streamsMtx.RLock()
+	for _, in := range s.activeInStreams {
+		if d, ok := in.consumer.stalled(now); ok {
+			stalled = append(stalled, stalledStream{in, d})
+		}
+	}
+	s.streamsMtx.RUnlock()
+
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return
+	}
+	var restarts []restart
+	for streamID, pending := range s.restarts {
+		if now.Before(pending.next) {
+			continue
+		}
+		restarts = append(restarts, restart{pending: pending, events: s.eventsFor(streamID)})
+	}
+	for _, st := range stalled {
+		if r, ok := s.stopStalled(st.in, st.stalledFor); ok {
+			restarts = append(restarts, r)
+		}
+	}
+	s.mtx.Unlock()
+
+	var wg sync.WaitGroup
+	for _, r := range restarts {
+		wg.Add(1)
+		go func(r restart) {
+			defer wg.Done()
+			s.restart(r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// stopStalled cancels a stream whose consumer loop stalled and records it in s.restarts until
+// it runs again. s.mtx must be held.
+func (s *supervisor) stopStalled(in *activeInStream, stalledFor time.Duration) (restart, bool) {
+	streamID := in.stream.GetId()
+	if s.activeInStreams[streamID] != in {
+		// SetPayload changed the stream since it was checked
+		return restart{}, false
+	}
+	stallTimeout := in.consumer.getStallTimeout()
+	log := streamLogger(streamID).WithField("plc", in.consumer.plc)
+	log.Warnf("no progress of the poll cycles for %s, restarting the stream", stalledFor)
+	extra := in.consumer.loop.snapshot()
+	extra["stalledMs"] = durationMs(stalledFor)
+	extra["stallTimeoutMs"] = durationMs(stallTimeout)
+	in.consumer.events.raise(condition{
+		alert: streamStalledAlert,
+		key:   "loop",
+		err:   fmt.Errorf("no progress of the poll cycles for %s, the stall timeout is %s", stalledFor, stallTimeout),
+		extra: extra,
+	})
+	streamRestartsTotal.WithLabelValues(streamID).Inc()
+
+	in.cancel()
+	s.streamsMtx.Lock()
+	delete(s.activeInStreams, streamID)
+	s.streamsMtx.Unlock()
+	pending := &pendingRestart{stream: in.stream}
+	s.restarts[streamID] = pending
+	return restart{pending: pending, events: in.consumer.events, stalled: in}, true
+}
+
+// restart waits for a stalled stream to stop and starts it again, retrying after
+// restartRetryInterval if it fails. A loop that doesn't stop within streamStopTimeout is
+// abandoned, its PLC connection is not reused. s.mtx must not be held.
+func (s *supervisor) restart(r restart) {
+	stream := r.pending.stream
+	log := streamLogger(stream.GetId())
+	if r.stalled != nil {
+		timer := time.NewTimer(streamStopTimeout)
+		select {
+		case <-r.stalled.done:
+		case <-timer.C:
+			log.Warnf("abandoning the consumer loop, it did not stop within %s", streamStopTimeout)
+		}
+		timer.Stop()
+	}
+	in, start, err := s.prepareInStream(stream, r.events)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed || s.restarts[stream.GetId()] != r.pending {
+		// SetPayload or the shutdown decided about the stream meanwhile
+		if err == nil {
+			in.cancel()
+			in.consumer.close()
+		}
+		return
+	}
+	s.streamsMtx.Lock()
+	if err != nil {
+		s.failedStreams[stream.GetId()] = err
+	} else {
+		delete(s.failedStreams, stream.GetId())
+		s.activeInStreams[stream.GetId()] = in
+	}
+	s.streamsMtx.Unlock()
+	if err != nil {
+		log.Warnf("unable to restart the stream, retrying in %s: %s", restartRetryInterval, err)
+		r.pending.next = time.Now().Add(restartRetryInterval)
+		return
+	}
+	delete(s.restarts, stream.GetId())
+	start()
+	log.Info("starting streaming stream")
+}
@@ -0,0 +1,74 @@
+package connector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoopStateStalled(t *testing.T) {
+	start := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	interval := 1 * time.Second
+	timeout := 10 * time.Second
+	tests := []struct {
+		name        string
+		loop        func(l *loopState)
+		now         time.Time
+		wantFor     time.Duration
+		wantStalled bool
+	}{
+		{"cycle running", func(l *loopState) {
+			l.reset(start, time.Time{})
+			l.begin(start.Add(interval), interval)
+		}, start.Add(interval + 5*time.Second), 5 * time.Second, false},
+		{"cycle running for the timeout", func(l *loopState) {
+			l.reset(start, time.Time{})
+			l.begin(start.Add(interval), interval)
+		}, start.Add(interval + timeout + time.Millisecond), timeout + time.Millisecond, true},
+		{"connecting for the timeout", func(l *loopState) {
+			l.connecting(start)
+		}, start.Add(timeout + time.Millisecond), timeout + time.Millisecond, true},
+		{"cycle due", func(l *loopState) {
+			l.reset(start, time.Time{})
+		}, start.Add(interval), 0, false},
+		{"interval overdue", func(l *loopState) {
+			l.reset(start, time.Time{})
+			l.begin(start.Add(interval), interval)
+			l.end(start.Add(interval+time.Second), true)
+		}, start.Add(2*interval + time.Second + timeout + time.Millisecond), timeout + time.Millisecond, true},
+		{"interval overdue within the timeout", func(l *loopState) {
+			l.reset(start, time.Time{})
+		}, start.Add(interval + timeout), timeout, false},
+		{"throttled", func(l *loopState) {
+			l.reset(start, time.Time{})
+			l.setThrottled(true)
+		}, start.Add(interval + 2*timeout), 0, false},
+		{"throttled ends with the cycle", func(l *loopState) {
+			l.reset(start, time.Time{})
+			l.setThrottled(true)
+			l.begin(start.Add(interval), interval)
+			l.end(start.Add(interval), true)
+		}, start.Add(2*interval + 2*timeout), 2 * timeout, true},
+		{"slot due", func(l *loopState) {
+			l.reset(start, start.Add(time.Hour))
+		}, start.Add(30 * time.Minute), -30 * time.Minute, false},
+		{"slot overdue", func(l *loopState) {
+			l.reset(start, start.Add(time.Hour))
+		}, start.Add(time.Hour + timeout + time.Millisecond), timeout + time.Millisecond, true},
+		{"slot set by the cycle overdue", func(l *loopState) {
+			l.reset(start, start.Add(time.Hour))
+			l.begin(start.Add(time.Hour), interval)
+			l.end(start.Add(time.Hour+time.Second), true)
+			l.setSlot(start.Add(2 * time.Hour))
+		}, start.Add(2*time.Hour + 2*timeout), 2 * timeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l loopState
+			tt.loop(&l)
+			stalledFor, stalled := l.stalled(tt.now, interval, timeout)
+			if stalledFor != tt.wantFor || stalled != tt.wantStalled {
+				t.Errorf("stalled = %s, %t, want %s, %t", stalledFor, stalled, tt.wantFor, tt.wantStalled)
+			}
+		})
+	}
+}
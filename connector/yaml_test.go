@@ -0,0 +1,167 @@
+package connector
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAMLDeployFiles(t *testing.T) {
+	tests := []struct {
+		file string
+		want interface{}
+	}{
+		{"../deploy/standalone-streams.yaml", map[string]interface{}{
+			"config": map[string]interface{}{"log_level": "INFO"},
+			"streams": []interface{}{
+				map[string]interface{}{
+					"id":               "plc4x-stream-in",
+					"direction":        "ingress",
+					"transportChannel": "plc4x-stream-in",
+					"metadata": map[string]interface{}{
+						"plc":              "modbus:tcp://127.0.0.1:5020",
+						"polling-interval": float64(1000),
+						"addresses": []interface{}{
+							map[string]interface{}{"name": "Field1", "address": "holding-register:123:INT"},
+							map[string]interface{}{"name": "Field2", "address": "holding-register:246:INT"},
+						},
+					},
+				},
+			},
+		}},
+		{"../deploy/example-connect-string-plc4x.yaml", map[string]interface{}{
+			"plc": map[string]interface{}{
+				"connection": "opcua:tcp://192.168.178.120:53530/opcua/SimulationServer",
+				"addresses": []interface{}{
+					map[string]interface{}{"name": "Counter", "address": "ns=3;s=Counter"},
+					map[string]interface{}{"name": "Sinusoid", "address": "ns=3;s=Sinusoid"},
+				},
+			},
+			"polling-interval": float64(100),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseYAML(data)
+			if err != nil {
+				t.Fatalf("parseYAML: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want interface{}
+	}{
+		{"empty", "# only a comment\n\n", nil},
+		{"document start", "---\na: 1\n", map[string]interface{}{"a": float64(1)}},
+		{"sequence at the key's indentation",
+			"a:\n- 1\n- 2\nb: x\n",
+			map[string]interface{}{"a": []interface{}{float64(1), float64(2)}, "b": "x"}},
+		{"indented sequence",
+			"a:\n  - 1\n  - 2\n",
+			map[string]interface{}{"a": []interface{}{float64(1), float64(2)}}},
+		{"mapping items",
+			"- a: 1\n  b: 2\n- c: 3\n",
+			[]interface{}{
+				map[string]interface{}{"a": float64(1), "b": float64(2)},
+				map[string]interface{}{"c": float64(3)},
+			}},
+		{"nested item on the next line",
+			"-\n  a: 1\n",
+			[]interface{}{map[string]interface{}{"a": float64(1)}}},
+		{"sequence of sequences",
+			"- - 1\n  - 2\n- - 3\n",
+			[]interface{}{[]interface{}{float64(1), float64(2)}, []interface{}{float64(3)}}},
+		{"empty value", "a:\nb: 1\n", map[string]interface{}{"a": nil, "b": float64(1)}},
+		{"quoted keys",
+			"\"a: b\": 1\n'c''d': 2\n",
+			map[string]interface{}{"a: b": float64(1), "c'd": float64(2)}},
+		{"comments",
+			"a: x # comment\nb: \"# not a comment\"\nc: 'x # y' # comment\nd: x#y\ne: it's # comment\n",
+			map[string]interface{}{"a": "x", "b": "# not a comment", "c": "x # y", "d": "x#y", "e": "it's"}},
+		{"colons in values",
+			"plc: modbus:tcp://127.0.0.1:5020\naddress: holding-register:1:INT\n",
+			map[string]interface{}{"plc": "modbus:tcp://127.0.0.1:5020", "address": "holding-register:1:INT"}},
+		{"numbers",
+			"a: 12\nb: -3\nc: 0x1F\nd: 1.5\ne: 1e3\nf: 1e400\ng: 0xZZ\nh: 007\n",
+			map[string]interface{}{
+				"a": float64(12), "b": float64(-3), "c": float64(31), "d": 1.5, "e": float64(1000),
+				"f": "1e400", "g": "0xZZ", "h": float64(7),
+			}},
+		{"null and booleans",
+			"a: ~\nb: null\nc: NULL\nd: true\ne: False\nf: TRUE\ng: yes\n",
+			map[string]interface{}{"a": nil, "b": nil, "c": nil, "d": true, "e": false, "f": true, "g": "yes"}},
+		{"strings",
+			"a: \"x\\ty\"\nb: 'it''s'\nc: '1'\nd: plain text\n",
+			map[string]interface{}{"a": "x\ty", "b": "it's", "c": "1", "d": "plain text"}},
+		{"flow collections",
+			"a: [\"-f\", 1]\nb: {\"c\": [true]}\n",
+			map[string]interface{}{
+				"a": []interface{}{"-f", float64(1)},
+				"b": map[string]interface{}{"c": []interface{}{true}},
+			}},
+		{"carriage returns", "a: 1\r\nb: 2\r\n", map[string]interface{}{"a": float64(1), "b": float64(2)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("parseYAML(%q): %s", tt.doc, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML(%q) = %#v, want %#v", tt.doc, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "line 2: tabs are not allowed for indentation"},
+		{"multiple documents", "a: 1\n---\nb: 2\n", "line 2: multiple documents are not supported"},
+		{"document end", "a: 1\n...\n", "line 2: multiple documents are not supported"},
+		{"literal block scalar", "a: |\n  text\n", "line 1: block scalars are not supported"},
+		{"folded block scalar", "a: >-\n  text\n", "line 1: block scalars are not supported"},
+		{"anchor", "a: &x 1\n", "line 1: anchors, aliases and tags are not supported"},
+		{"alias", "a: *x\n", "line 1: anchors, aliases and tags are not supported"},
+		{"tag", "a: !!str 1\n", "line 1: anchors, aliases and tags are not supported"},
+		{"alias item", "- *x\n", "line 1: anchors, aliases and tags are not supported"},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", "line 3: duplicate key \"a\""},
+		{"plain scalar", "a: 1\nplain\n", "line 2: expected a mapping key"},
+		{"deeper key", "a: 1\n  b: 2\n", "line 2: expected a mapping key"},
+		{"item in a mapping", "a: 1\n- 2\n", "line 2: expected a mapping key"},
+		{"deeper item", "- 1\n  - 2\n", "line 2: expected a sequence item"},
+		{"content after the document", "  a: 1\nb: 2\n", "line 2: unexpected content after the document"},
+		{"unterminated string", "a: 'x\n", "line 1: unterminated string 'x"},
+		{"invalid flow value", "a: [1,\n", "line 1: invalid flow value [1,"},
+		{"invalid quoted string", "a: \"x\\q\"\n", "line 1: invalid flow value"},
+		{"flow key", "{a: 1}\n", "line 1: expected a mapping key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.doc))
+			if err == nil {
+				t.Fatalf("parseYAML(%q) succeeded, want %q", tt.doc, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseYAML(%q) = %q, want %q", tt.doc, err, tt.err)
+			}
+		})
+	}
+}
@@ -6,9 +6,13 @@ go 1.15
 require (
 	github.com/apache/plc4x/plc4go v0.0.0-20210219073003-e296ad46cf80
 	github.com/gookit/color v1.3.7 // indirect
+	github.com/nats-io/nats.go v1.10.0
 	github.com/nbutton23/zxcvbn-go v0.0.0-20210217022336-fa2cb2858354 // indirect
 	github.com/nutanix/kps-connector-go-sdk v0.0.0-20210211173359-6b1301ef3741
+	github.com/prometheus/client_golang v1.9.0
+	github.com/prometheus/common v0.15.0
 	github.com/securego/gosec/v2 v2.6.1 // indirect
+	github.com/sirupsen/logrus v1.7.0
 	golang.org/x/lint v0.0.0-20201208152925-83fdc39ff7b5 // indirect
 	golang.org/x/mod v0.4.1 // indirect
 	golang.org/x/sys v0.0.0-20210218155724-8ebf48af031b // indirect
@@ -0,0 +1,196 @@
+// Package health implements the standard gRPC health checking protocol `grpc.health.v1.Health`
+// and the equivalent HTTP probes. The messages are declared by hand with protobuf struct tags,
+// since the vendored grpc release doesn't ship the generated health package.
+package health
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// watchInterval is how often Watch re-evaluates the health of the watched service
+const watchInterval = 1 * time.Second
+
+// ServingStatus is the status of a service as defined by grpc/health/v1/health.proto
+type ServingStatus int32
+
+// Serving statuses of grpc/health/v1/health.proto
+const (
+	Unknown        ServingStatus = 0
+	Serving        ServingStatus = 1
+	NotServing     ServingStatus = 2
+	ServiceUnknown ServingStatus = 3
+)
+
+var servingStatusNames = map[ServingStatus]string{
+	Unknown:        "UNKNOWN",
+	Serving:        "SERVING",
+	NotServing:     "NOT_SERVING",
+	ServiceUnknown: "SERVICE_UNKNOWN",
+}
+
+func (s ServingStatus) String() string {
+	return servingStatusNames[s]
+}
+
+// HealthCheckRequest is the `grpc.health.v1.HealthCheckRequest` message
+type HealthCheckRequest struct {
+	Service string `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+}
+
+// Reset implements proto.Message
+func (m *HealthCheckRequest) Reset() { *m = HealthCheckRequest{} }
+
+// String implements proto.Message
+func (m *HealthCheckRequest) String() string { return fmt.Sprintf("service:%q", m.Service) }
+
+// ProtoMessage implements proto.Message
+func (*HealthCheckRequest) ProtoMessage() {}
+
+// HealthCheckResponse is the `grpc.health.v1.HealthCheckResponse` message
+type HealthCheckResponse struct {
+	Status ServingStatus `protobuf:"varint,1,opt,name=status,proto3,enum=grpc.health.v1.HealthCheckResponse_ServingStatus" json:"status,omitempty"`
+}
+
+// Reset implements proto.Message
+func (m *HealthCheckResponse) Reset() { *m = HealthCheckResponse{} }
+
+// String implements proto.Message
+func (m *HealthCheckResponse) String() string { return fmt.Sprintf("status:%s", m.Status) }
+
+// ProtoMessage implements proto.Message
+func (*HealthCheckResponse) ProtoMessage() {}
+
+// Checker reports the health of the named services of a server. The overall health of the
+// server, the empty service name, is serving if all services are.
+type Checker interface {
+	ServiceHealth() map[string]bool
+}
+
+// check returns the status of a service, and false if the service is unknown
+func check(checker Checker, service string) (ServingStatus, bool) {
+	services := checker.ServiceHealth()
+	if service == "" {
+		return overallStatus(services), true
+	}
+	healthy, ok := services[service]
+	if !ok {
+		return ServiceUnknown, false
+	}
+	if healthy {
+		return Serving, true
+	}
+	return NotServing, true
+}
+
+func overallStatus(services map[string]bool) ServingStatus {
+	for _, healthy := range services {
+		if !healthy {
+			return NotServing
+		}
+	}
+	return Serving
+}
+
+// Server implements the `grpc.health.v1.Health` service
+type Server struct {
+	checker Checker
+}
+
+// NewServer creates a health service reporting the health computed by checker
+func NewServer(checker Checker) *Server {
+	return &Server{checker: checker}
+}
+
+// Check returns the status of the requested service, or a NotFound error if it is unknown
+func (s *Server) Check(ctx context.Context, req *HealthCheckRequest) (*HealthCheckResponse, error) {
+	servingStatus, ok := check(s.checker, req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &HealthCheckResponse{Status: servingStatus}, nil
+}
+
+// Watch sends the status of the requested service, and again whenever it changes. Unknown
+// services are reported as ServiceUnknown, since they may appear later.
+func (s *Server) Watch(req *HealthCheckRequest, stream grpc.ServerStream) error {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	last := ServingStatus(-1)
+	for {
+		servingStatus, _ := check(s.checker, req.Service)
+		if servingStatus != last {
+			if err := stream.SendMsg(&HealthCheckResponse{Status: servingStatus}); err != nil {
+				return err
+			}
+			last = servingStatus
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthServer is the handler type of serviceDesc
+type healthServer interface {
+	Check(context.Context, *HealthCheckRequest) (*HealthCheckResponse, error)
+	Watch(*HealthCheckRequest, grpc.ServerStream) error
+}
+
+// RegisterHealthServer registers the health service with a grpc server
+func RegisterHealthServer(s *grpc.Server, srv *Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+func checkHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(healthServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/grpc.health.v1.Health/Check",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(healthServer).Check(ctx, req.(*HealthCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(HealthCheckRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(healthServer).Watch(in, stream)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "grpc.health.v1.Health",
+	HandlerType: (*healthServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Check",
+			Handler:    checkHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "grpc/health/v1/health.proto",
+}
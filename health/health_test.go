@@ -0,0 +1,153 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeChecker reports the health of fixed services, it is safe for concurrent use
+type fakeChecker struct {
+	mtx      sync.Mutex
+	services map[string]bool
+}
+
+func (c *fakeChecker) ServiceHealth() map[string]bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	services := make(map[string]bool, len(c.services))
+	for name, healthy := range c.services {
+		services[name] = healthy
+	}
+	return services
+}
+
+func (c *fakeChecker) set(service string, healthy bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.services[service] = healthy
+}
+
+// serve starts a grpc server with the health service and returns a connection to it
+func serve(t *testing.T, checker Checker) *grpc.ClientConn {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	RegisterHealthServer(s, NewServer(checker))
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestCheck(t *testing.T) {
+	conn := serve(t, &fakeChecker{services: map[string]bool{"s1": true, "s2": false}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		service string
+		want    ServingStatus
+		code    codes.Code
+	}{
+		{"", NotServing, codes.OK},
+		{"s1", Serving, codes.OK},
+		{"s2", NotServing, codes.OK},
+		{"s3", Unknown, codes.NotFound},
+	}
+	for _, tt := range tests {
+		resp := new(HealthCheckResponse)
+		err := conn.Invoke(ctx, "/grpc.health.v1.Health/Check", &HealthCheckRequest{Service: tt.service}, resp)
+		if status.Code(err) != tt.code {
+			t.Errorf("Check(%q) = %v, want code %s", tt.service, err, tt.code)
+			continue
+		}
+		if resp.Status != tt.want {
+			t.Errorf("Check(%q) = %s, want %s", tt.service, resp.Status, tt.want)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	checker := &fakeChecker{services: map[string]bool{"s1": true}}
+	conn := serve(t, checker)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	desc := &grpc.StreamDesc{StreamName: "Watch", ServerStreams: true}
+	stream, err := conn.NewStream(ctx, desc, "/grpc.health.v1.Health/Watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.SendMsg(&HealthCheckRequest{Service: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []ServingStatus{Serving, NotServing} {
+		resp := new(HealthCheckResponse)
+		if err := stream.RecvMsg(resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Errorf("Watch sent %s, want %s", resp.Status, want)
+		}
+		checker.set("s1", false)
+	}
+}
+
+// rawCodec sends and receives the bytes of the messages as they are, so that the wire
+// format of the messages can be compared with the encoding of health.proto
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) { return *v.(*[]byte), nil }
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
+
+func TestWireFormat(t *testing.T) {
+	conn := serve(t, &fakeChecker{services: map[string]bool{"abc": true, "def": false}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		request  []byte
+		response []byte
+	}{
+		// field 1 `service` "abc", answered with field 1 `status` SERVING
+		{[]byte{0x0A, 0x03, 'a', 'b', 'c'}, []byte{0x08, 0x01}},
+		{[]byte{0x0A, 0x03, 'd', 'e', 'f'}, []byte{0x08, 0x02}},
+		// the empty service is the default value, it isn't encoded at all
+		{[]byte{}, []byte{0x08, 0x02}},
+	}
+	for _, tt := range tests {
+		var response []byte
+		err := conn.Invoke(ctx, "/grpc.health.v1.Health/Check", &tt.request, &response, grpc.ForceCodec(rawCodec{}))
+		if err != nil {
+			t.Fatalf("Check(% x): %s", tt.request, err)
+		}
+		if !bytes.Equal(response, tt.response) {
+			t.Errorf("Check(% x) = % x, want % x", tt.request, response, tt.response)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// LivenessHandler serves `/healthz`. The process is alive as long as it can answer, a broken
+// PLC or transport connection is reported by the readiness probe instead, since restarting the
+// pod doesn't fix it.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadinessHandler serves `/readyz`: 200 if all services are serving, 503 otherwise. The body
+// lists the status of every service, so operators can tell which one is broken.
+func ReadinessHandler(checker Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		services := checker.ServiceHealth()
+		statuses := make(map[string]string, len(services))
+		for service, healthy := range services {
+			if healthy {
+				statuses[service] = Serving.String()
+			} else {
+				statuses[service] = NotServing.String()
+			}
+		}
+		overall := overallStatus(services)
+
+		w.Header().Set("Content-Type", "application/json")
+		if overall != Serving {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(struct {
+			Status   string            `json:"status"`
+			Services map[string]string `json:"services"`
+		}{overall.String(), statuses})
+	})
+}
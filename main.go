@@ -1,23 +1,52 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	connectorpb "github.com/nutanix/kps-connector-go-sdk/connector/v1"
+	"github.com/nutanix/kps-connector-go-template/auth"
 	"github.com/nutanix/kps-connector-go-template/connector"
+	"github.com/nutanix/kps-connector-go-template/health"
+	"github.com/nutanix/kps-connector-go-template/tracing"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const (
 	// this is the port on which the connector instance will be listening to for the GRPC calls
 	connectorInstanceServerPort = 8000
+
+	// shutdownTimeout bounds how long the connector may take to stop its streams and the grpc server
+	shutdownTimeout = 20 * time.Second
 )
 
 func main() {
+	shutdownTracing, err := tracing.Setup(connector.ConnectorCfg.TraceExporter, connector.ConnectorCfg.OTLPEndpoint, connector.ConnectorCfg.Name)
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %s", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("failed to export the remaining spans: %s", err)
+		}
+	}()
+
+	if streamFile != "" {
+		runStandalone()
+		return
+	}
+
 	log.Printf("%s - Start", connector.ConnectorCfg.Name)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", connectorInstanceServerPort))
@@ -27,17 +56,136 @@ func main() {
 
 	log.Printf("successfully set up listener on %d\n", connectorInstanceServerPort)
 
-	var opts []grpc.ServerOption
+	opts, err := serverOptions()
+	if err != nil {
+		log.Fatalf("failed to set up grpc server security: %s", err)
+	}
 
-	srv := connector.NewConnector()
+	// the signals are handled from the start, so that a SIGTERM during the restore stops the
+	// connector gracefully
+	signals := notifyShutdownSignals()
 
+	srv := connector.NewConnector()
 	grpcServer := grpc.NewServer(opts...)
 	connectorpb.RegisterConnectorServiceServer(grpcServer, srv)
+	health.RegisterHealthServer(grpcServer, health.NewServer(srv))
+
+	httpServer := newHTTPServer(srv)
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		shutdownOnSignal(signals, grpcServer, httpServer, srv)
+	}()
+
+	// resume the streams of the last run right away, KPS may take minutes to send them again.
+	// Starting them may take a while, the grpc calls and probes are served meanwhile.
+	go func() {
+		if err := srv.RestoreState(context.Background()); err != nil {
+			log.Printf("failed to restore state: %s", err)
+		}
+	}()
 
 	log.Printf("starting to serve grpc server on %s\n", lis.Addr())
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to run the grpc server: %s", err)
 	}
+	// Serve returns as soon as the shutdown begins
+	<-shutdownDone
+	log.Printf("%s - Stopped", connector.ConnectorCfg.Name)
+}
+
+// notifyShutdownSignals relays SIGTERM and SIGINT to the returned channel
+func notifyShutdownSignals() <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	return signals
+}
+
+// shutdownOnSignal waits for SIGTERM or SIGINT, then stops accepting grpc calls, waits for the
+// running ones, and stops all streams. Whatever hasn't stopped after shutdownTimeout is dropped.
+// grpcServer is nil in standalone mode.
+func shutdownOnSignal(signals <-chan os.Signal, grpcServer *grpc.Server, httpServer *http.Server, srv *connector.Connector) {
+	sig := <-signals
+	log.Printf("received %s, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			log.Printf("grpc server did not stop in time, closing open connections")
+			grpcServer.Stop()
+		}
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("failed to stop all streams: %s", err)
+	}
+	if httpServer != nil {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("failed to stop the http server: %s", err)
+		}
+	}
+}
+
+// serverOptions configures tracing, TLS and bearer token authentication of the grpc server
+func serverOptions() ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	// the tracing interceptor comes first, so that calls rejected by the authentication are traced as well
+	interceptors := []grpc.UnaryServerInterceptor{tracing.UnaryServerInterceptor()}
+	cfg := connector.ConnectorCfg
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		tlsConfig, err := auth.ServerTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		log.Printf("grpc server uses TLS, client certificates required: %t", cfg.TLSClientCAFile != "")
+	} else if cfg.TLSClientCAFile != "" {
+		return nil, fmt.Errorf("client certificates require a server certificate and key")
+	} else {
+		log.Printf("grpc server uses plaintext, set -tlsCert and -tlsKey to enable TLS")
+	}
+	if cfg.AuthTokenFile != "" {
+		authenticator, err := auth.NewTokenAuthenticator(cfg.AuthTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		interceptors = append(interceptors, authenticator.UnaryInterceptor())
+		log.Printf("grpc server requires a bearer token")
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
+	return opts, nil
+}
+
+// newHTTPServer starts the HTTP server serving the metrics and probes, it returns nil if it is disabled
+func newHTTPServer(srv *connector.Connector) *http.Server {
+	if connector.ConnectorCfg.MetricsPort == 0 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", connector.MetricsHandler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler(srv))
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", connector.ConnectorCfg.MetricsPort),
+		Handler: mux,
+	}
+	go func() {
+		log.Printf("starting to serve metrics and probes on %s\n", httpServer.Addr)
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("failed to run the http server: %s", err)
+		}
+	}()
+	return httpServer
 }
 
 func init() {
@@ -46,6 +194,54 @@ func init() {
 		"connectorInstanceID",
 		os.Getenv("CONNECTOR_INSTANCE_ID"),
 		"UUID of the connector instance")
+	flag.IntVar(&connector.ConnectorCfg.MetricsPort,
+		"metricsPort",
+		9102,
+		"port of the HTTP server serving prometheus metrics on /metrics and the /healthz and /readyz probes, 0 disables it")
+	flag.StringVar(&connector.ConnectorCfg.TLSCertFile,
+		"tlsCert",
+		os.Getenv("TLS_CERT_FILE"),
+		"certificate file of the grpc server, enables TLS together with -tlsKey")
+	flag.StringVar(&connector.ConnectorCfg.TLSKeyFile,
+		"tlsKey",
+		os.Getenv("TLS_KEY_FILE"),
+		"private key file of the grpc server certificate")
+	flag.StringVar(&connector.ConnectorCfg.TLSClientCAFile,
+		"tlsClientCA",
+		os.Getenv("TLS_CLIENT_CA_FILE"),
+		"CA file the certificates of grpc clients must be signed with, enables mTLS")
+	flag.StringVar(&connector.ConnectorCfg.AuthTokenFile,
+		"authTokenFile",
+		os.Getenv("AUTH_TOKEN_FILE"),
+		"file holding the bearer token grpc calls must present in their authorization metadata")
+	flag.StringVar(&connector.ConnectorCfg.StateDir,
+		"stateDir",
+		os.Getenv("STATE_DIR"),
+		"directory on a persistent volume to save the streams and config to and restore them from on startup")
+	flag.StringVar(&streamFile,
+		"streamFile",
+		"",
+		"run standalone without KPS: load the config and streams from this YAML or JSON file instead of serving grpc")
+	flag.StringVar(&output,
+		"output",
+		"stdout",
+		"where standalone streams publish their messages: stdout, file:<path> or a nats:// URL")
+	flag.StringVar(&connector.ConnectorCfg.TraceExporter,
+		"traceExporter",
+		os.Getenv("TRACE_EXPORTER"),
+		"where to export trace spans: none (the default), stdout or otlp")
+	flag.StringVar(&connector.ConnectorCfg.OTLPEndpoint,
+		"otlpEndpoint",
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"OTLP/HTTP endpoint of the otlp trace exporter, defaults to http://localhost:4318")
+	flag.StringVar(&connector.ConnectorCfg.LogFormat,
+		"logFormat",
+		os.Getenv("LOG_FORMAT"),
+		"format of the logs: text (the default) or json, for log collectors indexing the fields")
 
 	flag.Parse()
+
+	if err := connector.SetupLogging(connector.ConnectorCfg.LogFormat); err != nil {
+		log.Fatalf("failed to set up logging: %s", err)
+	}
 }
@@ -0,0 +1,289 @@
+package modbus
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when a unit doesn't answer a request before the context deadline
+	ErrTimeout = errors.New("modbus: request timed out")
+	// ErrClosed is returned for requests on a client whose connection has been closed
+	ErrClosed = errors.New("modbus: connection closed")
+)
+
+const (
+	defaultPort      = "502"
+	mbapHeaderLength = 7
+	// serialFlushDelay is how long a serial framing waits before the request that follows an
+	// abandoned one, the late answer to the abandoned request is dropped if it arrives meanwhile
+	serialFlushDelay = 100 * time.Millisecond
+)
+
+type response struct {
+	pdu []byte
+	err error
+}
+
+// Admit is called before a request is sent, e.g. to bound the requests in flight on a device.
+// The request is sent once it returns and calls the returned function when it is answered or
+// abandoned. An error fails the request.
+type Admit func(ctx context.Context) (func(), error)
+
+type pendingRequest struct {
+	unitID   uint8
+	function byte
+	ch       chan response
+}
+
+// Client is a Modbus client for a single TCP connection to a device or gateway. Every
+// request carries its own unit identifier. With TCP framing requests are multiplexed
+// over the connection by their MBAP transaction identifier, so a unit behind a gateway
+// that stops answering doesn't hold up requests to the other units. The serial framings
+// tunnelled over TCP have no transaction identifier and send one request at a time.
+type Client struct {
+	conn   net.Conn
+	framer framer
+	// serial holds the single in-flight slot of framings that aren't multiplexed
+	serial chan struct{}
+	// admit is set by SetAdmit
+	admit Admit
+
+	mtx           sync.Mutex
+	transactionID uint16
+	pending       map[uint16]*pendingRequest
+	// abandoned is set when a request of a serial framing was abandoned before its answer
+	abandoned bool
+	done      chan struct{}
+	err       error
+}
+
+// Dial connects to the Modbus server at address using the given framing. The port defaults to 502.
+func Dial(ctx context.Context, address string, framing Framing) (*Client, error) {
+	f, err := newFramer(framing)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		framer:  f,
+		serial:  make(chan struct{}, 1),
+		pending: make(map[uint16]*pendingRequest),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// SetAdmit sets the function admitting every request, it must be called before the first request
+func (c *Client) SetAdmit(admit Admit) {
+	c.admit = admit
+}
+
+// Close closes the connection and fails all requests still waiting for a response
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
+
+// Done returns a channel that is closed once the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection was lost, or nil while it is still usable
+func (c *Client) Err() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.err
+}
+
+func (c *Client) fail(err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	_ = c.conn.Close()
+	for id, req := range c.pending {
+		req.ch <- response{err: err}
+		delete(c.pending, id)
+	}
+}
+
+// Send sends the request PDU to the unit and waits for the response PDU. Exception
+// responses are returned as PDUs, see checkResponse.
+func (c *Client) Send(ctx context.Context, unitID uint8, pdu []byte) ([]byte, error) {
+	if c.admit != nil {
+		release, err := c.admit(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	if !c.framer.multiplexed() {
+		select {
+		case c.serial <- struct{}{}:
+			defer func() { <-c.serial }()
+		case <-c.done:
+			return nil, c.Err()
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		}
+		if err := c.flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	req := &pendingRequest{unitID: unitID, function: pdu[0], ch: make(chan response, 1)}
+
+	c.mtx.Lock()
+	if c.err != nil {
+		c.mtx.Unlock()
+		return nil, c.err
+	}
+	c.transactionID++
+	id := c.transactionID
+	c.pending[id] = req
+	_, err := c.conn.Write(c.framer.encode(adu{transactionID: id, unitID: unitID, pdu: pdu}))
+	c.mtx.Unlock()
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+
+	select {
+	case resp := <-req.ch:
+		return resp.pdu, resp.err
+	case <-ctx.Done():
+		c.mtx.Lock()
+		delete(c.pending, id)
+		c.abandoned = true
+		c.mtx.Unlock()
+		return nil, contextError(ctx)
+	}
+}
+
+// flush gives the late answer to an abandoned request of a serial framing time to arrive
+// before the next request is sent, the read loop drops it as no request is pending. It is
+// called while holding the serial slot.
+func (c *Client) flush(ctx context.Context) error {
+	c.mtx.Lock()
+	abandoned := c.abandoned
+	c.abandoned = false
+	c.mtx.Unlock()
+	if !abandoned {
+		return nil
+	}
+	timer := time.NewTimer(serialFlushDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-ctx.Done():
+		c.mtx.Lock()
+		c.abandoned = true
+		c.mtx.Unlock()
+		return contextError(ctx)
+	}
+}
+
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+func (c *Client) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		a, err := c.framer.decode(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		c.mtx.Lock()
+		id, req := c.match(a)
+		if req != nil {
+			delete(c.pending, id)
+		}
+		c.mtx.Unlock()
+		// responses to requests that already timed out are dropped
+		if req != nil {
+			req.ch <- response{pdu: a.pdu}
+		}
+	}
+}
+
+// match finds the pending request a response belongs to. Without a transaction identifier
+// the single in-flight request is used if the response comes from the same unit and function.
+// A late answer to an abandoned request of another unit or function is dropped, one of the
+// same unit and function can't be told apart from the answer to the next request: flush
+// makes it unlikely, and readResponseData rejects it if the request read another count.
+func (c *Client) match(a adu) (uint16, *pendingRequest) {
+	if c.framer.multiplexed() {
+		return a.transactionID, c.pending[a.transactionID]
+	}
+	for id, req := range c.pending {
+		if len(a.pdu) > 0 && req.unitID == a.unitID && req.function == a.pdu[0]&^exceptionFlag {
+			return id, req
+		}
+	}
+	return 0, nil
+}
+
+// ReadFields reads the fields from a unit and returns the decoded values, see Decode.
+// Fields of the same area that are adjacent or overlapping are coalesced into a single
+// request. The returned values and errors are indexed like fields. Once a request to the
+// unit times out, the remaining requests to it are skipped and fail with ErrTimeout too.
+func (c *Client) ReadFields(ctx context.Context, unitID uint8, fields []Field) ([]interface{}, []error) {
+	values := make([]interface{}, len(fields))
+	errs := make([]error, len(fields))
+
+	var timedOut bool
+	for _, b := range coalesce(fields) {
+		var data []byte
+		err := ErrTimeout
+		if !timedOut {
+			data, err = c.readBlock(ctx, unitID, b)
+			timedOut = errors.Is(err, ErrTimeout)
+		}
+		for _, i := range b.fields {
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			values[i], errs[i] = Decode(fields[i], b.extract(fields[i], data))
+		}
+	}
+	return values, errs
+}
+
+func (c *Client) readBlock(ctx context.Context, unitID uint8, b block) ([]byte, error) {
+	request, err := readRequest(b.area, b.start, b.count)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.Send(ctx, unitID, request)
+	if err != nil {
+		return nil, err
+	}
+	return readResponseData(request, response)
+}
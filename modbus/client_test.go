@@ -0,0 +1,137 @@
+package modbus
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveRTU accepts one connection and answers every read request with the frame answer
+// returns after the delay it returns, nil to send nothing
+func serveRTU(t *testing.T, answer func(n int, request []byte) ([]byte, time.Duration)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for n := 0; ; n++ {
+			request := make([]byte, 8)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+			frame, delay := answer(n, request)
+			if frame == nil {
+				continue
+			}
+			go func() {
+				time.Sleep(delay)
+				_, _ = conn.Write(rtuFramer{}.encode(adu{unitID: request[0], pdu: frame}))
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClientDropsLateSerialAnswer(t *testing.T) {
+	address := serveRTU(t, func(n int, request []byte) ([]byte, time.Duration) {
+		if n == 0 {
+			// answers after the request timed out and the next one was sent
+			return []byte{FuncReadHoldingRegisters, 0x02, 0x00, 0x01}, 150 * time.Millisecond
+		}
+		return []byte{FuncReadHoldingRegisters, 0x02, 0x00, 0x02}, 100 * time.Millisecond
+	})
+	c, err := Dial(context.Background(), address, RTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fields := []Field{{Area: HoldingRegister, Address: 0, Quantity: 1, DataType: INT}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, errs := c.ReadFields(ctx, 1, fields)
+	cancel()
+	if errs[0] != ErrTimeout {
+		t.Fatalf("first read = %v, want ErrTimeout", errs[0])
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	values, errs := c.ReadFields(ctx, 1, fields)
+	if errs[0] != nil || values[0] != int64(2) {
+		t.Errorf("second read = %v, %v, want the answer to the second request 2", values[0], errs[0])
+	}
+}
+
+func TestClientSerialRequestsOneAtATime(t *testing.T) {
+	inFlight := make(chan struct{}, 2)
+	address := serveRTU(t, func(n int, request []byte) ([]byte, time.Duration) {
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			t.Errorf("request %d was sent before the previous one was answered", n)
+		}
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			<-inFlight
+		}()
+		return []byte{FuncReadHoldingRegisters, 0x02, 0x00, request[0]}, 10 * time.Millisecond
+	})
+	c, err := Dial(context.Background(), address, RTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fields := []Field{{Area: HoldingRegister, Address: 0, Quantity: 1, DataType: INT}}
+	results := make(chan error, 3)
+	for unit := uint8(1); unit <= 3; unit++ {
+		go func(unit uint8) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			values, errs := c.ReadFields(ctx, unit, fields)
+			if errs[0] == nil && values[0] != int64(unit) {
+				t.Errorf("unit %d read %v", unit, values[0])
+			}
+			results <- errs[0]
+		}(unit)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReadResponseData(t *testing.T) {
+	registers := []byte{FuncReadHoldingRegisters, 0x00, 0x00, 0x00, 0x02}
+	coils := []byte{FuncReadCoils, 0x00, 0x00, 0x00, 0x0A}
+	tests := []struct {
+		name     string
+		request  []byte
+		response []byte
+		err      string
+	}{
+		{"registers", registers, []byte{0x03, 0x04, 0x00, 0x01, 0x00, 0x02}, ""},
+		{"coils", coils, []byte{0x01, 0x02, 0xFF, 0x03}, ""},
+		{"exception", registers, []byte{0x83, 0x02}, "illegal data address"},
+		{"other function", registers, []byte{0x04, 0x04, 0x00, 0x01, 0x00, 0x02}, "unexpected function"},
+		{"malformed", registers, []byte{0x03, 0x04, 0x00, 0x01}, "malformed"},
+		{"answer to another count", registers, []byte{0x03, 0x02, 0x00, 0x01}, "unexpected byte count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readResponseData(tt.request, tt.response)
+			if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("readResponseData(% x, % x) = %v, want %q", tt.request, tt.response, err, tt.err)
+			}
+		})
+	}
+}
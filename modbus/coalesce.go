@@ -0,0 +1,83 @@
+package modbus
+
+import (
+	"sort"
+)
+
+// block is a contiguous range of bits or registers read with a single request
+type block struct {
+	area   Area
+	start  uint16
+	count  uint16
+	fields []int
+}
+
+// coalesce groups fields into as few blocks as possible. Only adjacent or overlapping
+// fields are merged; gaps are never bridged as they may contain unmapped addresses.
+func coalesce(fields []Field) []block {
+	order := make([]int, len(fields))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		fa, fb := fields[order[a]], fields[order[b]]
+		if fa.Area != fb.Area {
+			return fa.Area < fb.Area
+		}
+		return fa.Address < fb.Address
+	})
+
+	blocks := make([]block, 0, len(fields))
+	for _, i := range order {
+		f := fields[i]
+		end := int(f.Address) + int(f.Count())
+		if n := len(blocks); n > 0 {
+			last := &blocks[n-1]
+			lastEnd := int(last.start) + int(last.count)
+			if last.area == f.Area && int(f.Address) <= lastEnd && maxInt(end, lastEnd)-int(last.start) <= maxCount(f.Area) {
+				last.count = uint16(maxInt(end, lastEnd) - int(last.start))
+				last.fields = append(last.fields, i)
+				continue
+			}
+		}
+		blocks = append(blocks, block{area: f.Area, start: f.Address, count: f.Count(), fields: []int{i}})
+	}
+	return blocks
+}
+
+// extract returns the part of the block's response data belonging to the field
+func (b block) extract(f Field, data []byte) []byte {
+	offset := int(f.Address - b.start)
+	if !b.area.isBit() {
+		from, to := offset*2, (offset+int(f.Count()))*2
+		if to > len(data) {
+			return nil
+		}
+		return data[from:to]
+	}
+	bits := make([]byte, (int(f.Count())+7)/8)
+	for i := 0; i < int(f.Count()); i++ {
+		bit := offset + i
+		if bit/8 >= len(data) {
+			return nil
+		}
+		if data[bit/8]&(1<<uint(bit%8)) != 0 {
+			bits[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return bits
+}
+
+func maxCount(area Area) int {
+	if area.isBit() {
+		return maxReadBits
+	}
+	return maxReadRegisters
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
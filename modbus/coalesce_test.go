@@ -0,0 +1,122 @@
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func mustParseFields(t *testing.T, queries ...string) []Field {
+	t.Helper()
+	fields := make([]Field, len(queries))
+	for i, query := range queries {
+		field, err := ParseField(query)
+		if err != nil {
+			t.Fatalf("ParseField(%q): %s", query, err)
+		}
+		fields[i] = field
+	}
+	return fields
+}
+
+func TestCoalesce(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   []block
+	}{
+		{
+			name:   "adjacent registers",
+			fields: []string{"holding-register:1", "holding-register:2:DINT", "holding-register:4"},
+			want:   []block{{area: HoldingRegister, start: 0, count: 4, fields: []int{0, 1, 2}}},
+		},
+		{
+			name:   "overlapping registers",
+			fields: []string{"holding-register:1:DINT", "holding-register:2"},
+			want:   []block{{area: HoldingRegister, start: 0, count: 2, fields: []int{0, 1}}},
+		},
+		{
+			name:   "gaps are not bridged",
+			fields: []string{"holding-register:1", "holding-register:3"},
+			want: []block{
+				{area: HoldingRegister, start: 0, count: 1, fields: []int{0}},
+				{area: HoldingRegister, start: 2, count: 1, fields: []int{1}},
+			},
+		},
+		{
+			name:   "sorted by area and address",
+			fields: []string{"holding-register:2", "coil:2", "holding-register:1", "coil:1", "input-register:1"},
+			want: []block{
+				{area: Coil, start: 0, count: 2, fields: []int{3, 1}},
+				{area: InputRegister, start: 0, count: 1, fields: []int{4}},
+				{area: HoldingRegister, start: 0, count: 2, fields: []int{2, 0}},
+			},
+		},
+		{
+			name:   "areas are not merged",
+			fields: []string{"holding-register:1", "input-register:2"},
+			want: []block{
+				{area: InputRegister, start: 1, count: 1, fields: []int{1}},
+				{area: HoldingRegister, start: 0, count: 1, fields: []int{0}},
+			},
+		},
+		{
+			name:   "register blocks up to the request limit",
+			fields: []string{"holding-register:1:INT[100]", "holding-register:101:INT[25]"},
+			want:   []block{{area: HoldingRegister, start: 0, count: 125, fields: []int{0, 1}}},
+		},
+		{
+			name:   "register blocks split past the request limit",
+			fields: []string{"holding-register:1:INT[100]", "holding-register:101:INT[26]"},
+			want: []block{
+				{area: HoldingRegister, start: 0, count: 100, fields: []int{0}},
+				{area: HoldingRegister, start: 100, count: 26, fields: []int{1}},
+			},
+		},
+		{
+			name:   "bit blocks split past the request limit",
+			fields: []string{"coil:1[1999]", "coil:2000[2]"},
+			want: []block{
+				{area: Coil, start: 0, count: 1999, fields: []int{0}},
+				{area: Coil, start: 1999, count: 2, fields: []int{1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coalesce(mustParseFields(t, tt.fields...))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coalesce(%v) = %+v, want %+v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockExtract(t *testing.T) {
+	registers := block{area: HoldingRegister, start: 10, count: 3}
+	data := []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x03}
+	fields := mustParseFields(t, "holding-register:12:DINT", "holding-register:13", "holding-register:13:DINT")
+	if got := registers.extract(fields[0], data); !bytes.Equal(got, []byte{0x00, 0x02, 0x00, 0x03}) {
+		t.Errorf("extract DINT at 12 = %x", got)
+	}
+	if got := registers.extract(fields[1], data); !bytes.Equal(got, []byte{0x00, 0x03}) {
+		t.Errorf("extract INT at 13 = %x", got)
+	}
+	if got := registers.extract(fields[2], data); got != nil {
+		t.Errorf("extract past the block = %x, want nil", got)
+	}
+
+	// coils 4 to 13, the response packs them from the lowest bit of the first byte
+	bits := block{area: Coil, start: 3, count: 10}
+	data = []byte{0b10100101, 0b00000010}
+	fields = mustParseFields(t, "coil:4[3]", "coil:11[3]", "coil:13[2]")
+	if got := bits.extract(fields[0], data); !bytes.Equal(got, []byte{0b101}) {
+		t.Errorf("extract coils 4-6 = %08b", got)
+	}
+	if got := bits.extract(fields[1], data); !bytes.Equal(got, []byte{0b101}) {
+		t.Errorf("extract coils 11-13 = %08b", got)
+	}
+	if got := bits.extract(fields[2], data); !bytes.Equal(got, []byte{0b01}) {
+		t.Errorf("extract coils 13-14 = %08b", got)
+	}
+}
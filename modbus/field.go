@@ -0,0 +1,155 @@
+package modbus
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Area identifies the Modbus data model table a field lives in
+type Area uint8
+
+const (
+	// Coil is the read/write single bit table
+	Coil Area = iota
+	// DiscreteInput is the read-only single bit table
+	DiscreteInput
+	// InputRegister is the read-only 16 bit register table
+	InputRegister
+	// HoldingRegister is the read/write 16 bit register table
+	HoldingRegister
+	// ExtendedRegister is the file record table
+	ExtendedRegister
+)
+
+func (a Area) String() string {
+	switch a {
+	case Coil:
+		return "coil"
+	case DiscreteInput:
+		return "discrete-input"
+	case InputRegister:
+		return "input-register"
+	case HoldingRegister:
+		return "holding-register"
+	case ExtendedRegister:
+		return "extended-register"
+	}
+	return "unknown"
+}
+
+// isBit reports whether the area is addressed in bits rather than registers
+func (a Area) isBit() bool {
+	return a == Coil || a == DiscreteInput
+}
+
+// unit names what the area is addressed in
+func (a Area) unit() string {
+	if a.isBit() {
+		return "bits"
+	}
+	return "registers"
+}
+
+// addressOffset is subtracted from the user facing 1-based address to get the protocol address
+const addressOffset = 1
+
+// Field is a parsed Modbus address, e.g. `holding-register:123:INT[2]`
+type Field struct {
+	Area     Area
+	Address  uint16
+	Quantity uint16
+	DataType DataType
+}
+
+var (
+	generalAddressPattern    = `(?P<address>\d+)(:(?P<datatype>[a-zA-Z_]+))?(\[(?P<quantity>\d+)])?$`
+	fixedDigitAddressPattern = `(?P<address>\d{4,5})?(:(?P<datatype>[a-zA-Z_]+))?(\[(?P<quantity>\d+)])?$`
+	fieldPatterns            = []struct {
+		area    Area
+		pattern *regexp.Regexp
+	}{
+		{Coil, regexp.MustCompile("^coil:" + generalAddressPattern)},
+		{Coil, regexp.MustCompile("^0[xX]?" + fixedDigitAddressPattern)},
+		{DiscreteInput, regexp.MustCompile("^discrete-input:" + generalAddressPattern)},
+		{DiscreteInput, regexp.MustCompile("^1[xX]?" + fixedDigitAddressPattern)},
+		{InputRegister, regexp.MustCompile("^input-register:" + generalAddressPattern)},
+		{InputRegister, regexp.MustCompile("^3[xX]?" + fixedDigitAddressPattern)},
+		{HoldingRegister, regexp.MustCompile("^holding-register:" + generalAddressPattern)},
+		{HoldingRegister, regexp.MustCompile("^4[xX]?" + fixedDigitAddressPattern)},
+		{ExtendedRegister, regexp.MustCompile("^extended-register:" + generalAddressPattern)},
+		{ExtendedRegister, regexp.MustCompile("^6[xX]?" + fixedDigitAddressPattern)},
+	}
+)
+
+// ParseField parses an address using the same syntax as the plc4x Modbus driver
+func ParseField(query string) (Field, error) {
+	for _, p := range fieldPatterns {
+		match := p.pattern.FindStringSubmatch(query)
+		if match == nil {
+			continue
+		}
+		groups := make(map[string]string)
+		for i, name := range p.pattern.SubexpNames() {
+			if name != "" {
+				groups[name] = match[i]
+			}
+		}
+		return newField(p.area, groups["address"], groups["quantity"], groups["datatype"])
+	}
+	return Field{}, fmt.Errorf("invalid address format for address '%s'", query)
+}
+
+func newField(area Area, address, quantity, datatype string) (Field, error) {
+	addr, err := strconv.Atoi(address)
+	if err != nil || addr < addressOffset || addr > 0xFFFF+addressOffset {
+		return Field{}, fmt.Errorf("couldn't parse address string '%s' into a valid address", address)
+	}
+	qty := 1
+	if quantity != "" {
+		qty, err = strconv.Atoi(quantity)
+		if err != nil || qty < 1 || qty > 0xFFFF {
+			return Field{}, fmt.Errorf("couldn't parse quantity string '%s' into a valid quantity", quantity)
+		}
+	}
+	dataType := BOOL
+	if !area.isBit() {
+		dataType = INT
+	}
+	if datatype != "" {
+		dataType, err = DataTypeByName(datatype)
+		if err != nil {
+			return Field{}, err
+		}
+	}
+	// a field is read with a single request, it can't span more than one request can read
+	span := qty
+	if !area.isBit() {
+		span = (qty*dataType.Size() + 1) / 2
+	}
+	if span > maxCount(area) {
+		return Field{}, fmt.Errorf("quantity %d of %s spans %d %s, at most %d can be read with one request", qty, dataType, span, area.unit(), maxCount(area))
+	}
+	if addr-addressOffset+span > 0x10000 {
+		return Field{}, fmt.Errorf("address %d with quantity %d of %s runs past the last address", addr, qty, dataType)
+	}
+	return Field{
+		Area:     area,
+		Address:  uint16(addr - addressOffset),
+		Quantity: uint16(qty),
+		DataType: dataType,
+	}, nil
+}
+
+// Count returns the number of bits (for coils and discrete inputs) or
+// registers (for input and holding registers) the field spans
+func (f Field) Count() uint16 {
+	if f.Area.isBit() {
+		return f.Quantity
+	}
+	return uint16((int(f.Quantity)*f.DataType.Size() + 1) / 2)
+}
+
+func (f Field) String() string {
+	return fmt.Sprintf("%s:%d:%s[%d]", f.Area, int(f.Address)+addressOffset, f.DataType, f.Quantity)
+}
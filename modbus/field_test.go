@@ -0,0 +1,73 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Field
+		count uint16
+	}{
+		{"holding-register:1", Field{Area: HoldingRegister, Address: 0, Quantity: 1, DataType: INT}, 1},
+		{"holding-register:123:REAL[2]", Field{Area: HoldingRegister, Address: 122, Quantity: 2, DataType: REAL}, 4},
+		{"input-register:10:LREAL", Field{Area: InputRegister, Address: 9, Quantity: 1, DataType: LREAL}, 4},
+		{"holding-register:1:BYTE[3]", Field{Area: HoldingRegister, Address: 0, Quantity: 3, DataType: BYTE}, 2},
+		{"holding-register:1:dint", Field{Area: HoldingRegister, Address: 0, Quantity: 1, DataType: DINT}, 2},
+		{"coil:5", Field{Area: Coil, Address: 4, Quantity: 1, DataType: BOOL}, 1},
+		{"discrete-input:1[16]", Field{Area: DiscreteInput, Address: 0, Quantity: 16, DataType: BOOL}, 16},
+		{"400001", Field{Area: HoldingRegister, Address: 0, Quantity: 1, DataType: INT}, 1},
+		{"4x00010:UINT", Field{Area: HoldingRegister, Address: 9, Quantity: 1, DataType: UINT}, 1},
+		{"30002", Field{Area: InputRegister, Address: 1, Quantity: 1, DataType: INT}, 1},
+		{"000001", Field{Area: Coil, Address: 0, Quantity: 1, DataType: BOOL}, 1},
+		{"holding-register:65536", Field{Area: HoldingRegister, Address: 65535, Quantity: 1, DataType: INT}, 1},
+		// the largest fields one request can read
+		{"holding-register:1:INT[125]", Field{Area: HoldingRegister, Address: 0, Quantity: 125, DataType: INT}, 125},
+		{"holding-register:1:LREAL[31]", Field{Area: HoldingRegister, Address: 0, Quantity: 31, DataType: LREAL}, 124},
+		{"coil:1[2000]", Field{Area: Coil, Address: 0, Quantity: 2000, DataType: BOOL}, 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := ParseField(tt.query)
+			if err != nil {
+				t.Fatalf("ParseField(%q): %s", tt.query, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseField(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+			if got.Count() != tt.count {
+				t.Errorf("ParseField(%q).Count() = %d, want %d", tt.query, got.Count(), tt.count)
+			}
+		})
+	}
+}
+
+func TestParseFieldErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		err   string
+	}{
+		{"holding-register", "invalid address format"},
+		{"memory:1", "invalid address format"},
+		{"holding-register:0", "valid address"},
+		{"holding-register:65537", "valid address"},
+		{"holding-register:1[0]", "valid quantity"},
+		{"holding-register:1:FOO", "unsupported data type"},
+		{"holding-register:1:INT[126]", "at most 125"},
+		{"holding-register:1:LREAL[40000]", "at most 125"},
+		{"holding-register:1:LREAL[32]", "at most 125"},
+		{"coil:1[2001]", "at most 2000"},
+		{"holding-register:65536:DINT", "runs past the last address"},
+		{"coil:65000[1000]", "runs past the last address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseField(tt.query)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseField(%q) = %v, want an error containing %q", tt.query, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Framing selects how PDUs are wrapped into application data units on the wire
+type Framing uint8
+
+const (
+	// TCP is the MBAP framing of Modbus TCP
+	TCP Framing = iota
+	// RTU is the binary serial line framing with a CRC-16, tunnelled over TCP
+	RTU
+	// ASCII is the hex encoded serial line framing with an LRC, tunnelled over TCP
+	ASCII
+)
+
+func (f Framing) String() string {
+	switch f {
+	case TCP:
+		return "tcp"
+	case RTU:
+		return "rtu"
+	case ASCII:
+		return "ascii"
+	}
+	return "unknown"
+}
+
+// Multiplexed reports whether several requests can be in flight at once with the framing,
+// the serial framings have no transaction identifier and send one request at a time
+func (f Framing) Multiplexed() bool {
+	return f == TCP
+}
+
+// errFraming is returned when the byte stream can't be split into frames any more
+var errFraming = errors.New("modbus: framing error")
+
+// adu is a decoded application data unit
+type adu struct {
+	transactionID uint16
+	unitID        uint8
+	pdu           []byte
+}
+
+// framer encodes and decodes ADUs for one framing. Framings without a transaction
+// identifier can't match responses to requests, so only one request may be in flight.
+type framer interface {
+	encode(a adu) []byte
+	decode(r *bufio.Reader) (adu, error)
+	multiplexed() bool
+}
+
+func newFramer(f Framing) (framer, error) {
+	switch f {
+	case TCP:
+		return tcpFramer{}, nil
+	case RTU:
+		return rtuFramer{}, nil
+	case ASCII:
+		return asciiFramer{}, nil
+	}
+	return nil, fmt.Errorf("unsupported framing %d", f)
+}
+
+type tcpFramer struct{}
+
+func (tcpFramer) multiplexed() bool {
+	return true
+}
+
+func (tcpFramer) encode(a adu) []byte {
+	frame := make([]byte, mbapHeaderLength+len(a.pdu))
+	binary.BigEndian.PutUint16(frame[0:], a.transactionID)
+	binary.BigEndian.PutUint16(frame[4:], uint16(len(a.pdu)+1))
+	frame[6] = a.unitID
+	copy(frame[mbapHeaderLength:], a.pdu)
+	return frame
+}
+
+func (tcpFramer) decode(r *bufio.Reader) (adu, error) {
+	header := make([]byte, mbapHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return adu{}, err
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	if length < 2 || length > 254 {
+		return adu{}, fmt.Errorf("%w: invalid MBAP length %d", errFraming, length)
+	}
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return adu{}, err
+	}
+	return adu{
+		transactionID: binary.BigEndian.Uint16(header[0:]),
+		unitID:        header[6],
+		pdu:           pdu,
+	}, nil
+}
+
+type rtuFramer struct{}
+
+func (rtuFramer) multiplexed() bool {
+	return false
+}
+
+func (rtuFramer) encode(a adu) []byte {
+	frame := make([]byte, 0, len(a.pdu)+3)
+	frame = append(frame, a.unitID)
+	frame = append(frame, a.pdu...)
+	crc := crc16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// decode reads an RTU frame. RTU has no length field, so the length of the PDU is
+// derived from its function code and, for reads, from the byte count that follows it.
+func (rtuFramer) decode(r *bufio.Reader) (adu, error) {
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return adu{}, err
+	}
+	var rest int
+	function := head[1]
+	switch {
+	case function&exceptionFlag != 0:
+		rest = 0
+	case function >= FuncReadCoils && function <= FuncReadInputRegisters:
+		rest = int(head[2])
+	case function >= FuncWriteSingleCoil && function <= FuncWriteSingleRegister,
+		function == FuncWriteMultipleCoils, function == FuncWriteMultipleRegisters:
+		rest = 3
+	default:
+		return adu{}, fmt.Errorf("%w: can't determine RTU frame length for function 0x%02x", errFraming, function)
+	}
+	frame := make([]byte, len(head)+rest+2)
+	copy(frame, head)
+	if _, err := io.ReadFull(r, frame[len(head):]); err != nil {
+		return adu{}, err
+	}
+	n := len(frame) - 2
+	if crc := crc16(frame[:n]); frame[n] != byte(crc) || frame[n+1] != byte(crc>>8) {
+		return adu{}, fmt.Errorf("%w: RTU CRC mismatch", errFraming)
+	}
+	return adu{unitID: frame[0], pdu: frame[1:n]}, nil
+}
+
+type asciiFramer struct{}
+
+func (asciiFramer) multiplexed() bool {
+	return false
+}
+
+func (asciiFramer) encode(a adu) []byte {
+	raw := make([]byte, 0, len(a.pdu)+2)
+	raw = append(raw, a.unitID)
+	raw = append(raw, a.pdu...)
+	raw = append(raw, lrc(raw))
+	return []byte(":" + strings.ToUpper(hex.EncodeToString(raw)) + "\r\n")
+}
+
+func (asciiFramer) decode(r *bufio.Reader) (adu, error) {
+	if _, err := r.ReadString(':'); err != nil {
+		return adu{}, err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return adu{}, err
+	}
+	raw, err := hex.DecodeString(strings.TrimRight(line, "\r\n"))
+	if err != nil || len(raw) < 3 {
+		return adu{}, fmt.Errorf("%w: malformed ASCII frame", errFraming)
+	}
+	n := len(raw) - 1
+	if lrc(raw[:n]) != raw[n] {
+		return adu{}, fmt.Errorf("%w: ASCII LRC mismatch", errFraming)
+	}
+	return adu{unitID: raw[0], pdu: raw[1:n]}, nil
+}
+
+// crc16 computes the Modbus RTU CRC; it is appended to the frame low byte first
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// lrc computes the Modbus ASCII longitudinal redundancy check
+func lrc(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return -sum
+}
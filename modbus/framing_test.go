@@ -0,0 +1,134 @@
+package modbus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCRC16(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want uint16
+	}{
+		// the check value of CRC-16/MODBUS
+		{[]byte("123456789"), 0x4B37},
+		{[]byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}, 0x8776},
+		{[]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}, 0xCDC5},
+		{nil, 0xFFFF},
+	}
+	for _, tt := range tests {
+		if got := crc16(tt.data); got != tt.want {
+			t.Errorf("crc16(% x) = %#04x, want %#04x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestLRC(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want byte
+	}{
+		{[]byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}, 0x7E},
+		{[]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01}, 0xFB},
+		{nil, 0x00},
+	}
+	for _, tt := range tests {
+		if got := lrc(tt.data); got != tt.want {
+			t.Errorf("lrc(% x) = %#02x, want %#02x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestFramingEncode(t *testing.T) {
+	request := adu{transactionID: 0x0102, unitID: 0x11, pdu: []byte{0x03, 0x00, 0x6B, 0x00, 0x03}}
+	tests := []struct {
+		framing Framing
+		want    []byte
+	}{
+		{TCP, []byte{0x01, 0x02, 0x00, 0x00, 0x00, 0x06, 0x11, 0x03, 0x00, 0x6B, 0x00, 0x03}},
+		{RTU, []byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03, 0x76, 0x87}},
+		{ASCII, []byte(":1103006B00037E\r\n")},
+	}
+	for _, tt := range tests {
+		f, err := newFramer(tt.framing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.encode(request); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s encode = % x, want % x", tt.framing, got, tt.want)
+		}
+	}
+}
+
+func TestFramingDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing Framing
+		frame   []byte
+		want    adu
+	}{
+		{"tcp read", TCP, []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x2A},
+			adu{transactionID: 7, unitID: 1, pdu: []byte{0x03, 0x02, 0x00, 0x2A}}},
+		{"rtu read", RTU, []byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 0x9B},
+			adu{unitID: 1, pdu: []byte{0x03, 0x02, 0x00, 0x2A}}},
+		{"rtu exception", RTU, []byte{0x01, 0x83, 0x02, 0xC0, 0xF1},
+			adu{unitID: 1, pdu: []byte{0x83, 0x02}}},
+		{"rtu write", RTU, []byte{0x11, 0x06, 0x00, 0x01, 0x00, 0x03, 0x9A, 0x9B},
+			adu{unitID: 0x11, pdu: []byte{0x06, 0x00, 0x01, 0x00, 0x03}}},
+		{"ascii read", ASCII, []byte(":010302002AD0\r\n"),
+			adu{unitID: 1, pdu: []byte{0x03, 0x02, 0x00, 0x2A}}},
+		{"ascii skips noise before the frame", ASCII, []byte("\x00\r\n:010302002AD0\r\n"),
+			adu{unitID: 1, pdu: []byte{0x03, 0x02, 0x00, 0x2A}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := newFramer(tt.framing)
+			got, err := f.decode(bufio.NewReader(bytes.NewReader(tt.frame)))
+			if err != nil {
+				t.Fatalf("decode(% x): %s", tt.frame, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode(% x) = %+v, want %+v", tt.frame, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFramingDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		framing Framing
+		frame   []byte
+	}{
+		{"tcp length", TCP, []byte{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01}},
+		{"rtu crc", RTU, []byte{0x01, 0x03, 0x02, 0x00, 0x2A, 0x39, 0x9C}},
+		{"rtu function", RTU, []byte{0x01, 0x2B, 0x0E, 0x00, 0x00}},
+		{"ascii lrc", ASCII, []byte(":010302002AD1\r\n")},
+		{"ascii hex", ASCII, []byte(":01030G002AD0\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, _ := newFramer(tt.framing)
+			if _, err := f.decode(bufio.NewReader(bytes.NewReader(tt.frame))); !errors.Is(err, errFraming) {
+				t.Errorf("decode(% x) = %v, want a framing error", tt.frame, err)
+			}
+		})
+	}
+}
+
+func TestFramingRoundTrip(t *testing.T) {
+	for _, framing := range []Framing{TCP, RTU, ASCII} {
+		f, _ := newFramer(framing)
+		want := adu{unitID: 9, pdu: []byte{FuncReadHoldingRegisters, 0x04, 0x00, 0x01, 0xFF, 0xFF}}
+		if framing == TCP {
+			want.transactionID = 0xBEEF
+		}
+		got, err := f.decode(bufio.NewReader(bytes.NewReader(f.encode(want))))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s decode(encode(%+v)) = %+v, %v", framing, want, got, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Function codes of the public Modbus function code range used by the connector
+const (
+	FuncReadCoils              byte = 0x01
+	FuncReadDiscreteInputs     byte = 0x02
+	FuncReadHoldingRegisters   byte = 0x03
+	FuncReadInputRegisters     byte = 0x04
+	FuncWriteSingleCoil        byte = 0x05
+	FuncWriteSingleRegister    byte = 0x06
+	FuncWriteMultipleCoils     byte = 0x0F
+	FuncWriteMultipleRegisters byte = 0x10
+
+	exceptionFlag byte = 0x80
+)
+
+// Protocol limits for a single read request
+const (
+	maxReadBits      = 2000
+	maxReadRegisters = 125
+)
+
+// ExceptionCode is the code returned by a server in an exception response
+type ExceptionCode byte
+
+// Exception codes defined by the Modbus application protocol specification
+const (
+	IllegalFunction                    ExceptionCode = 0x01
+	IllegalDataAddress                 ExceptionCode = 0x02
+	IllegalDataValue                   ExceptionCode = 0x03
+	ServerDeviceFailure                ExceptionCode = 0x04
+	Acknowledge                        ExceptionCode = 0x05
+	ServerDeviceBusy                   ExceptionCode = 0x06
+	MemoryParityError                  ExceptionCode = 0x08
+	GatewayPathUnavailable             ExceptionCode = 0x0A
+	GatewayTargetDeviceFailedToRespond ExceptionCode = 0x0B
+)
+
+func (c ExceptionCode) String() string {
+	switch c {
+	case IllegalFunction:
+		return "illegal function"
+	case IllegalDataAddress:
+		return "illegal data address"
+	case IllegalDataValue:
+		return "illegal data value"
+	case ServerDeviceFailure:
+		return "server device failure"
+	case Acknowledge:
+		return "acknowledge"
+	case ServerDeviceBusy:
+		return "server device busy"
+	case MemoryParityError:
+		return "memory parity error"
+	case GatewayPathUnavailable:
+		return "gateway path unavailable"
+	case GatewayTargetDeviceFailedToRespond:
+		return "gateway target device failed to respond"
+	}
+	return "unknown exception"
+}
+
+// Exception is the error returned when a server answers with an exception response
+type Exception struct {
+	Function byte
+	Code     ExceptionCode
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("modbus exception 0x%02x (%s) for function 0x%02x", byte(e.Code), e.Code, e.Function)
+}
+
+// readFunctions maps the areas that can be read to their read function code
+var readFunctions = map[Area]byte{
+	Coil:            FuncReadCoils,
+	DiscreteInput:   FuncReadDiscreteInputs,
+	HoldingRegister: FuncReadHoldingRegisters,
+	InputRegister:   FuncReadInputRegisters,
+}
+
+// Readable reports whether a client can read the area
+func (a Area) Readable() bool {
+	_, ok := readFunctions[a]
+	return ok
+}
+
+// readRequest assembles the PDU for reading count bits or registers of an area starting at address
+func readRequest(area Area, address, count uint16) ([]byte, error) {
+	function, ok := readFunctions[area]
+	if !ok {
+		return nil, fmt.Errorf("reading %s is not supported", area)
+	}
+	pdu := make([]byte, 5)
+	pdu[0] = function
+	binary.BigEndian.PutUint16(pdu[1:], address)
+	binary.BigEndian.PutUint16(pdu[3:], count)
+	return pdu, nil
+}
+
+// parseReadRequest is the inverse of readRequest. It returns the exception code to answer a
+// PDU that isn't a valid read request with.
+func parseReadRequest(pdu []byte) (area Area, address, count uint16, code ExceptionCode) {
+	for a, function := range readFunctions {
+		if pdu[0] != function {
+			continue
+		}
+		if len(pdu) != 5 {
+			return 0, 0, 0, IllegalDataValue
+		}
+		address, count = binary.BigEndian.Uint16(pdu[1:]), binary.BigEndian.Uint16(pdu[3:])
+		if count == 0 || int(count) > maxCount(a) {
+			return 0, 0, 0, IllegalDataValue
+		}
+		if int(address)+int(count) > 0x10000 {
+			return 0, 0, 0, IllegalDataAddress
+		}
+		return a, address, count, 0
+	}
+	return 0, 0, 0, IllegalFunction
+}
+
+// readResponse assembles the PDU answering a read request with data, see readResponseData
+func readResponse(function byte, data []byte) []byte {
+	return append([]byte{function, byte(len(data))}, data...)
+}
+
+// exceptionResponse assembles the PDU answering a request with an exception, see checkResponse
+func exceptionResponse(function byte, code ExceptionCode) []byte {
+	return []byte{function | exceptionFlag, byte(code)}
+}
+
+// readResponseData validates a read response PDU and returns its data bytes, which must be
+// as many as the request asked for
+func readResponseData(request, response []byte) ([]byte, error) {
+	if err := checkResponse(request, response); err != nil {
+		return nil, err
+	}
+	if len(response) < 2 || int(response[1]) != len(response)-2 {
+		return nil, fmt.Errorf("malformed response for function 0x%02x", request[0])
+	}
+	count := int(binary.BigEndian.Uint16(request[3:]))
+	expected := count * 2
+	if request[0] == FuncReadCoils || request[0] == FuncReadDiscreteInputs {
+		expected = (count + 7) / 8
+	}
+	if int(response[1]) != expected {
+		return nil, fmt.Errorf("unexpected byte count %d in response to function 0x%02x, expected %d", response[1], request[0], expected)
+	}
+	return response[2:], nil
+}
+
+// checkResponse returns an *Exception if the response is an exception response for the request
+func checkResponse(request, response []byte) error {
+	if len(response) == 0 {
+		return fmt.Errorf("empty response for function 0x%02x", request[0])
+	}
+	if response[0] == request[0]|exceptionFlag {
+		if len(response) < 2 {
+			return fmt.Errorf("malformed exception response for function 0x%02x", request[0])
+		}
+		return &Exception{Function: request[0], Code: ExceptionCode(response[1])}
+	}
+	if response[0] != request[0] {
+		return fmt.Errorf("unexpected function 0x%02x in response to function 0x%02x", response[0], request[0])
+	}
+	return nil
+}
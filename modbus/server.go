@@ -0,0 +1,218 @@
+package modbus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxAcceptDelay bounds the backoff of Serve after temporary errors accepting connections
+const maxAcceptDelay = 1 * time.Second
+
+// Server is a Modbus TCP server serving an image of coils, discrete inputs and registers.
+// Only addresses that have been mapped or set are served, reading any other address yields
+// an IllegalDataAddress exception. The image is owned by the server's user and read only
+// for clients, so write requests are answered with IllegalFunction. Requests and responses
+// use the ADU and PDU model of the client; the plc4go Modbus model is internal to plc4go
+// and can't be imported.
+type Server struct {
+	// unitIdentifier restricts the server to requests for one unit, 0 answers all of them
+	unitIdentifier uint8
+
+	mtx       sync.RWMutex
+	bits      map[Area]map[uint16]bool
+	registers map[Area]map[uint16]uint16
+
+	connMtx  sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// NewServer creates a server with an empty image. Requests for other unit identifiers
+// than unitIdentifier are ignored unless it is 0.
+func NewServer(unitIdentifier uint8) *Server {
+	return &Server{
+		unitIdentifier: unitIdentifier,
+		bits: map[Area]map[uint16]bool{
+			Coil:          make(map[uint16]bool),
+			DiscreteInput: make(map[uint16]bool),
+		},
+		registers: map[Area]map[uint16]uint16{
+			HoldingRegister: make(map[uint16]uint16),
+			InputRegister:   make(map[uint16]uint16),
+		},
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Map adds the addresses of a field to the image, at zero unless they are set already, so
+// that they are served before their first value is set
+func (s *Server) Map(field Field) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if bits, ok := s.bits[field.Area]; ok {
+		for i := 0; i < int(field.Count()); i++ {
+			if _, ok := bits[field.Address+uint16(i)]; !ok {
+				bits[field.Address+uint16(i)] = false
+			}
+		}
+		return nil
+	}
+	if registers, ok := s.registers[field.Area]; ok {
+		for i := 0; i < int(field.Count()); i++ {
+			if _, ok := registers[field.Address+uint16(i)]; !ok {
+				registers[field.Address+uint16(i)] = 0
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("serving %s is not supported", field.Area)
+}
+
+// Set stores the raw data of a field, as returned by Encode, in the image
+func (s *Server) Set(field Field, data []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if bits, ok := s.bits[field.Area]; ok {
+		for i := 0; i < int(field.Count()); i++ {
+			if i/8 >= len(data) {
+				return fmt.Errorf("short data for %s", field)
+			}
+			bits[field.Address+uint16(i)] = data[i/8]&(1<<uint(i%8)) != 0
+		}
+		return nil
+	}
+	if registers, ok := s.registers[field.Area]; ok {
+		if len(data) < int(field.Count())*2 {
+			return fmt.Errorf("short data for %s", field)
+		}
+		for i := 0; i < int(field.Count()); i++ {
+			registers[field.Address+uint16(i)] = binary.BigEndian.Uint16(data[i*2:])
+		}
+		return nil
+	}
+	return fmt.Errorf("serving %s is not supported", field.Area)
+}
+
+// Serve accepts connections on the listener until Close is called. Temporary errors, like
+// running out of file descriptors, are retried with a backoff.
+func (s *Server) Serve(l net.Listener) error {
+	s.connMtx.Lock()
+	if s.closed {
+		s.connMtx.Unlock()
+		return l.Close()
+	}
+	s.listener = l
+	s.connMtx.Unlock()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.connMtx.Lock()
+			closed := s.closed
+			s.connMtx.Unlock()
+			if closed {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay *= 2; delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		s.connMtx.Lock()
+		s.conns[conn] = struct{}{}
+		s.connMtx.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections and closes all open ones
+func (s *Server) Close() error {
+	s.connMtx.Lock()
+	defer s.connMtx.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.connMtx.Lock()
+		delete(s.conns, conn)
+		s.connMtx.Unlock()
+		_ = conn.Close()
+	}()
+
+	var f tcpFramer
+	r := bufio.NewReader(conn)
+	for {
+		req, err := f.decode(r)
+		if err != nil {
+			return
+		}
+		if s.unitIdentifier != 0 && req.unitID != s.unitIdentifier {
+			continue
+		}
+		resp := adu{transactionID: req.transactionID, unitID: req.unitID, pdu: s.handle(req.pdu)}
+		if _, err := conn.Write(f.encode(resp)); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers a request PDU
+func (s *Server) handle(pdu []byte) []byte {
+	area, address, count, code := parseReadRequest(pdu)
+	if code == 0 {
+		var data []byte
+		if data, code = s.read(area, address, count); code == 0 {
+			return readResponse(pdu[0], data)
+		}
+	}
+	return exceptionResponse(pdu[0], code)
+}
+
+// read returns the data of a valid read request, see parseReadRequest
+func (s *Server) read(area Area, address, count uint16) ([]byte, ExceptionCode) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if area.isBit() {
+		data := make([]byte, (count+7)/8)
+		for i := uint16(0); i < count; i++ {
+			v, ok := s.bits[area][address+i]
+			if !ok {
+				return nil, IllegalDataAddress
+			}
+			if v {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+		return data, 0
+	}
+	data := make([]byte, count*2)
+	for i := uint16(0); i < count; i++ {
+		v, ok := s.registers[area][address+i]
+		if !ok {
+			return nil, IllegalDataAddress
+		}
+		binary.BigEndian.PutUint16(data[i*2:], v)
+	}
+	return data, 0
+}
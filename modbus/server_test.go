@@ -0,0 +1,154 @@
+package modbus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, s *Server) *Client {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { _ = s.Close() })
+	c, err := Dial(context.Background(), l.Addr().String(), TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(1)
+	fields := mustParseFields(t, "holding-register:10:DINT", "coil:3", "input-register:1", "holding-register:20")
+	for _, field := range fields {
+		if err := s.Map(field); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := startServer(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// mapped fields are served at zero before they are set
+	values, errs := c.ReadFields(ctx, 1, fields)
+	for i, want := range []interface{}{int64(0), false, int64(0), int64(0)} {
+		if errs[i] != nil || values[i] != want {
+			t.Errorf("read %s = %v, %v, want %v", fields[i], values[i], errs[i], want)
+		}
+	}
+
+	for i, value := range []interface{}{-70000, true, 7} {
+		data, err := Encode(fields[i], value)
+		if err == nil {
+			err = s.Set(fields[i], data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Map keeps the values that are set
+	if err := s.Map(fields[0]); err != nil {
+		t.Fatal(err)
+	}
+	values, errs = c.ReadFields(ctx, 1, fields)
+	for i, want := range []interface{}{int64(-70000), true, int64(7), int64(0)} {
+		if errs[i] != nil || values[i] != want {
+			t.Errorf("read %s = %v, %v, want %v", fields[i], values[i], errs[i], want)
+		}
+	}
+
+	tests := []struct {
+		request []byte
+		code    ExceptionCode
+	}{
+		{[]byte{FuncReadHoldingRegisters, 0x00, 0x0B, 0x00, 0x02}, IllegalDataAddress},
+		{[]byte{FuncReadHoldingRegisters, 0x00, 0x09, 0x00, 0x00}, IllegalDataValue},
+		{[]byte{FuncReadHoldingRegisters, 0x00, 0x09, 0x00, 0x7E}, IllegalDataValue},
+		{[]byte{FuncReadHoldingRegisters, 0xFF, 0xFF, 0x00, 0x02}, IllegalDataAddress},
+		{[]byte{FuncReadCoils, 0x00, 0x00, 0x00, 0x01}, IllegalDataAddress},
+		{[]byte{FuncWriteSingleRegister, 0x00, 0x09, 0x00, 0x01}, IllegalFunction},
+		{[]byte{FuncReadInputRegisters, 0x00}, IllegalDataValue},
+	}
+	for _, tt := range tests {
+		response, err := c.Send(ctx, 1, tt.request)
+		var exception *Exception
+		if err == nil {
+			err = checkResponse(tt.request, response)
+		}
+		if !errors.As(err, &exception) || exception.Code != tt.code {
+			t.Errorf("request % x = %v, want exception %s", tt.request, err, tt.code)
+		}
+	}
+}
+
+func TestServerIgnoresOtherUnits(t *testing.T) {
+	s := NewServer(1)
+	field := mustParseFields(t, "holding-register:1")[0]
+	if err := s.Map(field); err != nil {
+		t.Fatal(err)
+	}
+	c := startServer(t, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, errs := c.ReadFields(ctx, 2, []Field{field}); errs[0] != ErrTimeout {
+		t.Errorf("read from unit 2 = %v, want ErrTimeout", errs[0])
+	}
+}
+
+// temporaryError is a net.Error like the one of accepting with no file descriptors left
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails the first accepts with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServerRetriesTemporaryAcceptErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(0)
+	field := mustParseFields(t, "holding-register:1")[0]
+	if err := s.Map(field); err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(&flakyListener{Listener: l, failures: 3}) }()
+	defer s.Close()
+
+	c, err := Dial(context.Background(), l.Addr().String(), TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, errs := c.ReadFields(ctx, 1, []Field{field}); errs[0] != nil {
+		t.Errorf("read after temporary accept errors: %s", errs[0])
+	}
+	_ = s.Close()
+	if err := <-served; err != nil {
+		t.Errorf("Serve = %s after Close, want nil", err)
+	}
+}
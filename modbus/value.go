@@ -0,0 +1,287 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DataType is the IEC 61131 type used to interpret the raw bits and registers of a field
+type DataType uint8
+
+// Data types supported by the plc4x Modbus address syntax
+const (
+	BOOL DataType = iota + 1
+	BYTE
+	WORD
+	DWORD
+	LWORD
+	SINT
+	INT
+	DINT
+	LINT
+	USINT
+	UINT
+	UDINT
+	ULINT
+	REAL
+	LREAL
+	CHAR
+	WCHAR
+)
+
+var dataTypeNames = map[DataType]string{
+	BOOL:  "BOOL",
+	BYTE:  "BYTE",
+	WORD:  "WORD",
+	DWORD: "DWORD",
+	LWORD: "LWORD",
+	SINT:  "SINT",
+	INT:   "INT",
+	DINT:  "DINT",
+	LINT:  "LINT",
+	USINT: "USINT",
+	UINT:  "UINT",
+	UDINT: "UDINT",
+	ULINT: "ULINT",
+	REAL:  "REAL",
+	LREAL: "LREAL",
+	CHAR:  "CHAR",
+	WCHAR: "WCHAR",
+}
+
+// DataTypeByName looks up a data type by its IEC 61131 name
+func DataTypeByName(name string) (DataType, error) {
+	for dt, n := range dataTypeNames {
+		if n == strings.ToUpper(name) {
+			return dt, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported data type '%s'", name)
+}
+
+func (t DataType) String() string {
+	return dataTypeNames[t]
+}
+
+// Size returns the number of bytes a single value of the type occupies
+func (t DataType) Size() int {
+	switch t {
+	case BOOL, BYTE, SINT, USINT, CHAR:
+		return 1
+	case WORD, INT, UINT, WCHAR:
+		return 2
+	case DWORD, DINT, UDINT, REAL:
+		return 4
+	case LWORD, LINT, ULINT, LREAL:
+		return 8
+	}
+	return 0
+}
+
+// Decode interprets the raw response data of a field. Bit areas yield bool values, register
+// areas yield int64, uint64 or float64 values depending on the data type. A field with a
+// quantity greater than one yields a []interface{}.
+func Decode(field Field, data []byte) (interface{}, error) {
+	values := make([]interface{}, 0, field.Quantity)
+	if field.Area.isBit() {
+		if len(data)*8 < int(field.Quantity) {
+			return nil, fmt.Errorf("short response for %s: got %d bytes", field, len(data))
+		}
+		for i := 0; i < int(field.Quantity); i++ {
+			values = append(values, data[i/8]&(1<<uint(i%8)) != 0)
+		}
+	} else {
+		size := field.DataType.Size()
+		if size == 0 {
+			return nil, fmt.Errorf("unsupported data type for %s", field)
+		}
+		if len(data) < int(field.Quantity)*size {
+			return nil, fmt.Errorf("short response for %s: got %d bytes", field, len(data))
+		}
+		for i := 0; i < int(field.Quantity); i++ {
+			values = append(values, decodeValue(field.DataType, data[i*size:(i+1)*size]))
+		}
+	}
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	return values, nil
+}
+
+func decodeValue(t DataType, b []byte) interface{} {
+	switch t {
+	case BOOL:
+		return b[0]&0x01 != 0
+	case SINT:
+		return int64(int8(b[0]))
+	case INT:
+		return int64(int16(binary.BigEndian.Uint16(b)))
+	case DINT:
+		return int64(int32(binary.BigEndian.Uint32(b)))
+	case LINT:
+		return int64(binary.BigEndian.Uint64(b))
+	case BYTE, USINT:
+		return uint64(b[0])
+	case WORD, UINT:
+		return uint64(binary.BigEndian.Uint16(b))
+	case DWORD, UDINT:
+		return uint64(binary.BigEndian.Uint32(b))
+	case LWORD, ULINT:
+		return binary.BigEndian.Uint64(b)
+	case REAL:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case LREAL:
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	case CHAR:
+		return string(rune(b[0]))
+	case WCHAR:
+		return string(rune(binary.BigEndian.Uint16(b)))
+	}
+	return nil
+}
+
+// FormatValue renders a decoded value the same way the plc4x value types do in `GetString`
+func FormatValue(t DataType, value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, FormatValue(t, item))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		if t == REAL {
+			return strconv.FormatFloat(v, 'g', -1, 32)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Encode converts a value into the raw data of a field, the inverse of Decode. Numeric
+// values are accepted as any Go integer or float type and are converted to the data type
+// of the field, rounding floats for integer types; bool values are accepted for BOOL and
+// the bit areas. Only single values are supported.
+func Encode(field Field, value interface{}) ([]byte, error) {
+	if field.Area.isBit() || field.DataType == BOOL {
+		b, ok := value.(bool)
+		if !ok {
+			f, isNumber := toFloat(value)
+			if !isNumber {
+				return nil, fmt.Errorf("can't encode %T as %s", value, field)
+			}
+			b = f != 0
+		}
+		if field.Area.isBit() {
+			if b {
+				return []byte{1}, nil
+			}
+			return []byte{0}, nil
+		}
+		// like in Decode, a BOOL in a register is the lowest bit of its first (high) byte
+		if b {
+			return []byte{1, 0}, nil
+		}
+		return []byte{0, 0}, nil
+	}
+
+	f, ok := toFloat(value)
+	if !ok {
+		if b, isBool := value.(bool); isBool {
+			if b {
+				f = 1
+			}
+		} else {
+			return nil, fmt.Errorf("can't encode %T as %s", value, field)
+		}
+	}
+	size := field.DataType.Size()
+	if size == 0 {
+		return nil, fmt.Errorf("unsupported data type for %s", field)
+	}
+	buf := make([]byte, 8)
+	switch field.DataType {
+	case SINT, BYTE, USINT, CHAR:
+		if err := checkRange(f, field.DataType); err != nil {
+			return nil, err
+		}
+		// single byte types are padded to a full register, see Decode for the byte order
+		return []byte{byte(int64(math.Round(f))), 0}, nil
+	case INT, WORD, UINT, WCHAR:
+		if err := checkRange(f, field.DataType); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint16(buf, uint16(int64(math.Round(f))))
+	case DINT, DWORD, UDINT:
+		if err := checkRange(f, field.DataType); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(buf, uint32(int64(math.Round(f))))
+	case LINT:
+		binary.BigEndian.PutUint64(buf, uint64(int64(math.Round(f))))
+	case LWORD, ULINT:
+		if f < 0 {
+			return nil, fmt.Errorf("value %g out of range for %s", f, field.DataType)
+		}
+		binary.BigEndian.PutUint64(buf, uint64(math.Round(f)))
+	case REAL:
+		binary.BigEndian.PutUint32(buf, math.Float32bits(float32(f)))
+	case LREAL:
+		binary.BigEndian.PutUint64(buf, math.Float64bits(f))
+	}
+	return buf[:size], nil
+}
+
+// integerRanges holds the value range of the integer types narrower than 64 bit
+var integerRanges = map[DataType][2]float64{
+	SINT:  {math.MinInt8, math.MaxInt8},
+	BYTE:  {0, math.MaxUint8},
+	USINT: {0, math.MaxUint8},
+	CHAR:  {0, math.MaxUint8},
+	INT:   {math.MinInt16, math.MaxInt16},
+	WORD:  {0, math.MaxUint16},
+	UINT:  {0, math.MaxUint16},
+	WCHAR: {0, math.MaxUint16},
+	DINT:  {math.MinInt32, math.MaxInt32},
+	DWORD: {0, math.MaxUint32},
+	UDINT: {0, math.MaxUint32},
+}
+
+func checkRange(f float64, t DataType) error {
+	r := integerRanges[t]
+	if v := math.Round(f); v < r[0] || v > r[1] {
+		return fmt.Errorf("value %g out of range for %s", f, t)
+	}
+	return nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	}
+	return 0, false
+}
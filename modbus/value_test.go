@@ -0,0 +1,98 @@
+package modbus
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		field string
+		data  []byte
+		want  interface{}
+	}{
+		// registers are big endian, multi-register values have the high word first
+		{"holding-register:1:INT", []byte{0xFF, 0xFE}, int64(-2)},
+		{"holding-register:1:UINT", []byte{0xFF, 0xFE}, uint64(0xFFFE)},
+		{"holding-register:1:WORD", []byte{0x12, 0x34}, uint64(0x1234)},
+		{"holding-register:1:DINT", []byte{0xFF, 0xFF, 0xFF, 0xFE}, int64(-2)},
+		{"holding-register:1:UDINT", []byte{0x00, 0x01, 0x00, 0x02}, uint64(0x00010002)},
+		{"holding-register:1:LINT", []byte{0x80, 0, 0, 0, 0, 0, 0, 0}, int64(math.MinInt64)},
+		{"holding-register:1:ULINT", []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}, uint64(0x0102030405060708)},
+		{"holding-register:1:REAL", []byte{0x41, 0x28, 0x00, 0x00}, float64(10.5)},
+		{"holding-register:1:LREAL", []byte{0x40, 0x09, 0x21, 0xFB, 0x54, 0x44, 0x2D, 0x18}, math.Pi},
+		{"holding-register:1:SINT", []byte{0xFF, 0x00}, int64(-1)},
+		{"holding-register:1:USINT", []byte{0xFF, 0x00}, uint64(255)},
+		{"holding-register:1:CHAR", []byte{'A', 0x00}, "A"},
+		{"holding-register:1:WCHAR", []byte{0x00, 'B'}, "B"},
+		{"holding-register:1:BOOL", []byte{0x01, 0x00}, true},
+		{"holding-register:1:INT[3]", []byte{0x00, 0x01, 0x00, 0x02, 0xFF, 0xFF}, []interface{}{int64(1), int64(2), int64(-1)}},
+		{"holding-register:1:BYTE[3]", []byte{0x01, 0x02, 0x03, 0x00}, []interface{}{uint64(1), uint64(2), uint64(3)}},
+		// bits are packed from the lowest bit of the first byte
+		{"coil:1", []byte{0x01}, true},
+		{"discrete-input:1", []byte{0x00}, false},
+		{"coil:1[10]", []byte{0b01000101, 0b10}, []interface{}{true, false, true, false, false, false, true, false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			field := mustParseFields(t, tt.field)[0]
+			got, err := Decode(field, tt.data)
+			if err != nil {
+				t.Fatalf("Decode(%s, %x): %s", tt.field, tt.data, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode(%s, %x) = %#v, want %#v", tt.field, tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeShortResponse(t *testing.T) {
+	tests := []struct {
+		field string
+		data  []byte
+	}{
+		{"holding-register:1:DINT", []byte{0x00, 0x01}},
+		{"holding-register:1:INT[2]", []byte{0x00, 0x01, 0x00}},
+		{"coil:1[9]", []byte{0xFF}},
+	}
+	for _, tt := range tests {
+		field := mustParseFields(t, tt.field)[0]
+		if _, err := Decode(field, tt.data); err == nil || !strings.Contains(err.Error(), "short response") {
+			t.Errorf("Decode(%s, %x) = %v, want a short response error", tt.field, tt.data, err)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		field string
+		value interface{}
+		want  interface{}
+	}{
+		{"holding-register:1:INT", -1234, int64(-1234)},
+		{"holding-register:1:UINT", 65535.0, uint64(65535)},
+		{"holding-register:1:DINT", 2.6, int64(3)},
+		{"holding-register:1:REAL", 10.5, float64(10.5)},
+		{"holding-register:1:LREAL", math.E, math.E},
+		{"holding-register:1:SINT", -5, int64(-5)},
+		{"holding-register:1:BOOL", true, true},
+		{"coil:1", 1.0, true},
+	}
+	for _, tt := range tests {
+		field := mustParseFields(t, tt.field)[0]
+		data, err := Encode(field, tt.value)
+		if err != nil {
+			t.Fatalf("Encode(%s, %v): %s", tt.field, tt.value, err)
+		}
+		got, err := Decode(field, data)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%s, Encode(%v)) = %#v, %v, want %#v", tt.field, tt.value, got, err, tt.want)
+		}
+	}
+	if _, err := Encode(mustParseFields(t, "holding-register:1:INT")[0], 40000); err == nil {
+		t.Errorf("Encode(INT, 40000) succeeded, want an out of range error")
+	}
+}
@@ -0,0 +1,179 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronSearch bounds the search for the next slot of an expression that never matches, like
+// the 30th of February
+const maxCronSearch = 5 * 366 * day
+
+// cronField is the set of values of one field of a cron expression, bit i is value i
+type cronField uint64
+
+func (f cronField) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// cron is a parsed cron expression, its slots are wall-clock times in loc
+type cron struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	// anyDay is set if day of month or day of week is unrestricted, it starts with `*` or
+	// covers all days. Otherwise a day matches if either field matches, like in the classic cron.
+	anyDay bool
+	loc    *time.Location
+}
+
+// cronMacros are the nicknames of common expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+	dayNames   = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+)
+
+// Cron parses a cron expression with the five fields minute, hour, day of month, month and
+// day of week, e.g. `0 6,14,22 * * *` for 06:00, 14:00 and 22:00 every day, or one of the
+// macros @yearly, @monthly, @weekly, @daily and @hourly. Fields are `*`, values, ranges like
+// `1-5`, steps like `*/15` or `0-30/10`, and lists of those. Months and days of the week also
+// accept their English three letter names, Sunday is 0 or 7. Slots are wall-clock times in loc.
+func Cron(expr string, loc *time.Location) (Schedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression %q, found %d", expr, len(fields))
+	}
+	c := &cron{loc: loc}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dayOfWeek, err = parseCronField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dayOfWeek.has(7) {
+		c.dayOfWeek |= 1
+	}
+	c.anyDay = unrestricted(fields[2], c.dayOfMonth, 1, 31) || unrestricted(fields[4], c.dayOfWeek, 0, 6)
+	return c, nil
+}
+
+// unrestricted reports whether a field starts with `*`, like `*/2`, or covers all values
+// from min to max, like `*/1` or `1-31`
+func unrestricted(field string, f cronField, min, max int) bool {
+	if strings.HasPrefix(field, "*") {
+		return true
+	}
+	for v := min; v <= max; v++ {
+		if !f.has(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCronField parses a comma separated list of `*`, values, ranges and steps within [min, max]
+func parseCronField(field string, min, max int, names map[string]int) (cronField, error) {
+	var f cronField
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// `a/n` runs from a to the end of the range
+				hi = max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("expected a value from %d to %d, found %q", min, max, s)
+	}
+	return v, nil
+}
+
+func (c *cron) matchesDay(t time.Time) bool {
+	dayOfMonth, dayOfWeek := c.dayOfMonth.has(t.Day()), c.dayOfWeek.has(int(t.Weekday()))
+	if c.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first slot after t, or the zero time if there is none within five years
+func (c *cron) Next(t time.Time) time.Time {
+	local := t.In(c.loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute()+1, 0, 0, c.loc)
+	limit := t.Add(maxCronSearch)
+	for next.Before(limit) {
+		switch {
+		case !next.After(t):
+			// time.Date picked the earlier of a wall-clock time that occurs twice as the clocks
+			// were set back
+			next = next.Add(time.Minute)
+		case !c.month.has(int(next.Month())):
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.matchesDay(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, c.loc)
+		case !c.hour.has(next.Hour()):
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, c.loc)
+		case !c.minute.has(next.Minute()):
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour(), next.Minute()+1, 0, 0, c.loc)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s is not available: %s", name, err)
+	}
+	return loc
+}
+
+func mustParseTime(t *testing.T, loc *time.Location, value string) time.Time {
+	t.Helper()
+	v, err := time.ParseInLocation("2006-01-02 15:04:05", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// checkSlots checks the slots a schedule returns one after another from the time from
+func checkSlots(t *testing.T, s Schedule, loc *time.Location, from string, want []string) {
+	t.Helper()
+	slot := mustParseTime(t, loc, from)
+	for _, w := range want {
+		slot = s.Next(slot)
+		if w == "" {
+			if !slot.IsZero() {
+				t.Errorf("Next = %s, want none", slot)
+			}
+			return
+		}
+		if wantSlot := mustParseTime(t, loc, w); !slot.Equal(wantSlot) {
+			t.Errorf("Next = %s, want %s", slot, wantSlot)
+			return
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expr string
+		from string
+		want []string
+	}{
+		{"*/15 * * * *", "2026-10-19 10:07:30", []string{"2026-10-19 10:15:00", "2026-10-19 10:30:00"}},
+		{"@hourly", "2026-10-19 10:00:00", []string{"2026-10-19 11:00:00", "2026-10-19 12:00:00"}},
+		{"@daily", "2026-12-31 23:59:59", []string{"2027-01-01 00:00:00", "2027-01-02 00:00:00"}},
+		{"@monthly", "2026-10-19 00:00:00", []string{"2026-11-01 00:00:00", "2026-12-01 00:00:00"}},
+		{"0-30/10 8 * * *", "2026-10-19 08:05:00", []string{"2026-10-19 08:10:00", "2026-10-19 08:20:00", "2026-10-19 08:30:00", "2026-10-20 08:00:00"}},
+		{"45/5 * * * *", "2026-10-19 08:50:00", []string{"2026-10-19 08:55:00", "2026-10-19 09:45:00"}},
+		// the end of every shift on weekdays, from a Friday night to the Monday
+		{"0 6,14,22 * * 1-5", "2026-10-16 23:00:00", []string{"2026-10-19 06:00:00", "2026-10-19 14:00:00", "2026-10-19 22:00:00"}},
+		{"0 0 * JAN-MAR mon", "2026-10-19 00:00:00", []string{"2027-01-04 00:00:00", "2027-01-11 00:00:00"}},
+		// Sunday is 0 or 7
+		{"0 0 * * 7", "2026-10-19 00:00:00", []string{"2026-10-25 00:00:00", "2026-11-01 00:00:00"}},
+		{"0 0 * * SUN", "2026-10-19 00:00:00", []string{"2026-10-25 00:00:00", "2026-11-01 00:00:00"}},
+		// restricted day of month and day of week: either matches, the 13th is a Sunday
+		{"0 0 13 * 5", "2026-12-01 00:00:00", []string{"2026-12-04 00:00:00", "2026-12-11 00:00:00", "2026-12-13 00:00:00", "2026-12-18 00:00:00"}},
+		// an unrestricted day of month or day of week: both must match
+		{"0 0 * * 5", "2026-12-01 00:00:00", []string{"2026-12-04 00:00:00", "2026-12-11 00:00:00"}},
+		{"0 0 */1 * 5", "2026-12-01 00:00:00", []string{"2026-12-04 00:00:00", "2026-12-11 00:00:00", "2026-12-18 00:00:00"}},
+		{"0 0 1-31 * 5", "2026-12-01 00:00:00", []string{"2026-12-04 00:00:00", "2026-12-11 00:00:00", "2026-12-18 00:00:00"}},
+		{"0 0 */2 * 5", "2026-12-01 00:00:00", []string{"2026-12-11 00:00:00", "2026-12-25 00:00:00"}},
+		{"0 0 13 * *", "2026-12-01 00:00:00", []string{"2026-12-13 00:00:00", "2027-01-13 00:00:00"}},
+		{"0 0 13 * 0-6", "2026-12-01 00:00:00", []string{"2026-12-13 00:00:00", "2027-01-13 00:00:00"}},
+		// leap days only
+		{"0 0 29 2 *", "2026-10-19 00:00:00", []string{"2028-02-29 00:00:00", "2032-02-29 00:00:00"}},
+		{"0 0 30 2 *", "2026-10-19 00:00:00", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Cron(tt.expr, time.UTC)
+			if err != nil {
+				t.Fatalf("Cron(%q): %s", tt.expr, err)
+			}
+			checkSlots(t, s, time.UTC, tt.from, tt.want)
+		})
+	}
+}
+
+func TestCronNextDaylightSaving(t *testing.T) {
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	s, err := Cron("30 1,2 * * *", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 02:30 occurs twice when the clocks are set back from 03:00 to 02:00, it is one slot
+	checkSlots(t, s, berlin, "2026-10-24 12:00:00", []string{"2026-10-25 01:30:00", "2026-10-25 02:30:00", "2026-10-26 01:30:00"})
+
+	s, err = Cron("0 */6 * * *", berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the slots keep their time of day across the change
+	checkSlots(t, s, berlin, "2026-03-28 19:00:00", []string{"2026-03-29 00:00:00", "2026-03-29 06:00:00", "2026-03-29 12:00:00"})
+}
+
+func TestCronErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"* * * *", "expected 5 fields"},
+		{"* * * * * *", "expected 5 fields"},
+		{"@every", "expected 5 fields"},
+		{"60 * * * *", "minute: expected a value from 0 to 59"},
+		{"* 24 * * *", "hour: expected a value from 0 to 23"},
+		{"* * 0 * *", "day of month: expected a value from 1 to 31"},
+		{"* * * 13 *", "month: expected a value from 1 to 12"},
+		{"* * * * 8", "day of week: expected a value from 0 to 7"},
+		{"* * * FOO *", "month: expected a value from 1 to 12"},
+		{"*/0 * * * *", "minute: invalid step"},
+		{"*/x * * * *", "minute: invalid step"},
+		{"30-10 * * * *", "minute: invalid range"},
+		{"1,,2 * * * *", "minute: expected a value"},
+		{"-5 * * * *", "minute: expected a value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := Cron(tt.expr, time.UTC)
+			if err == nil {
+				t.Fatalf("Cron(%q) succeeded, want %q", tt.expr, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Cron(%q) = %q, want %q", tt.expr, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestEvery(t *testing.T) {
+	s, err := Every(15*time.Minute, 0, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSlots(t, s, time.UTC, "2026-10-19 23:37:12", []string{"2026-10-19 23:45:00", "2026-10-20 00:00:00", "2026-10-20 00:15:00"})
+
+	s, err = Every(8*time.Hour, 6*time.Hour, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSlots(t, s, time.UTC, "2026-10-19 03:00:00", []string{"2026-10-19 06:00:00", "2026-10-19 14:00:00", "2026-10-19 22:00:00", "2026-10-20 06:00:00"})
+
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	s, err = Every(6*time.Hour, 0, berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the slots keep their time of day across daylight saving time changes
+	checkSlots(t, s, berlin, "2026-10-24 19:00:00", []string{"2026-10-25 00:00:00", "2026-10-25 06:00:00", "2026-10-25 12:00:00"})
+}
+
+func TestEveryErrors(t *testing.T) {
+	tests := []struct {
+		every, offset time.Duration
+		err           string
+	}{
+		{0, 0, "does not divide a day"},
+		{-time.Hour, 0, "does not divide a day"},
+		{7 * time.Minute, 0, "does not divide a day"},
+		{25 * time.Hour, 0, "does not divide a day"},
+		{time.Hour, time.Hour, "is not less than the interval"},
+		{time.Hour, -time.Minute, "is not less than the interval"},
+	}
+	for _, tt := range tests {
+		_, err := Every(tt.every, tt.offset, time.UTC)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Every(%s, %s) = %v, want %q", tt.every, tt.offset, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package schedule computes the slots of scan schedules that follow the wall clock rather
+// than the time a stream started: slots aligned to boundaries of the day, like every quarter
+// hour, and cron expressions, like the end of every shift.
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule is a series of slots in time
+type Schedule interface {
+	// Next returns the first slot after t
+	Next(t time.Time) time.Time
+}
+
+const day = 24 * time.Hour
+
+// aligned is a slot every interval since midnight, shifted by offset
+type aligned struct {
+	every  time.Duration
+	offset time.Duration
+	loc    *time.Location
+}
+
+// Every returns slots every interval, aligned to midnight in loc and shifted by offset: every
+// 15 minutes is :00, :15, :30 and :45 of every hour, every 8 hours with an offset of 6 hours
+// is 06:00, 14:00 and 22:00. The interval must divide a day and the offset must be less than
+// the interval. Slots are wall-clock times, so they keep their time of day across daylight
+// saving time changes.
+func Every(every, offset time.Duration, loc *time.Location) (Schedule, error) {
+	if every <= 0 || day%every != 0 {
+		return nil, fmt.Errorf("interval %s does not divide a day", every)
+	}
+	if offset < 0 || offset >= every {
+		return nil, fmt.Errorf("offset %s is not less than the interval %s", offset, every)
+	}
+	return &aligned{every: every, offset: offset, loc: loc}, nil
+}
+
+func (a *aligned) Next(t time.Time) time.Time {
+	local := t.In(a.loc)
+	year, month, dayOfMonth := local.Date()
+	hour, min, sec := local.Clock()
+	sinceMidnight := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute +
+		time.Duration(sec)*time.Second + time.Duration(local.Nanosecond())
+	slot := (sinceMidnight-a.offset)/a.every + 1
+	if sinceMidnight < a.offset {
+		slot = 0
+	}
+	for {
+		// time.Date normalizes the seconds into the wall clock of the day, or of the next one
+		d := a.offset + slot*a.every
+		next := time.Date(year, month, dayOfMonth, 0, 0, int(d/time.Second), int(d%time.Second), a.loc)
+		if next.After(t) {
+			return next
+		}
+		slot++
+	}
+}
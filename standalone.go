@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nutanix/kps-connector-go-sdk/transport"
+	"github.com/nutanix/kps-connector-go-template/connector"
+)
+
+var (
+	// streamFile is the stream file of the standalone mode, empty when running under KPS
+	streamFile string
+	// output is where the streams of the standalone mode publish their messages
+	output string
+)
+
+// runStandalone runs the streams of streamFile without KPS and without the grpc server, until
+// SIGTERM or SIGINT is received
+func runStandalone() {
+	log.Printf("%s - Start standalone with %s", connector.ConnectorCfg.Name, streamFile)
+
+	tclt, closer, err := newStandaloneTransport(output)
+	if err != nil {
+		log.Fatalf("failed to open output %s: %s", output, err)
+	}
+	defer closer.Close()
+
+	file, err := connector.LoadStreamFile(streamFile)
+	if err != nil {
+		log.Fatalf("failed to load streams: %s", err)
+	}
+
+	signals := notifyShutdownSignals()
+	srv := connector.NewConnector()
+	srv.SetTransportClient(tclt)
+	if err := srv.ApplyStreamFile(context.Background(), file); err != nil {
+		log.Fatalf("failed to start streams: %s", err)
+	}
+	log.Printf("started %d streams, publishing to %s", len(file.Streams), output)
+
+	httpServer := newHTTPServer(srv)
+	shutdownOnSignal(signals, nil, httpServer, srv)
+	log.Printf("%s - Stopped", connector.ConnectorCfg.Name)
+}
+
+// newStandaloneTransport returns the transport client for an output: `stdout`, `file:<path>`
+// or a `nats://` URL. The closer releases the output once the streams are stopped.
+func newStandaloneTransport(output string) (transport.Client, io.Closer, error) {
+	switch {
+	case output == "stdout":
+		return connector.NewWriterTransport(os.Stdout), nopCloser{}, nil
+	case strings.HasPrefix(output, "file:"):
+		f, err := os.OpenFile(strings.TrimPrefix(output, "file:"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return connector.NewWriterTransport(f), f, nil
+	case strings.HasPrefix(output, "nats://"), strings.HasPrefix(output, "tls://"):
+		tclt, err := connector.NewNATSTransport(output)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tclt, nopCloser{}, nil
+	}
+	return nil, nil, fmt.Errorf("expected stdout, file:<path> or a nats:// URL")
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,367 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize bounds the ended spans waiting for export, spans ended while it is full are dropped
+	queueSize = 2048
+	// batchSize is the number of spans that triggers an export before batchInterval elapsed
+	batchSize = 512
+	// batchInterval is the maximum time an ended span waits for export
+	batchInterval = 5 * time.Second
+	// otlpTimeout bounds an export to the OTLP endpoint
+	otlpTimeout = 10 * time.Second
+)
+
+// exporter sends batches of ended spans to their destination
+type exporter interface {
+	export(ctx context.Context, spans []*Span) error
+}
+
+var (
+	processorMtx sync.RWMutex
+	processor    *batchProcessor
+)
+
+func currentExporter() *batchProcessor {
+	processorMtx.RLock()
+	defer processorMtx.RUnlock()
+	return processor
+}
+
+// defaultOTLPEndpoint is the OTLP/HTTP endpoint of a collector running next to the connector
+const defaultOTLPEndpoint = "http://localhost:4318"
+
+// Setup starts exporting spans: kind `stdout` writes them as JSON lines to stdout, `otlp` sends
+// them to the OTLP/HTTP endpoint, http://localhost:4318 if empty, and `none` or "" records no
+// spans at all. The returned function flushes the queued spans and stops the export.
+func Setup(kind, endpoint, serviceName string) (func(context.Context) error, error) {
+	var exp exporter
+	switch strings.ToLower(kind) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exp = &writerExporter{w: os.Stdout}
+	case "otlp":
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+		exp = newOTLPExporter(endpoint, serviceName)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected none, stdout or otlp", kind)
+	}
+
+	p := &batchProcessor{
+		exporter: exp,
+		queue:    make(chan *Span, queueSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+	processorMtx.Lock()
+	processor = p
+	processorMtx.Unlock()
+	return p.shutdown, nil
+}
+
+func export(span *Span) {
+	if p := currentExporter(); p != nil {
+		p.enqueue(span)
+	}
+}
+
+// batchProcessor exports the ended spans in batches, so that ending a span never blocks on
+// the exporter
+type batchProcessor struct {
+	exporter exporter
+	queue    chan *Span
+	stop     chan struct{}
+	stopped  chan struct{}
+
+	mtx     sync.Mutex
+	dropped int
+}
+
+func (p *batchProcessor) enqueue(span *Span) {
+	select {
+	case p.queue <- span:
+	default:
+		p.mtx.Lock()
+		p.dropped++
+		p.mtx.Unlock()
+	}
+}
+
+func (p *batchProcessor) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case span := <-p.queue:
+			batch = append(batch, span)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-p.stop:
+			// drain what was ended before the shutdown
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+			}
+			p.flush(batch)
+			return
+		}
+		p.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (p *batchProcessor) flush(batch []*Span) {
+	p.mtx.Lock()
+	dropped := p.dropped
+	p.dropped = 0
+	p.mtx.Unlock()
+	if dropped > 0 {
+		log.Printf("dropped %d spans, the export queue was full", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	if err := p.exporter.export(ctx, batch); err != nil {
+		log.Printf("failed to export %d spans: %s", len(batch), err)
+	}
+}
+
+// shutdown stops recording spans and exports the queued ones, unless ctx is done first
+func (p *batchProcessor) shutdown(ctx context.Context) error {
+	processorMtx.Lock()
+	if processor == p {
+		processor = nil
+	}
+	processorMtx.Unlock()
+
+	close(p.stop)
+	select {
+	case <-p.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writerExporter writes every span as a JSON line
+type writerExporter struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+type spanLine struct {
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	Start        string                 `json:"start"`
+	DurationMs   float64                `json:"durationMs"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+var kindNames = map[SpanKind]string{
+	KindInternal: "internal",
+	KindServer:   "server",
+	KindClient:   "client",
+	KindProducer: "producer",
+}
+
+func (e *writerExporter) export(ctx context.Context, spans []*Span) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		span.mtx.Lock()
+		line := spanLine{
+			TraceID:    hex.EncodeToString(span.sc.TraceID[:]),
+			SpanID:     hex.EncodeToString(span.sc.SpanID[:]),
+			Name:       span.name,
+			Kind:       kindNames[span.kind],
+			Start:      span.start.UTC().Format(time.RFC3339Nano),
+			DurationMs: float64(span.end.Sub(span.start)) / float64(time.Millisecond),
+		}
+		if span.parent != (SpanID{}) {
+			line.ParentSpanID = hex.EncodeToString(span.parent[:])
+		}
+		if len(span.attributes) > 0 {
+			line.Attributes = make(map[string]interface{}, len(span.attributes))
+			for _, attribute := range span.attributes {
+				line.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+		if span.err != nil {
+			line.Error = span.err.Error()
+		}
+		span.mtx.Unlock()
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// otlpExporter posts the spans to an OTLP/HTTP endpoint in the JSON encoding of the
+// ExportTraceServiceRequest
+type otlpExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+}
+
+func newOTLPExporter(endpoint, serviceName string) *otlpExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &otlpExporter{url: url, serviceName: serviceName, client: &http.Client{Timeout: otlpTimeout}}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpStatusError is STATUS_CODE_ERROR
+const otlpStatusError = 2
+
+func toOTLPAttribute(attribute Attribute) otlpAttribute {
+	a := otlpAttribute{Key: attribute.Key}
+	switch v := attribute.Value.(type) {
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		a.Value.IntValue = &s
+	case float64:
+		a.Value.DoubleValue = &v
+	case bool:
+		a.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
+
+func (e *otlpExporter) export(ctx context.Context, spans []*Span) error {
+	scopeSpans := otlpScopeSpans{Scope: otlpScope{Name: "github.com/nutanix/kps-connector-go-template/tracing"}}
+	for _, span := range spans {
+		span.mtx.Lock()
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.sc.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.sc.SpanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parent != (SpanID{}) {
+			s.ParentSpanID = hex.EncodeToString(span.parent[:])
+		}
+		for _, attribute := range span.attributes {
+			s.Attributes = append(s.Attributes, toOTLPAttribute(attribute))
+		}
+		if span.err != nil {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.err.Error()}
+		}
+		span.mtx.Unlock()
+		scopeSpans.Spans = append(scopeSpans.Spans, s)
+	}
+
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{toOTLPAttribute(String("service.name", e.serviceName))}},
+		ScopeSpans: []otlpScopeSpans{scopeSpans},
+	}}}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded %s: %s", e.url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
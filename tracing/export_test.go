@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter keeps the batches it exported
+type recordingExporter struct {
+	mtx     sync.Mutex
+	batches [][]string
+}
+
+func (e *recordingExporter) export(ctx context.Context, spans []*Span) error {
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.name)
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.batches = append(e.batches, names)
+	return nil
+}
+
+// exported returns the number of spans exported so far
+func (e *recordingExporter) exported() int {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	n := 0
+	for _, batch := range e.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+func newTestProcessor(exp exporter, size int) *batchProcessor {
+	return &batchProcessor{
+		exporter: exp,
+		queue:    make(chan *Span, size),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func TestBatchProcessorFlushesOnShutdown(t *testing.T) {
+	exp := &recordingExporter{}
+	p := newTestProcessor(exp, queueSize)
+	go p.run()
+	for _, name := range []string{"poll", "read", "publish"} {
+		p.enqueue(&Span{name: name})
+	}
+	// the spans wait for batchInterval, the shutdown exports them right away
+	if err := p.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.batches) != 1 || len(exp.batches[0]) != 3 || exp.batches[0][0] != "poll" {
+		t.Errorf("exported %q, want poll, read and publish in one batch", exp.batches)
+	}
+}
+
+func TestBatchProcessorExportsFullBatches(t *testing.T) {
+	exp := &recordingExporter{}
+	p := newTestProcessor(exp, queueSize)
+	go p.run()
+	defer func() { _ = p.shutdown(context.Background()) }()
+	for i := 0; i < batchSize; i++ {
+		p.enqueue(&Span{name: "read"})
+	}
+	deadline := time.Now().Add(time.Second)
+	for exp.exported() != batchSize {
+		if time.Now().After(deadline) {
+			t.Fatalf("exported %d spans before batchInterval, want a batch of %d", exp.exported(), batchSize)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchProcessorDropsWhenQueueFull(t *testing.T) {
+	exp := &recordingExporter{}
+	// the processor doesn't run yet, so the queue fills up
+	p := newTestProcessor(exp, 2)
+	for i := 0; i < 5; i++ {
+		p.enqueue(&Span{name: "read"})
+	}
+	p.mtx.Lock()
+	dropped := p.dropped
+	p.mtx.Unlock()
+	if dropped != 3 {
+		t.Errorf("dropped %d spans, want 3", dropped)
+	}
+
+	go p.run()
+	if err := p.shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := exp.exported(); n != 2 {
+		t.Errorf("exported %d spans, want the 2 queued", n)
+	}
+	// the flush reported the dropped spans
+	if p.dropped != 0 {
+		t.Errorf("dropped = %d after the flush, want 0", p.dropped)
+	}
+}
+
+func TestBatchProcessorShutdownDeadline(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	p := newTestProcessor(blockingExporter(block), queueSize)
+	go p.run()
+	p.enqueue(&Span{name: "read"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("shutdown = %v, want %s", err, context.DeadlineExceeded)
+	}
+}
+
+// blockingExporter blocks every export until block is closed
+type blockingExporter chan struct{}
+
+func (e blockingExporter) export(ctx context.Context, spans []*Span) error {
+	<-e
+	return nil
+}
+
+func TestSetupExporters(t *testing.T) {
+	shutdown, err := Setup("none", "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Enabled() {
+		t.Error("spans are recorded with the none exporter")
+	}
+	_ = shutdown(context.Background())
+	if _, err := Setup("jaeger", "", "test"); err == nil {
+		t.Error("Setup accepted an unknown exporter")
+	}
+}
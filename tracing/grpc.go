@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor records a server span for every unary call. It continues the trace
+// of the `traceparent` metadata of the call, if the client sent one.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("traceparent"); len(values) > 0 {
+				ctx = ContextWithRemoteParent(ctx, values[0])
+			}
+		}
+		service, method := splitMethod(info.FullMethod)
+		ctx, span := Start(ctx, info.FullMethod, KindServer,
+			String("rpc.system", "grpc"),
+			String("rpc.service", service),
+			String("rpc.method", method),
+		)
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		span.SetAttributes(String("rpc.grpc.status_code", status.Code(err).String()))
+		span.RecordError(err)
+		return resp, err
+	}
+}
+
+// splitMethod splits `/package.Service/Method` into its service and method
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "", fullMethod
+}
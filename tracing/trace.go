@@ -0,0 +1,203 @@
+// Package tracing records spans in the OpenTelemetry data model and exports them to stdout or
+// to an OTLP/HTTP endpoint. Trace context is propagated in the W3C `traceparent` format.
+//
+// Spans are only recorded once Setup installed an exporter. Until then Start returns a nil
+// *Span, whose methods are no-ops, so instrumented code costs next to nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// SpanContext is the part of a span that is propagated to its children, locally or in
+// `traceparent`
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether the trace and span IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent formats the span context as a W3C `traceparent` of a sampled span
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceParent parses a W3C `traceparent`, it returns false if it is malformed
+func ParseTraceParent(traceParent string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceParent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.IsValid()
+}
+
+// SpanKind is the OTLP kind of a span
+type SpanKind int
+
+// The OTLP span kinds used by the connector
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindProducer SpanKind = 4
+)
+
+// Attribute is a key and a string, int64, float64 or bool value
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute { return Attribute{key, value} }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute { return Attribute{key, int64(value)} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return Attribute{key, value} }
+
+// Span is an operation of a trace. A nil *Span is valid and records nothing.
+type Span struct {
+	mtx        sync.Mutex
+	name       string
+	kind       SpanKind
+	sc         SpanContext
+	parent     SpanID
+	start      time.Time
+	end        time.Time
+	attributes []Attribute
+	err        error
+	ended      bool
+}
+
+type spanContextKey struct{}
+
+// Enabled reports whether spans are recorded
+func Enabled() bool {
+	return currentExporter() != nil
+}
+
+// Start starts a span as a child of the span in ctx, or of the remote span context of ctx, and
+// returns a context holding it
+func Start(ctx context.Context, name string, kind SpanKind, attributes ...Attribute) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		parent:     parent.SpanID,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	span.sc.TraceID = parent.TraceID
+	if !parent.IsValid() {
+		span.sc.TraceID = newTraceID()
+	}
+	span.sc.SpanID = newSpanID()
+	return context.WithValue(ctx, spanContextKey{}, span.sc), span
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// RecordError marks the span as failed with err, a nil err is ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.err = err
+}
+
+// End ends the span and queues it for export, ending it again has no effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	if s.ended {
+		s.mtx.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mtx.Unlock()
+	export(s)
+}
+
+// SpanContext returns the span context of the span, it is invalid for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SpanContextFromContext returns the span context of the current span of ctx
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteParent returns a context whose spans continue the trace of a remote
+// `traceparent`. Malformed values are ignored.
+func ContextWithRemoteParent(ctx context.Context, traceParent string) context.Context {
+	sc, ok := ParseTraceParent(traceParent)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// TraceParent returns the `traceparent` of the current span of ctx, or "" if there is none
+func TraceParent(ctx context.Context) string {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceParent()
+}
+
+func newTraceID() (id TraceID) {
+	_, _ = rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	_, _ = rand.Read(id[:])
+	return id
+}